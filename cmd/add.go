@@ -19,7 +19,57 @@ func getCTime(path string) (int, int, error) {
     return sec, nsec, nil
 }
 
+// indexEntryFromFile builds the index entry for a worktree file whose blob
+// is sha, filling in the stat data used to notice later changes.
+func indexEntryFromFile(abspath string, relPath string, sha string) (utils.GitIndexEntry, error) {
+	// a symlink's own stat data, not its target's
+	stat, err := os.Lstat(abspath)
+	if err != nil {
+		return utils.GitIndexEntry{}, err
+	}
+
+	ctimeS, ctimeNs, _ := getCTime(abspath)
+
+	mtimeS := uint32(stat.ModTime().Unix())
+	mtimeNs := uint32(stat.ModTime().Nanosecond())
+
+	devIno, _ := utils.GetDevIno(abspath)
+	GidUid := utils.GetGidUid(abspath)
+
+	return utils.GitIndexEntry {
+		Ctime: [2]uint32{uint32(ctimeS), uint32(ctimeNs)},
+		Mtime: [2]uint32{mtimeS, mtimeNs},
+		Dev: uint32(devIno.Dev),
+		Ino: uint32(devIno.Ino),
+		ModeType: 0b1000,
+		ModePerms: 0o644,
+		UID: GidUid.Uid,
+		GID: GidUid.Gid,
+		Size: uint32(stat.Size()),
+		SHA: sha,
+		AssumeValid: false,
+		Stage: 0,
+		Name: relPath,
+	}, nil
+}
+
 func add(repo utils.Repo, paths []string, del bool, skipMissing bool) {
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		utils.ErrorHandler("error reading index", err)
+		return
+	}
+
+	// entries the fsmonitor daemon vouches for don't need to be hashed again
+	useFsmonitor := utils.FsmonitorRefresh(repo, index)
+	refreshed := index
+	unchanged := make(map[string]utils.GitIndexEntry)
+	for _, e := range index.Entries {
+		if e.FsmonitorValid {
+			unchanged[e.Name] = e
+		}
+	}
+
 	rm(repo, paths, true, false)
 
 	worktree := repo.Worktree + string(os.PathSeparator)
@@ -42,41 +92,40 @@ func add(repo utils.Repo, paths []string, del bool, skipMissing bool) {
 		cleanPaths = append(cleanPaths, pair{abspath: abspath, relPath: relPathGit})
 	}
 
-	index, err := utils.IndexRead(repo)
-	utils.ErrorHandler("error reading index", err)
+	index, err = utils.IndexRead(repo)
+	if err != nil {
+		utils.ErrorHandler("error reading index", err)
+		return
+	}
+	// rm wrote the index as it was before the refresh, so what the daemon
+	// reported since the old token has to be carried over with the new one
+	index.FsmonitorToken = refreshed.FsmonitorToken
+	index.Untracked = refreshed.Untracked
+	for i, e := range index.Entries {
+		_, valid := unchanged[e.Name]
+		index.Entries[i].FsmonitorValid = valid
+	}
 
 	for _, path := range cleanPaths {
-		fd, _ := os.Open(path.abspath)
-		sha := objectHash(repo, fd, "blob")
+		if entry, ok := unchanged[path.relPath]; ok {
+			index.Entries = append(index.Entries, entry)
+			continue
+		}
 
-		stat, err := os.Stat(path.abspath)
+		fd, err := os.Open(path.abspath)
 		if err != nil {
-			fmt.Printf("error reading file: %v\n", stat.Name())
+			fmt.Printf("error reading file: %v\n", path.relPath)
+			continue
 		}
+		sha := objectHash(repo, fd, "blob")
+		fd.Close()
 
-		ctimeS, ctimeNs, _ := getCTime(path.abspath)
-		
-		mtimeS := uint32(stat.ModTime().Unix())
-		mtimeNs := uint32(stat.ModTime().Nanosecond())
-
-		devIno, _ := utils.GetDevIno(path.abspath)
-		GidUid := utils.GetGidUid(path.abspath)
-
-		entry := utils.GitIndexEntry {
-			Ctime: [2]uint32{uint32(ctimeS), uint32(ctimeNs)},
-			Mtime: [2]uint32{mtimeS, mtimeNs},
-			Dev: uint32(devIno.Dev),
-			Ino: uint32(devIno.Ino),
-			ModeType: 0b1000,
-			ModePerms: 0o644,
-			UID: GidUid.Uid,
-			GID: GidUid.Gid,
-			Size: uint32(stat.Size()),
-			SHA: sha,
-			AssumeValid: false,
-			Stage: 0,
-			Name: path.relPath,
+		entry, err := indexEntryFromFile(path.abspath, path.relPath, sha)
+		if err != nil {
+			fmt.Printf("error reading file: %v\n", path.relPath)
+			continue
 		}
+		entry.FsmonitorValid = useFsmonitor
 
 		index.Entries = append(index.Entries, entry)
 	}
@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// headBranch returns the branch HEAD points at, or "" when HEAD is detached.
+func headBranch(repo utils.Repo) string {
+	return strings.TrimPrefix(utils.HeadBranch(repo), "refs/heads/")
+}
+
+func branchList(repo utils.Repo, dir string) (names []string, shas map[string]string) {
+	shas = make(map[string]string)
+	for ref, sha := range utils.ListRefs(repo, dir+"/") {
+		shas[strings.TrimPrefix(ref, dir+"/")] = sha
+	}
+
+	for name := range shas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, shas
+}
+
+// branchMerged tells whether commit is reachable from target.
+func branchMerged(repo utils.Repo, commit string, target string) bool {
+	seen := make(map[string]bool)
+	queue := []string{target}
+
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+
+		if sha == commit {
+			return true
+		}
+		if sha == "" || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+
+		if c, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit); ok {
+			queue = append(queue, c.Data.GetAll("parent")...)
+		}
+	}
+	return false
+}
+
+func branchSubject(repo utils.Repo, sha string) string {
+	commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+	if !ok {
+		return ""
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Data.Message), "\n")
+	return subject
+}
+
+func branchShow(repo utils.Repo, local bool, remote bool, verbose bool) {
+	current := headBranch(repo)
+
+	show := func(display string, sha string, isCurrent bool) {
+		marker := " "
+		if isCurrent {
+			marker = "*"
+		}
+
+		if verbose {
+			fmt.Printf("%s %s %s %s\n", marker, display, sha[:7], branchSubject(repo, sha))
+		} else {
+			fmt.Printf("%s %s\n", marker, display)
+		}
+	}
+
+	if current == "" && local {
+		if head := utils.ResolveRef(repo, "HEAD"); head != "" {
+			show(fmt.Sprintf("(HEAD detached at %s)", head[:7]), head, true)
+		}
+	}
+
+	if local {
+		names, shas := branchList(repo, "refs/heads")
+		for _, name := range names {
+			show(name, shas[name], name == current)
+		}
+	}
+
+	if remote {
+		names, shas := branchList(repo, "refs/remotes")
+		for _, name := range names {
+			display := name
+			if local {
+				display = "remotes/" + name
+			}
+			show(display, shas[name], false)
+		}
+	}
+}
+
+func branchCreate(repo utils.Repo, name string, start string, force bool) error {
+	if !utils.CheckRefFormat("refs/heads/" + name) {
+		return fmt.Errorf("'%v' is not a valid branch name", name)
+	}
+
+	if utils.ResolveRef(repo, "refs/heads/"+name) != "" && !force {
+		return fmt.Errorf("a branch named '%v' already exists", name)
+	}
+	if force && name == headBranch(repo) {
+		return fmt.Errorf("cannot force update the current branch")
+	}
+
+	sha := utils.ObjectFind(repo, start, "commit", true)
+	if sha == "" {
+		return fmt.Errorf("not a valid object name: '%v'", start)
+	}
+
+	return utils.UpdateRef(repo, "refs/heads/"+name, sha, "branch: Created from "+start)
+}
+
+func branchDelete(repo utils.Repo, name string, force bool) error {
+	sha := utils.ResolveRef(repo, "refs/heads/"+name)
+	if sha == "" {
+		return fmt.Errorf("branch '%v' not found", name)
+	}
+
+	if name == headBranch(repo) {
+		return fmt.Errorf("cannot delete branch '%v' checked out at '%v'", name, repo.Worktree)
+	}
+
+	if !force {
+		head := utils.ResolveRef(repo, "HEAD")
+		if !branchMerged(repo, sha, head) {
+			return fmt.Errorf("the branch '%v' is not fully merged.\nif you are sure you want to delete it, run 'branch -D %v'", name, name)
+		}
+	}
+
+	// the branch must still be where it was checked to be merged; the
+	// transaction takes its reflog with it
+	t := utils.NewRefTransaction(repo)
+	t.Delete("refs/heads/"+name, sha, "")
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	utils.ConfigRemoveSection(repo, fmt.Sprintf("branch \"%s\"", name))
+
+	fmt.Printf("Deleted branch %v (was %v).\n", name, sha[:7])
+	return nil
+}
+
+func branchRename(repo utils.Repo, oldName string, newName string, force bool) error {
+	sha := utils.ResolveRef(repo, "refs/heads/"+oldName)
+	if sha == "" {
+		return fmt.Errorf("no branch named '%v'", oldName)
+	}
+	if !utils.CheckRefFormat("refs/heads/" + newName) {
+		return fmt.Errorf("'%v' is not a valid branch name", newName)
+	}
+	if utils.ResolveRef(repo, "refs/heads/"+newName) != "" && !force {
+		return fmt.Errorf("a branch named '%v' already exists", newName)
+	}
+
+	// the reflog moves along with the branch, before the transaction would
+	// delete it with the old ref
+	if err := utils.ReflogRename(repo, "refs/heads/"+oldName, "refs/heads/"+newName); err != nil {
+		return err
+	}
+
+	// creating the new ref and deleting the old one, loose or packed, happen
+	// together or not at all
+	existing := utils.ResolveRef(repo, "refs/heads/"+newName)
+	if existing == "" {
+		existing = utils.ZeroSha
+	}
+	t := utils.NewRefTransaction(repo)
+	t.Update("refs/heads/"+newName, sha, existing,
+		fmt.Sprintf("Branch: renamed refs/heads/%s to refs/heads/%s", oldName, newName))
+	t.Delete("refs/heads/"+oldName, sha, "")
+	if err := t.Commit(); err != nil {
+		utils.ReflogRename(repo, "refs/heads/"+newName, "refs/heads/"+oldName)
+		return err
+	}
+
+	if headBranch(repo) == oldName {
+		if err := utils.WriteSymbolicRef(repo, "HEAD", "refs/heads/"+newName, ""); err != nil {
+			return err
+		}
+	}
+
+	return utils.ConfigRenameSection(repo, fmt.Sprintf("branch \"%s\"", oldName), fmt.Sprintf("branch \"%s\"", newName))
+}
+
+// branchSetUpstream records upstream (a remote-tracking branch like
+// origin/main, or a local branch) as what name tracks.
+func branchSetUpstream(repo utils.Repo, name string, upstream string) error {
+	if utils.ResolveRef(repo, "refs/heads/"+name) == "" {
+		return fmt.Errorf("branch '%v' does not exist", name)
+	}
+
+	var remote, merge string
+	if utils.ResolveRef(repo, "refs/heads/"+upstream) != "" {
+		remote = "."
+		merge = "refs/heads/" + upstream
+	} else if utils.ResolveRef(repo, "refs/remotes/"+upstream) != "" {
+		var branch string
+		remote, branch, _ = strings.Cut(upstream, "/")
+		merge = "refs/heads/" + branch
+	} else {
+		return fmt.Errorf("the requested upstream branch '%v' does not exist", upstream)
+	}
+
+	section := fmt.Sprintf("branch \"%s\"", name)
+	if err := utils.ConfigSet(repo, section, "remote", remote); err != nil {
+		return err
+	}
+	if err := utils.ConfigSet(repo, section, "merge", merge); err != nil {
+		return err
+	}
+
+	fmt.Printf("branch '%v' set up to track '%v'.\n", name, upstream)
+	return nil
+}
+
+var branchCmd = &cobra.Command{
+	Use:   "branch [-a|-r] [-v] | BRANCH [START] | -d|-D BRANCH... | -m [OLD] NEW | --set-upstream-to UPSTREAM [BRANCH]",
+	Short: "list, create, delete or rename branches",
+	Long: `with no arguments the local branches are listed, the current one marked with *.
+	BRANCH [START] creates a branch at START (default HEAD), -d deletes a branch merged into HEAD,
+	-D deletes it anyway, -m renames a branch along with its reflog and config, and
+	--set-upstream-to records the branch tracked through branch.<name>.remote/merge.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		del, _ := cmd.Flags().GetBool("delete")
+		forceDel, _ := cmd.Flags().GetBool("force-delete")
+		move, _ := cmd.Flags().GetBool("move")
+		force, _ := cmd.Flags().GetBool("force")
+		all, _ := cmd.Flags().GetBool("all")
+		remotes, _ := cmd.Flags().GetBool("remotes")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		upstream, _ := cmd.Flags().GetString("set-upstream-to")
+		unsetUpstream, _ := cmd.Flags().GetBool("unset-upstream")
+
+		var err error
+		switch {
+		case del || forceDel:
+			if len(args) == 0 {
+				fmt.Print("Usage: branch -d|-D BRANCH...\n")
+				return
+			}
+			for _, name := range args {
+				if e := branchDelete(repo, name, forceDel || force); e != nil {
+					fmt.Printf("error: %v\n", e)
+				}
+			}
+
+		case move:
+			switch len(args) {
+			case 1:
+				current := headBranch(repo)
+				if current == "" {
+					fmt.Print("HEAD is detached, name the branch to rename\n")
+					return
+				}
+				err = branchRename(repo, current, args[0], force)
+			case 2:
+				err = branchRename(repo, args[0], args[1], force)
+			default:
+				fmt.Print("Usage: branch -m [OLD] NEW\n")
+				return
+			}
+
+		case upstream != "" || unsetUpstream:
+			name := headBranch(repo)
+			if len(args) > 0 {
+				name = args[0]
+			}
+			if name == "" {
+				fmt.Print("HEAD is detached, name the branch\n")
+				return
+			}
+
+			if unsetUpstream {
+				section := fmt.Sprintf("branch \"%s\"", name)
+				utils.ConfigUnset(repo, section, "remote")
+				err = utils.ConfigUnset(repo, section, "merge")
+			} else {
+				err = branchSetUpstream(repo, name, upstream)
+			}
+
+		case len(args) > 0:
+			start := "HEAD"
+			if len(args) > 1 {
+				start = args[1]
+			}
+			err = branchCreate(repo, args[0], start, force)
+
+		default:
+			branchShow(repo, !remotes || all, remotes || all, verbose)
+		}
+
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(branchCmd)
+
+	branchCmd.Flags().BoolP("delete", "d", false, "delete a branch, which must be merged into HEAD")
+	branchCmd.Flags().BoolP("force-delete", "D", false, "delete a branch even if it isn't merged")
+	branchCmd.Flags().BoolP("move", "m", false, "rename a branch along with its reflog")
+	branchCmd.Flags().BoolP("force", "f", false, "reset an existing branch, or overwrite it when renaming")
+	branchCmd.Flags().BoolP("all", "a", false, "list both local and remote-tracking branches")
+	branchCmd.Flags().BoolP("remotes", "r", false, "list the remote-tracking branches")
+	branchCmd.Flags().BoolP("verbose", "v", false, "show the sha and subject of each branch head")
+	branchCmd.Flags().StringP("set-upstream-to", "u", "", "set the upstream of BRANCH (default the current one)")
+	branchCmd.Flags().Bool("unset-upstream", false, "remove the upstream of BRANCH (default the current one)")
+}
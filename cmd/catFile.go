@@ -18,8 +18,12 @@ var catFileCmd = &cobra.Command{
 		}
 
 		repo := utils.RepoFind(".", true)
-		obj := utils.ObjectRead(repo, utils.ObjectFind(repo, args[1], args[0], true))
+		sha := utils.ObjectFind(repo, args[1], args[0], true)
+		if sha == "" {
+			return
+		}
 
+		obj := utils.ObjectRead(repo, sha)
 		if obj == nil {
 			return
 		}
@@ -42,6 +42,21 @@ func gitignoreParse(lines []string) []utils.Rule {
 	return ret
 }
 
+func gitignoreGlobalPath() string {
+	var configHome string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		configHome = xdg
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = path.Join(homeDir, ".config")
+	}
+
+	return filepath.Join(configHome, "git", "ignore")
+}
+
 func gitignoreRead(repo utils.Repo) (*utils.GitIgnore, error){
 	ret := &utils.GitIgnore{
 		Absolute: [][]utils.Rule{}, 
@@ -83,18 +98,7 @@ func gitignoreRead(repo utils.Repo) (*utils.GitIgnore, error){
 		ret.Absolute = append(ret.Absolute, gitignoreParse(lines))
 	}
 	
-	var configHome string
-	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
-		configHome = xdg
-	} else {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get home user directory: %w", err)
-		}
-		configHome = path.Join(homeDir, ".config")
-	}
-
-	globalFile := filepath.Join(configHome, "git", "ignore")
+	globalFile := gitignoreGlobalPath()
 	if  lines, err := readLines(globalFile); err != nil {
 		return nil, err
 	} else if lines != nil {
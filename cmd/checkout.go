@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/Duck-005/wannagit/utils"
@@ -28,25 +29,230 @@ func checkoutTree(repo utils.Repo, tree *utils.GitTree, path string) {
 	} 
 }
 
-var checkoutCmd = &cobra.Command{
-	Use:   "checkout COMMIT DIRECTORY",
-	Short: "checkout a commit inside of an empty directory",
-	Long: `ensure the directory is empty before running the command`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) < 2 {
-			fmt.Print("usage: checkout COMMIT DIRECTORY\n")
+// checkoutWorktreeSha hashes a worktree file without storing the blob, ""
+// when the file is missing. a symlink hashes as its target, like git stores
+// it.
+func checkoutWorktreeSha(repo utils.Repo, name string) string {
+	abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+	if stat, err := os.Lstat(abspath); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(abspath)
+		if err != nil {
+			return ""
+		}
+		blob := utils.GitBlob{}
+		blob.Deserialize(target)
+		return utils.ObjectWrite(&blob, utils.Repo{})
+	}
+
+	file, err := os.Open(abspath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	return objectHash(utils.Repo{}, file, "blob")
+}
+
+// checkoutTreeEntries lists the files of the tree of rev by path, with
+// their modes.
+func checkoutTreeEntries(repo utils.Repo, rev string) (map[string]utils.GitIndexEntry, error) {
+	tree := utils.ObjectFind(repo, rev, "tree", true)
+	if tree == "" {
+		return nil, fmt.Errorf("%v has no tree", rev)
+	}
+	entries, err := utils.TreeIndexEntries(repo, tree)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]utils.GitIndexEntry, len(entries))
+	for _, e := range entries {
+		files[e.Name] = e
+	}
+	return files, nil
+}
+
+// checkoutSameEntry tells whether two entries, either of them missing, hold
+// the same blob with the same mode.
+func checkoutSameEntry(x utils.GitIndexEntry, xok bool, y utils.GitIndexEntry, yok bool) bool {
+	if !xok || !yok {
+		return xok == yok
+	}
+	return x.SHA == y.SHA && x.ModeType == y.ModeType && x.ModePerms == y.ModePerms
+}
+
+// checkoutSwitch moves the worktree and index from the HEAD tree to the tree
+// of commit, refusing to touch files with changes that would be lost. a
+// change of mode alone, like the exec bit, counts as a change.
+func checkoutSwitch(repo utils.Repo, commit string) error {
+	current := make(map[string]utils.GitIndexEntry)
+	if utils.ResolveRef(repo, "HEAD") != "" {
+		var err error
+		if current, err = checkoutTreeEntries(repo, "HEAD"); err != nil {
+			return err
+		}
+	}
+
+	target, err := checkoutTreeEntries(repo, commit)
+	if err != nil {
+		return err
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]utils.GitIndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		staged[e.Name] = e
+	}
+
+	changed := make(map[string]bool)
+	for name, e := range current {
+		if t, ok := target[name]; !checkoutSameEntry(e, true, t, ok) {
+			changed[name] = true
+		}
+	}
+	for name, e := range target {
+		if c, ok := current[name]; !checkoutSameEntry(c, ok, e, true) {
+			changed[name] = true
+		}
+	}
+
+	var dirty []string
+	for name := range changed {
+		s, inIndex := staged[name]
+		c, inCurrent := current[name]
+		t, inTarget := target[name]
+
+		// the index and worktree must match HEAD, or already match the target
+		if !checkoutSameEntry(s, inIndex, c, inCurrent) && !checkoutSameEntry(s, inIndex, t, inTarget) {
+			dirty = append(dirty, name)
+		} else if worktree := checkoutWorktreeSha(repo, name); worktree != s.SHA && worktree != t.SHA {
+			dirty = append(dirty, name)
+		}
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("your local changes to the following files would be overwritten by checkout:\n\t%s",
+			strings.Join(dirty, "\n\t"))
+	}
+
+	var kept []utils.GitIndexEntry
+	for _, e := range index.Entries {
+		if !changed[e.Name] {
+			kept = append(kept, e)
+		}
+	}
+
+	for name := range changed {
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		e, ok := target[name]
+
+		if !ok {
+			if os.Remove(abspath) == nil {
+				checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+			}
+			continue
+		}
+
+		if err := mergeWorktreeFile(repo, abspath, e.SHA, e.ModeType, e.ModePerms); err != nil {
+			return err
+		}
+		entry, err := indexEntryFromFile(abspath, name, e.SHA)
+		if err != nil {
+			return err
+		}
+		entry.ModeType, entry.ModePerms = e.ModeType, e.ModePerms
+		kept = append(kept, entry)
+	}
+
+	index.Entries = kept
+	return utils.IndexWrite(repo, *index)
+}
+
+// checkoutRemoveEmptyDirs removes the directories emptied by checkout, up to
+// the worktree root.
+func checkoutRemoveEmptyDirs(repo utils.Repo, dir string) {
+	for dir != repo.Worktree && strings.HasPrefix(dir, repo.Worktree) {
+		if os.Remove(dir) != nil {
 			return
 		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// checkoutShortName is how HEAD is named in the reflog: the branch, or the
+// sha it is detached at.
+func checkoutShortName(repo utils.Repo) string {
+	if branch := utils.HeadBranch(repo); branch != "" {
+		return strings.TrimPrefix(branch, "refs/heads/")
+	}
+	return utils.ResolveRef(repo, "HEAD")
+}
+
+// checkout switches to a branch, attaching HEAD to it, or to any other
+// commit, detaching HEAD.
+func checkout(repo utils.Repo, name string, detach bool) error {
+	commit := utils.ObjectFind(repo, name, "commit", true)
+	if commit == "" {
+		return fmt.Errorf("reference is not a commit: %v", name)
+	}
+
+	old := utils.ResolveRef(repo, "HEAD")
+	if old == "" {
+		old = utils.ZeroSha
+	}
+
+	if err := checkoutSwitch(repo, commit); err != nil {
+		return err
+	}
+
+	from := checkoutShortName(repo)
+	message := fmt.Sprintf("checkout: moving from %s to %s", from, name)
+
+	branch := "refs/heads/" + name
+	if !detach && utils.ResolveRef(repo, branch) != "" {
+		if err := utils.WriteSymbolicRef(repo, "HEAD", branch, message); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to branch '%v'\n", name)
+	} else {
+		if err := utils.DetachHead(repo, commit, message); err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %v\n", commit[:7])
+	}
 
+	// the 1 tells the hook a branch was checked out, rather than files
+	return utils.RunHook(repo, "post-checkout", "", old, commit, "1")
+}
+
+var checkoutCmd = &cobra.Command{
+	Use:   "checkout [--detach] BRANCH|COMMIT | COMMIT DIRECTORY",
+	Short: "switch branches, or checkout a commit inside of an empty directory",
+	Long: `with one argument the worktree and index are switched to BRANCH, attaching HEAD to it, or to COMMIT,
+	detaching HEAD. local changes to files that differ between the two commits stop the switch.
+	with a DIRECTORY the commit is written there instead, ensure the directory is empty before running the command`,
+	Run: func(cmd *cobra.Command, args []string) {
 		repo := utils.RepoFind(".", true)
-		obj := utils.ObjectRead(repo, args[0])
 
-		if obj.Format() == "commit" {
-			commit := obj.(*utils.GitCommit)
-			obj = utils.ObjectRead(repo, commit.GetData()["tree"][0])
+		if len(args) == 1 {
+			detach, _ := cmd.Flags().GetBool("detach")
+			if err := checkout(repo, args[0], detach); err != nil {
+				fmt.Printf("error: %v\n", err)
+			}
+			return
 		}
 
-		tree, ok := obj.(*utils.GitTree)
+		if len(args) != 2 {
+			fmt.Print("usage: checkout [--detach] BRANCH|COMMIT | COMMIT DIRECTORY\n")
+			return
+		}
+		sha := utils.ObjectFind(repo, args[0], "tree", true)
+		if sha == "" {
+			fmt.Print("Not a tree object\n")
+			return
+		}
+
+		tree, ok := utils.ObjectRead(repo, sha).(*utils.GitTree)
 		if !ok {
 			fmt.Print("Not a tree object\n")
 			return
@@ -88,4 +294,6 @@ var checkoutCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(checkoutCmd)
+
+	checkoutCmd.Flags().Bool("detach", false, "detach HEAD at the commit even when given a branch")
 }
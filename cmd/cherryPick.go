@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// pickOptions are the flags cherryPick and revert share, kept in the
+// sequencer state while a run is stopped.
+type pickOptions struct {
+	recordOrigin bool // -x
+	mainline     int  // -m, the parent to diff a merge against
+	noCommit     bool // -n
+}
+
+// pickCommits resolves the commits to apply, in the order they go in. a
+// range is walked oldest first for a cherry-pick and newest first for a
+// revert, so that each change applies on top of the one before.
+func pickCommits(repo utils.Repo, args []string, oldestFirst bool) ([]string, error) {
+	ranged := false
+	for _, arg := range args {
+		if strings.Contains(arg, "..") || strings.HasPrefix(arg, "^") || arg == "--not" {
+			ranged = true
+		}
+	}
+
+	if !ranged {
+		var shas []string
+		for _, arg := range args {
+			sha, err := revListCommit(repo, arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad revision '%v'", arg)
+			}
+			shas = append(shas, sha)
+		}
+		return shas, nil
+	}
+
+	walk := utils.NewRevWalk(repo)
+	if _, _, err := revListSetup(repo, walk, args); err != nil {
+		return nil, err
+	}
+	shas, err := walk.Walk()
+	if err != nil {
+		return nil, err
+	}
+	if oldestFirst {
+		for i, j := 0, len(shas)-1; i < j; i, j = i+1, j-1 {
+			shas[i], shas[j] = shas[j], shas[i]
+		}
+	}
+	if len(shas) == 0 {
+		return nil, fmt.Errorf("empty commit set passed")
+	}
+	return shas, nil
+}
+
+// pickSubject is the first line of a commit message.
+func pickSubject(message string) string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n")
+	return subject
+}
+
+var pickTrailerRE = regexp.MustCompile(`^([A-Za-z0-9-]+:\s|\(cherry picked from commit )`)
+
+// pickHasTrailers tells whether the message ends in a block of trailers,
+// "Signed-off-by: ..." lines, that -x can add its line to.
+func pickHasTrailers(message string) bool {
+	paragraphs := strings.Split(strings.TrimRight(message, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return false
+	}
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if !pickTrailerRE.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickMessage gives the message of the commit made from sha: its own for a
+// cherry-pick, "Revert ..." for a revert.
+func pickMessage(item utils.SequencerItem, commit *utils.GitCommit, parent string, opts pickOptions) string {
+	message := commit.Data.Message
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	if item.Command == "pick" {
+		if opts.recordOrigin {
+			if !pickHasTrailers(message) {
+				message += "\n"
+			}
+			message += "(cherry picked from commit " + item.Sha + ")\n"
+		}
+		return message
+	}
+
+	subject := pickSubject(message)
+	title := fmt.Sprintf("Revert \"%s\"", subject)
+	if inner, ok := strings.CutPrefix(subject, "Revert \""); ok && strings.HasSuffix(inner, "\"") {
+		title = fmt.Sprintf("Reapply \"%s\"", strings.TrimSuffix(inner, "\""))
+	}
+
+	body := fmt.Sprintf("This reverts commit %s.\n", item.Sha)
+	if opts.mainline > 0 {
+		body = fmt.Sprintf("This reverts commit %s, reversing\nchanges made to %s.\n", item.Sha, parent)
+	}
+	return title + "\n\n" + body
+}
+
+// pickAction is what git calls the command in its messages.
+func pickAction(command string) string {
+	if command == "revert" {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// pickApply applies one commit onto HEAD as a three-way merge: a pick takes
+// the changes from its parent to it, a revert the changes back. it returns
+// false when it stopped for conflicts or because nothing changed, leaving
+// CHERRY_PICK_HEAD or REVERT_HEAD and MERGE_MSG for commit.
+func pickApply(repo utils.Repo, item utils.SequencerItem, opts pickOptions) (bool, error) {
+	commit, ok := utils.ObjectRead(repo, item.Sha).(*utils.GitCommit)
+	if !ok {
+		return false, fmt.Errorf("bad revision '%v'", item.Sha)
+	}
+	action := pickAction(item.Command)
+
+	parents := commit.Data.GetAll("parent")
+	var parent string
+	switch {
+	case len(parents) > 1 && opts.mainline == 0:
+		return false, fmt.Errorf("commit %s is a merge but no -m option was given.", item.Sha)
+	case len(parents) <= 1 && opts.mainline > 0:
+		return false, fmt.Errorf("mainline was specified but commit %s is not a merge.", item.Sha)
+	case opts.mainline > len(parents):
+		return false, fmt.Errorf("commit %s does not have parent %d", item.Sha, opts.mainline)
+	case opts.mainline > 0:
+		parent = parents[opts.mainline-1]
+	case len(parents) == 1:
+		parent = parents[0]
+	}
+
+	commitTree, err := utils.PeelTo(repo, item.Sha, "tree")
+	if err != nil {
+		return false, err
+	}
+	parentTree := ""
+	if parent != "" {
+		if parentTree, err = utils.PeelTo(repo, parent, "tree"); err != nil {
+			return false, err
+		}
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return false, err
+	}
+
+	// -n works on top of whatever is staged, not HEAD
+	head := utils.ResolveRef(repo, "HEAD")
+	ours, oursTree := head, utils.EmptyTreeSha
+	if head != "" {
+		if oursTree, err = utils.PeelTo(repo, head, "tree"); err != nil {
+			return false, err
+		}
+	}
+	if opts.noCommit && len(mergeUnmerged(*index)) == 0 {
+		oursTree = utils.TreeFromIndex(repo, *index)
+		ours = oursTree
+	}
+
+	label := fmt.Sprintf("%s (%s)", item.Sha[:7], pickSubject(commit.Data.Message))
+	mergeOpts := utils.MergeOptionsFor(repo, "HEAD", label)
+	base, theirs := parentTree, commitTree
+	mergeOpts.Base = "parent of " + label
+	if item.Command == "revert" {
+		base, theirs = commitTree, parentTree
+		mergeOpts.Base, mergeOpts.Theirs = label, "parent of "+label
+	}
+
+	m, err := utils.MergeTrees(repo, base, oursTree, theirs, mergeOpts)
+	if err != nil {
+		return false, err
+	}
+	if err := mergeApply(repo, ours, m, action); err != nil {
+		return false, err
+	}
+	for _, line := range m.Messages {
+		fmt.Println(line)
+	}
+
+	message := pickMessage(item, commit, parent, opts)
+	if !m.Clean() {
+		conflicts := "\n# Conflicts:\n"
+		for _, name := range m.Conflicts {
+			conflicts += "#\t" + name + "\n"
+		}
+		if err := pickStop(repo, item, message+conflicts, opts); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if opts.noCommit {
+		return true, nil
+	}
+
+	tree := m.Tree(repo)
+	if tree == oursTree {
+		if err := pickStop(repo, item, message, opts); err != nil {
+			return false, err
+		}
+		fmt.Printf("The previous %s is now empty, possibly due to conflict resolution.\n"+
+			"If you wish to commit it anyway, use:\n\n    commit --allow-empty\n\n"+
+			"Otherwise, please use '%s --skip'\n", action, pickCommand(item.Command))
+		return false, nil
+	}
+
+	// a pick keeps the author of the commit, a revert is the user's own
+	var original utils.Ident
+	if item.Command == "pick" {
+		original, _ = utils.ParseIdent(commit.Data.Get("author"))
+	}
+	author, err := utils.AuthorIdent(repo, original)
+	if err != nil {
+		return false, err
+	}
+	committer, err := utils.CommitterIdent(repo)
+	if err != nil {
+		return false, err
+	}
+
+	var newParents []string
+	if head != "" {
+		newParents = append(newParents, head)
+	}
+	sha, err := utils.CommitCreate(repo, tree, newParents, author, committer, message, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := utils.UpdateRef(repo, "HEAD", sha, action+": "+pickSubject(message)); err != nil {
+		return false, fmt.Errorf("couldn't update HEAD: %v", err)
+	}
+	fmt.Printf("created commit: %v\n", sha)
+	return true, nil
+}
+
+// pickStop leaves what commit needs to conclude a stopped pick: the message
+// in MERGE_MSG and, unless -n, the commit in CHERRY_PICK_HEAD or REVERT_HEAD.
+func pickStop(repo utils.Repo, item utils.SequencerItem, message string, opts pickOptions) error {
+	path, err := utils.RepoFile(repo, false, "MERGE_MSG")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(message), 0644); err != nil {
+		return err
+	}
+	if opts.noCommit {
+		return nil
+	}
+	return utils.PickHeadWrite(repo, item.Sha, pickAction(item.Command))
+}
+
+// pickCommand is the wannagit command for a todo command.
+func pickCommand(command string) string {
+	if command == "revert" {
+		return "revert"
+	}
+	return "cherryPick"
+}
+
+// pickRun applies the todo in order. when one stops, what is left is saved
+// for --continue, unless it was the only commit of the run.
+func pickRun(repo utils.Repo, state *utils.SequencerState) {
+	opts := pickOptions{recordOrigin: state.RecordOrigin, mainline: state.Mainline, noCommit: state.NoCommit}
+	single := len(state.Todo) == 1 && !utils.SequencerInProgress(repo)
+
+	save := func() {
+		if single {
+			return
+		}
+		if err := utils.SequencerWrite(repo, state); err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+	}
+
+	for len(state.Todo) > 0 {
+		item := state.Todo[0]
+		action := pickAction(item.Command)
+
+		done, err := pickApply(repo, item, opts)
+		if err != nil {
+			// the commit wasn't applied, --continue tries it again
+			save()
+			fmt.Printf("error: %v\nfatal: %s failed\n", err, action)
+			os.Exit(128)
+		}
+		if !done {
+			// with -n there is nothing to commit, the next --continue moves on
+			if opts.noCommit {
+				state.Todo = state.Todo[1:]
+			}
+			save()
+
+			verb := "apply"
+			if item.Command == "revert" {
+				verb = "revert"
+			}
+			command := pickCommand(item.Command)
+			commit, _ := utils.ObjectRead(repo, item.Sha).(*utils.GitCommit)
+			if index, err := utils.IndexRead(repo); err == nil && len(mergeUnmerged(*index)) > 0 {
+				fmt.Printf("error: could not %s %s... %s\n", verb, item.Sha[:7], pickSubject(commit.Data.Message))
+				if opts.noCommit {
+					fmt.Print("hint: after resolving the conflicts, mark the corrected paths\n" +
+						"hint: with 'add <paths>' or 'rm <paths>'\n")
+					os.Exit(1)
+				}
+				fmt.Printf("hint: After resolving the conflicts, mark them with\n"+
+					"hint: \"add/rm <pathspec>\", then run\n"+
+					"hint: \"%[1]s --continue\".\n"+
+					"hint: You can instead skip this commit with \"%[1]s --skip\".\n"+
+					"hint: To abort and get back to the state before \"%[1]s\",\n"+
+					"hint: run \"%[1]s --abort\".\n", command)
+			}
+			os.Exit(1)
+		}
+		state.Todo = state.Todo[1:]
+	}
+
+	utils.SequencerClear(repo)
+}
+
+// pickMain runs cherryPick and revert: a new run over the commits in args,
+// or --continue, --skip or --abort of the one that stopped.
+func pickMain(cmd *cobra.Command, args []string, command string) {
+	repo := utils.RepoFind(".", true)
+
+	cont, _ := cmd.Flags().GetBool("continue")
+	skip, _ := cmd.Flags().GetBool("skip")
+	abort, _ := cmd.Flags().GetBool("abort")
+	name := pickCommand(command)
+
+	var state *utils.SequencerState
+	if utils.SequencerInProgress(repo) {
+		var err error
+		if state, err = utils.SequencerRead(repo); err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+	}
+	pickHead, _ := utils.PickHead(repo)
+
+	switch {
+	case cont || skip || abort:
+		if state == nil && pickHead == "" {
+			fmt.Println("error: no cherry-pick or revert in progress")
+			os.Exit(128)
+		}
+
+		if abort {
+			if err := mergeAbort(repo); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			// back to where the run started, dropping what it committed
+			if state != nil && state.Head != "" && utils.ResolveRef(repo, "HEAD") != state.Head {
+				if err := checkoutSwitch(repo, state.Head); err != nil {
+					fmt.Printf("error: %v\n", err)
+					os.Exit(128)
+				}
+				if err := utils.UpdateRef(repo, "HEAD", state.Head, "reset: moving to "+state.Head); err != nil {
+					fmt.Printf("fatal: couldn't update HEAD: %v\n", err)
+					os.Exit(128)
+				}
+			}
+			utils.SequencerClear(repo)
+			return
+		}
+
+		if skip {
+			if err := mergeAbort(repo); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			if state != nil && len(state.Todo) > 0 && (pickHead == "" || strings.HasPrefix(pickHead, state.Todo[0].Sha)) {
+				state.Todo = state.Todo[1:]
+			}
+		}
+
+		if cont && pickHead != "" {
+			// commit drops the commit from the todo once it is made
+			commitCmd.Run(commitCmd, nil)
+			if state != nil {
+				if state, _ = utils.SequencerRead(repo); state == nil {
+					return
+				}
+			}
+		}
+
+		if state != nil {
+			pickRun(repo, state)
+		}
+		return
+	}
+
+	if len(args) == 0 {
+		fmt.Printf("usage: %s [-n] [-m <parent>] <commit>... | --continue | --skip | --abort\n", name)
+		os.Exit(129)
+	}
+	if state != nil || pickHead != "" {
+		fmt.Printf("error: a cherry-pick or revert is already in progress\n"+
+			"hint: try \"%s (--continue | --skip | --abort)\"\n", name)
+		os.Exit(128)
+	}
+
+	opts := pickOptions{}
+	opts.recordOrigin, _ = cmd.Flags().GetBool("x")
+	opts.mainline, _ = cmd.Flags().GetInt("mainline")
+	opts.noCommit, _ = cmd.Flags().GetBool("no-commit")
+
+	shas, err := pickCommits(repo, args, command == "pick")
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	state = &utils.SequencerState{
+		Head:         utils.ResolveRef(repo, "HEAD"),
+		RecordOrigin: opts.recordOrigin,
+		Mainline:     opts.mainline,
+		NoCommit:     opts.noCommit,
+	}
+	for _, sha := range shas {
+		subject := ""
+		if commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit); ok {
+			subject = pickSubject(commit.Data.Message)
+		}
+		state.Todo = append(state.Todo, utils.SequencerItem{Command: command, Sha: sha, Subject: subject})
+	}
+	pickRun(repo, state)
+}
+
+var cherryPickCmd = &cobra.Command{
+	Use:   "cherryPick [-x] [-n] [-m <parent>] <commit>... | --continue | --skip | --abort",
+	Short: "apply the changes introduced by some existing commits",
+	Long: `applies the change each commit made to its parent onto HEAD, as a three-way merge, and commits it with the
+original author and message. a range like A..B picks its commits oldest first.
+when a commit conflicts the run stops with markers in the worktree; resolve them, add the files and run
+--continue, or give up on the commit with --skip or on the whole run with --abort.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pickMain(cmd, args, "pick")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cherryPickCmd)
+
+	cherryPickCmd.Flags().BoolP("x", "x", false, "add a \"(cherry picked from commit ...)\" line to the message")
+	cherryPickCmd.Flags().BoolP("no-commit", "n", false, "apply the changes to the index and worktree without committing")
+	cherryPickCmd.Flags().IntP("mainline", "m", 0, "for a merge, the parent number (from 1) to take the changes against")
+	cherryPickCmd.Flags().Bool("continue", false, "commit the resolved commit and go on with the rest")
+	cherryPickCmd.Flags().Bool("skip", false, "drop the commit that stopped and go on with the rest")
+	cherryPickCmd.Flags().Bool("abort", false, "give up, going back to HEAD as it was before the run")
+}
@@ -1,253 +1,741 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"math"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
+	"regexp"
 	"sort"
 	"strings"
-	"time"
 
-	"gopkg.in/ini.v1"
 	"github.com/Duck-005/wannagit/utils"
 	"github.com/spf13/cobra"
 )
 
-func expandUserHome(path string) string {
-	if !strings.HasPrefix(path, "~/") {
-		return path
+// commitFindAuthor looks for an author matching pattern among the commits
+// reachable from any ref, for --author without an email.
+func commitFindAuthor(repo utils.Repo, pattern string) (utils.Ident, bool) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return utils.Ident{}, false
+	}
+
+	walk := utils.NewRevWalk(repo)
+	refs := utils.ListRefs(repo, "refs/")
+	for _, name := range utils.SortedRefNames(refs) {
+		if sha, err := utils.PeelTo(repo, refs[name], "commit"); err == nil {
+			walk.Push(sha)
+		}
+	}
+	if head := utils.ResolveRef(repo, "HEAD"); head != "" {
+		walk.Push(head)
 	}
 
-	homeDir, err := os.UserHomeDir()
+	commits, err := walk.Walk()
 	if err != nil {
-		return ""
+		return utils.Ident{}, false
 	}
 
-	expandedPath := filepath.Join(homeDir, path[2:])
-	return expandedPath
+	for _, sha := range commits {
+		commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+		if !ok {
+			continue
+		}
+		author, err := utils.ParseIdent(commit.Data.Get("author"))
+		if err == nil && re.MatchString(fmt.Sprintf("%s <%s>", author.Name, author.Email)) {
+			return utils.Ident{Name: author.Name, Email: author.Email}, true
+		}
+	}
+	return utils.Ident{}, false
 }
 
-func gitconfigRead() []string {
-	var configFiles []string
+// commitAuthor works out the author from --author and --date, falling back
+// on base, which --amend sets to the author of the commit it replaces, then
+// on GIT_AUTHOR_* and the config.
+func commitAuthor(repo utils.Repo, authorFlag string, dateFlag string, base utils.Ident) (utils.Ident, error) {
+	override := base
 
-	if runtime.GOOS == "windows" {
-		configFiles = append(configFiles, `C:\ProgramData\Git\config`)
-	} else {
-		configFiles = append(configFiles, "/etc/gitconfig")
+	if authorFlag != "" {
+		ident, ok := utils.ParseNameEmail(authorFlag)
+		if !ok {
+			ident, ok = commitFindAuthor(repo, authorFlag)
+		}
+		if !ok {
+			return utils.Ident{}, fmt.Errorf("--author '%v' is not 'Name <email>' and matches no existing author", authorFlag)
+		}
+		override.Name, override.Email = ident.Name, ident.Email
 	}
 
-	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
-	if xdgConfigHome == "" {
-		configFiles = append(configFiles, expandUserHome("~/.gitconfig"))
-	} else {
-		configFiles = append(configFiles, expandUserHome(filepath.Join(xdgConfigHome, "git/config")))
+	if dateFlag != "" {
+		when, err := utils.ParseDate(dateFlag)
+		if err != nil {
+			return utils.Ident{}, fmt.Errorf("invalid date format: %v", dateFlag)
+		}
+		override.When = when
 	}
 
-	repo := utils.RepoFind(".", true)
-	if repo.Conf == "" {
-		configFiles = append(configFiles, filepath.Join(repo.Worktree, ".git", "config"))
+	return utils.AuthorIdent(repo, override)
+}
+
+// commitReflogMessage gives the "commit: <subject>" reflog message, or the
+// amend, merge or initial variant.
+func commitReflogMessage(repo utils.Repo, sha string, amend bool) string {
+	commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+	if !ok {
+		return "commit"
 	}
 
-	return configFiles
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Data.Message), "\n")
+	_, pickAction := utils.PickHead(repo)
+	switch {
+	case amend:
+		return "commit (amend): " + subject
+	case len(commit.Data.GetAll("parent")) > 1:
+		return "commit (merge): " + subject
+	case pickAction == "cherry-pick":
+		return "commit (cherry-pick): " + subject
+	case !commit.Data.Has("parent"):
+		return "commit (initial): " + subject
+	}
+	return "commit: " + subject
 }
 
-func gitconfigUserGet(repo utils.Repo) string {
-	files := gitconfigRead()
+// commitTrackedChanges lists the tracked files whose worktree copy was
+// modified or deleted since they were staged.
+func commitTrackedChanges(repo utils.Repo, index utils.GitIndex) (modified []string, deleted []string) {
+	for _, entry := range index.Entries {
+		fullPath := filepath.Join(repo.Worktree, filepath.FromSlash(entry.Name))
 
-	var configPath string
-	for _, f := range files {
-		if _, err := os.Stat(f); err == nil {
-			configPath = f
+		stat, err := os.Stat(fullPath)
+		if errors.Is(err, os.ErrNotExist) {
+			deleted = append(deleted, entry.Name)
+			continue
+		}
+		if err != nil || stat.IsDir() {
+			continue
 		}
-	}
 
-	config, _ := utils.RepoFile(repo, false, "config")
-	configData, err := ini.Load(config)
-	if err != nil {
-		utils.ErrorHandler(fmt.Sprintf("error reading config file: ", configPath), err)
-		return ""
-	}
-	
-	section := configData.Section("user")
-	name := section.Key("name").String()
-	email := section.Key("email").String()
-	return fmt.Sprintf("%v <%v>", name, email)
+		if stat.ModTime().Unix() != int64(entry.Mtime[0]) ||
+			stat.ModTime().Nanosecond() != int(entry.Mtime[1]) ||
+			stat.Size() != int64(entry.Size) {
+			if checkoutWorktreeSha(repo, entry.Name) != entry.SHA {
+				modified = append(modified, entry.Name)
+			}
+		}
+	}
+	return modified, deleted
 }
 
-type treeEntry struct {
-	gitIndexEntry utils.GitIndexEntry
-	sha string
-	basename string
-}
+// commitStage gives a copy of index with the worktree copy of names staged,
+// as add does for the files still there and rm for the deleted ones. Nothing
+// is written, so the real index stays as it was if the commit is abandoned.
+func commitStage(repo utils.Repo, index utils.GitIndex, names []string) utils.GitIndex {
+	byName := make(map[string]utils.GitIndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		byName[e.Name] = e
+	}
 
-func treeFromIndex(repo utils.Repo, index utils.GitIndex) string {
-	contents := make(map[string] []treeEntry)
+	for _, name := range names {
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		fd, err := os.Open(abspath)
+		if errors.Is(err, os.ErrNotExist) {
+			delete(byName, name)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("error reading file: %v\n", name)
+			continue
+		}
+		sha := objectHash(repo, fd, "blob")
+		fd.Close()
 
-	for _, entry := range index.Entries {
-		dirname := filepath.Dir(entry.Name)
-		
-		key := dirname
-		for key != "" && key != "." && key != string(filepath.Separator){
-			if _, ok := contents[key]; !ok {
-				contents[key] = []treeEntry{}
-			}
+		entry, err := indexEntryFromFile(abspath, name, sha)
+		if err != nil {
+			fmt.Printf("error reading file: %v\n", name)
+			continue
+		}
+		byName[name] = entry
+	}
+
+	staged := index
+	staged.Entries = make([]utils.GitIndexEntry, 0, len(byName))
+	for _, e := range byName {
+		staged.Entries = append(staged.Entries, e)
+	}
+	sort.Slice(staged.Entries, func(i, j int) bool {
+		return staged.Entries[i].Name < staged.Entries[j].Name
+	})
+	return staged
+}
 
-			parent := filepath.Dir(key)
-			if parent == key { // stop if Dir() no longer changes
-				break
+// commitPathspec finds the files known to the index or to the HEAD tree that
+// the paths given to commit name, either directly, as a directory holding
+// them, or as a glob pattern.
+func commitPathspec(repo utils.Repo, index utils.GitIndex, head []utils.GitIndexEntry, paths []string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, e := range index.Entries {
+		known[e.Name] = true
+	}
+	for _, e := range head {
+		known[e.Name] = true
+	}
+
+	matched := make(map[string]bool)
+	for _, p := range paths {
+		abspath, _ := filepath.Abs(p)
+		rel, err := filepath.Rel(repo.Worktree, abspath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%v is outside the worktree", p)
+		}
+		spec := filepath.ToSlash(rel)
+
+		found := false
+		for name := range known {
+			glob, _ := path.Match(spec, name)
+			if spec == "." || name == spec || strings.HasPrefix(name, spec+"/") || glob {
+				matched[name] = true
+				found = true
 			}
-			key = parent
 		}
+		if !found {
+			return nil, fmt.Errorf("pathspec '%v' did not match any file(s) known to wannagit", p)
+		}
+	}
 
-		contents[dirname] = append(contents[dirname], treeEntry{gitIndexEntry: entry})
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names, nil
+}
 
-	sortedPaths := make([]string, 0, len(contents))
-	for k := range contents {
-    	sortedPaths = append(sortedPaths, k)
+// commitOnlyEntries gives the entries of HEAD with only names taken from
+// the staged index, leaving the rest of what is staged out of the commit.
+func commitOnlyEntries(staged utils.GitIndex, head []utils.GitIndexEntry, names []string) []utils.GitIndexEntry {
+	only := make(map[string]bool, len(names))
+	for _, name := range names {
+		only[name] = true
 	}
 
-	sort.Slice(sortedPaths, func(i, j int) bool {
-		return len(sortedPaths[i]) > len(sortedPaths[j]) // > gives reverse order
-	})
+	var entries []utils.GitIndexEntry
+	for _, e := range head {
+		if !only[e.Name] {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range staged.Entries {
+		if only[e.Name] {
+			entries = append(entries, e)
+		}
+	}
 
-	var sha string
-	for _, path := range sortedPaths {
-		tree := utils.GitTree{}
-
-		for _, entry := range contents[path] {
-			var leaf utils.GitTreeLeaf
-			if entry.gitIndexEntry.Name != "" {
-				leafMode := fmt.Sprintf("%02o%04o", entry.gitIndexEntry.ModeType, entry.gitIndexEntry.ModePerms)
-				leaf = utils.GitTreeLeaf{
-					Mode: leafMode,
-					Path: filepath.Base(entry.gitIndexEntry.Name),
-					Sha: entry.gitIndexEntry.SHA,
-				}
-			} else {
-				leaf = utils.GitTreeLeaf{
-					Mode: "040000",
-					Path: entry.basename,
-					Sha: entry.sha,
-				}
-			}
+	return entries
+}
 
-			tree.Items = append(tree.Items, leaf)
+// the line below which --cleanup=scissors drops everything
+const commitScissors = "# ------------------------ >8 ------------------------"
+
+// commitCleanup tidies message for a --cleanup mode. "default" strips the
+// comments when the message went through the editor, and only the
+// surrounding whitespace otherwise.
+func commitCleanup(message string, mode string, edited bool) string {
+	switch mode {
+	case "verbatim":
+		return message
+	case "scissors":
+		if edited {
+			if i := strings.Index(message, commitScissors+"\n"); i == 0 || (i > 0 && message[i-1] == '\n') {
+				message = message[:i]
+			}
 		}
-		sha = utils.ObjectWrite(&tree, repo)
+		return utils.CleanupMessage(message, false)
+	case "whitespace":
+		return utils.CleanupMessage(message, false)
+	}
+	return utils.CleanupMessage(message, mode == "strip" || edited)
+}
+
+// commitStatusComment is the commented summary of what is being committed
+// that goes below the message in the editor.
+func commitStatusComment(repo utils.Repo, mode string, parent []utils.GitIndexEntry, entries []utils.GitIndexEntry, unstaged []string) string {
+	var b strings.Builder
+
+	switch mode {
+	case "scissors":
+		b.WriteString(commitScissors + "\n")
+		b.WriteString("# Do not modify or remove the line above.\n")
+		b.WriteString("# Everything below it will be ignored.\n")
+	case "whitespace", "verbatim":
+		b.WriteString("# Please enter the commit message for your changes. Lines starting\n")
+		b.WriteString("# with '#' will be kept; you may remove them yourself if you want to.\n")
+		b.WriteString("# An empty message aborts the commit.\n")
+	default:
+		b.WriteString("# Please enter the commit message for your changes. Lines starting\n")
+		b.WriteString("# with '#' will be ignored, and an empty message aborts the commit.\n")
+	}
+	b.WriteString("#\n")
+
+	if branch := utils.HeadBranch(repo); branch != "" {
+		fmt.Fprintf(&b, "# On branch %s\n", strings.TrimPrefix(branch, "refs/heads/"))
+	} else {
+		b.WriteString("# HEAD detached\n")
+	}
+	if len(parent) == 0 {
+		b.WriteString("#\n# Initial commit\n#\n")
+	}
 
-		if path != "" {
-			parent := filepath.Dir(path)
-			base := filepath.Base(path)
+	old := make(map[string]string, len(parent))
+	for _, e := range parent {
+		old[e.Name] = e.SHA
+	}
+	var staged []string
+	for _, e := range entries {
+		sha, ok := old[e.Name]
+		switch {
+		case !ok:
+			staged = append(staged, "new file:   "+e.Name)
+		case sha != e.SHA:
+			staged = append(staged, "modified:   "+e.Name)
+		}
+		delete(old, e.Name)
+	}
+	for name := range old {
+		staged = append(staged, "deleted:    "+name)
+	}
+	sort.Slice(staged, func(i, j int) bool {
+		return staged[i][12:] < staged[j][12:]
+	})
 
-			contents[parent] = append(contents[parent], treeEntry{basename: base, sha: sha})
+	if len(staged) > 0 {
+		b.WriteString("# Changes to be committed:\n")
+		for _, line := range staged {
+			fmt.Fprintf(&b, "#\t%s\n", line)
+		}
+		b.WriteString("#\n")
+	}
+	if len(unstaged) > 0 {
+		b.WriteString("# Changes not staged for commit:\n")
+		for _, line := range unstaged {
+			fmt.Fprintf(&b, "#\t%s\n", line)
 		}
+		b.WriteString("#\n")
 	}
+	return b.String()
+}
 
-	return sha
+// commitMessageOptions are the flags the message of a commit comes from.
+type commitMessageOptions struct {
+	messages   []string // -m, each a paragraph
+	file       string   // -F, "-" for stdin
+	template   string   // -t, commit.template when empty
+	reuse      string   // the message of the commit --amend replaces
+	prepared   string   // MERGE_MSG or SQUASH_MSG, left by a merge
+	source     string   // where prepared comes from, "merge" or "squash"
+	edit       bool
+	noEdit     bool
+	cleanup    string
+	allowEmpty bool
+	noVerify   bool // skip the commit-msg hook
 }
 
-func commitCreate(repo utils.Repo, tree string, parent string, author string, timestamp time.Time, message string ) string {
-	commit := utils.GitCommit{
-		Data: make(map[string][]string),
+// commitMessage gets the message from -m, -F, the commit being amended, the
+// merge being concluded or the editor, which opens COMMIT_EDITMSG holding
+// the message or template and status, a summary of the commit. the
+// prepare-commit-msg and commit-msg hooks get to change it on the way.
+func commitMessage(repo utils.Repo, opts commitMessageOptions, status func(mode string) string) (string, error) {
+	mode := opts.cleanup
+	if mode == "" {
+		mode = utils.ConfigGet(repo, "commit", "cleanup")
 	}
-	commit.Data["tree"] = []string{tree}
-	if parent != "" {
-		commit.Data["parent"] = []string{parent}
+	switch mode {
+	case "":
+		mode = "default"
+	case "default", "strip", "whitespace", "verbatim", "scissors":
+	default:
+		return "", fmt.Errorf("invalid cleanup mode %v", mode)
 	}
 
-	message = strings.TrimSpace(message) + "\n"
+	// what the message comes from, as prepare-commit-msg is told
+	var message, template string
+	var source []string
+	switch {
+	case len(opts.messages) > 0 && opts.file != "":
+		return "", fmt.Errorf("option -m cannot be combined with -F")
+
+	case len(opts.messages) > 0:
+		message = strings.Join(opts.messages, "\n\n")
+		source = []string{"message"}
+
+	case opts.file != "":
+		var data []byte
+		var err error
+		if opts.file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(opts.file)
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not read log file '%v': %v", opts.file, err)
+		}
+		message = string(data)
+		source = []string{"message"}
+
+	case opts.reuse != "":
+		message = opts.reuse
+		source = []string{"commit", "HEAD"}
+
+	case opts.prepared != "":
+		message = opts.prepared
+		source = []string{opts.source}
+
+	default:
+		file := opts.template
+		if file == "" {
+			file = utils.ConfigGet(repo, "commit", "template")
+		}
+		if file != "" {
+			data, err := os.ReadFile(utils.ExpandHome(file))
+			if err != nil {
+				return "", fmt.Errorf("could not read '%v': %v", file, err)
+			}
+			message, template = string(data), string(data)
+			source = []string{"template"}
+		}
+	}
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
 
-	ts := time.Now().UTC() 
-	loc, _ := time.LoadLocation("Asia/Kolkata")
-	localTs := ts.In(loc)
-	_, offset := localTs.Zone()
+	given := len(opts.messages) > 0 || opts.file != ""
+	edit := opts.edit || (!given && !opts.noEdit)
 
-	hours := int(math.Floor(float64(offset / 3600)))
-	minutes := int(math.Floor(float64((offset % 3600) / 60)))
-	
-	sign := "+"
-	if offset < 0 {
-		sign = "-"
-		hours = -hours // make hours positive for formatting
-		minutes = -minutes
+	editPath, err := utils.RepoFile(repo, false, "COMMIT_EDITMSG")
+	if err != nil {
+		return "", err
+	}
+	content := message
+	if edit {
+		content += "\n" + status(mode)
+	}
+	if err := os.WriteFile(editPath, []byte(content), 0644); err != nil {
+		return "", err
 	}
 
-	// format as ±HHMM
-	tz := fmt.Sprintf("%s%02d%02d", sign, hours, minutes)
-	epoch := ts.Unix()
-	author = fmt.Sprintf("%s %d %s", author, epoch, tz)
+	if err := utils.RunHook(repo, "prepare-commit-msg", "", append([]string{editPath}, source...)...); err != nil {
+		return "", err
+	}
+	if edit {
+		if err := utils.EditFile(repo, editPath); err != nil {
+			return "", fmt.Errorf("there was a problem with the editor '%v'", utils.Editor(repo))
+		}
+	}
+	if !opts.noVerify {
+		if err := utils.RunHook(repo, "commit-msg", "", editPath); err != nil {
+			return "", err
+		}
+	}
 
-	commit.Data["author"] = []string{author}
-	commit.Data["committer"] = []string{author}
-	commit.Data[""] = []string{message}
+	data, err := os.ReadFile(editPath)
+	if err != nil {
+		return "", err
+	}
+	message = string(data)
 
-	return utils.ObjectWrite(&commit, repo)
+	message = commitCleanup(message, mode, edit)
+	if edit && template != "" && message == commitCleanup(template, mode, true) {
+		return "", fmt.Errorf("Aborting commit; you did not edit the message.")
+	}
+	if message == "" && !opts.allowEmpty {
+		return "", fmt.Errorf("Aborting commit due to empty commit message.")
+	}
+	return message, nil
 }
 
 var commitCmd = &cobra.Command{
-	Use:   "commit -m MESSAGE",
+	Use:   "commit [-a] [--amend] [-m MESSAGE | -F FILE] [<paths>...]",
 	Short: "record changes to the repository",
-	Long: `create a new commit containing the current contents of the index and the given log message describing the changes.`,
+	Long: `create a new commit containing the current contents of the index and the given log message describing the changes.
+without -m or -F the message is written in the editor.
+with paths, only the current worktree contents of those paths are committed, whatever else is staged.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		repo := utils.RepoFind(".", true)
 
-		index, err := utils.IndexRead(repo)
-		utils.ErrorHandler("error in reading index", err)
-		
-		tree := treeFromIndex(repo, *index)
+		all, _ := cmd.Flags().GetBool("all")
+		amend, _ := cmd.Flags().GetBool("amend")
+		allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
+		resetAuthor, _ := cmd.Flags().GetBool("reset-author")
+		msgOpts := commitMessageOptions{}
+		msgOpts.messages, _ = cmd.Flags().GetStringArray("message")
+		msgOpts.file, _ = cmd.Flags().GetString("file")
+		msgOpts.template, _ = cmd.Flags().GetString("template")
+		msgOpts.edit, _ = cmd.Flags().GetBool("edit")
+		msgOpts.noEdit, _ = cmd.Flags().GetBool("no-edit")
+		msgOpts.cleanup, _ = cmd.Flags().GetString("cleanup")
+		msgOpts.allowEmpty, _ = cmd.Flags().GetBool("allow-empty-message")
+		msgOpts.noVerify, _ = cmd.Flags().GetBool("no-verify")
+
+		if all && len(args) > 0 {
+			fmt.Printf("fatal: paths '%v ...' with -a does not make sense\n", args[0])
+			os.Exit(128)
+		}
 
-		message, _ := cmd.Flags().GetString("message")
-		commit := commitCreate(
-			repo, 
-			tree,
-			utils.ObjectFind(repo, "HEAD", "commit", true),
-			gitconfigUserGet(repo),
-			time.Now(),
-			message,
-		)
-		fmt.Printf("created commit: %v", commit)
+		// a merge stopped for conflicts is concluded by the next commit
+		mergeHeads := utils.MergeHeads(repo)
+		if len(mergeHeads) > 0 {
+			if amend {
+				fmt.Println("fatal: You are in the middle of a merge -- cannot amend.")
+				os.Exit(128)
+			}
+			if len(args) > 0 {
+				fmt.Println("fatal: cannot do a partial commit during a merge.")
+				os.Exit(128)
+			}
+		}
 
-		head, _ := os.ReadFile(filepath.Join(repo.Gitdir, "HEAD"))
-		
-		var isActiveBranch bool
-		if strings.HasPrefix(string(head), "ref: refs/heads/") {
-			isActiveBranch = true
-		} else {
-			isActiveBranch = false
+		// empty on an unborn branch, giving a root commit
+		head := utils.ResolveRef(repo, "HEAD")
+		parents := []string{}
+		if head != "" {
+			parents = append(parents, head)
+		}
+		parents = append(parents, mergeHeads...)
+
+		// so is a cherry-pick or revert, which left its message behind
+		pickHead, pickAction := utils.PickHead(repo)
+
+		if data, err := os.ReadFile(filepath.Join(repo.Gitdir, "MERGE_MSG")); err == nil && len(mergeHeads) > 0 {
+			msgOpts.prepared, msgOpts.source = string(data), "merge"
+		} else if err == nil && pickHead != "" {
+			msgOpts.prepared, msgOpts.source = string(data), "message"
+		} else if data, err := os.ReadFile(filepath.Join(repo.Gitdir, "SQUASH_MSG")); err == nil {
+			msgOpts.prepared, msgOpts.source = string(data), "squash"
 		}
 
-		if isActiveBranch {
-			branchPath, err := utils.RepoFile(repo, false, filepath.Join("refs", "heads", string(head[16:len(head)-1])))
-			utils.ErrorHandler("error in reading refs/heads/BRANCH", err)
+		// --amend replaces HEAD, keeping its parents, author and message
+		var base utils.Ident
+		if pickAction == "cherry-pick" && !resetAuthor {
+			if picked, ok := utils.ObjectRead(repo, pickHead).(*utils.GitCommit); ok {
+				base, _ = utils.ParseIdent(picked.Data.Get("author"))
+			}
+		}
+		if amend {
+			if head == "" {
+				fmt.Println("fatal: you have nothing to amend.")
+				os.Exit(128)
+			}
+			old, ok := utils.ObjectRead(repo, head).(*utils.GitCommit)
+			if !ok {
+				fmt.Println("fatal: HEAD is not a commit")
+				os.Exit(128)
+			}
+			parents = old.Data.GetAll("parent")
+			if !resetAuthor {
+				base, _ = utils.ParseIdent(old.Data.Get("author"))
+			}
+			msgOpts.reuse = old.Data.Message
+		}
 
-			fd, err := os.OpenFile(branchPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		var headEntries []utils.GitIndexEntry
+		if head != "" {
+			headTree, err := utils.PeelTo(repo, head, "tree")
+			if err == nil {
+				headEntries, err = utils.TreeIndexEntries(repo, headTree)
+			}
 			if err != nil {
-				utils.ErrorHandler("error updating HEAD", err)
+				utils.ErrorHandler("error reading the HEAD tree", err)
+				return
 			}
-			defer fd.Close()
+		}
 
-			fd.Write([]byte(commit + "\n"))
-			
-		} else {
-			headPath, err := utils.RepoFile(repo, false, "HEAD")
-			utils.ErrorHandler("error in reading HEAD", err)
+		index, err := utils.IndexRead(repo)
+		if err != nil {
+			utils.ErrorHandler("error in reading index", err)
+			return
+		}
+		if len(mergeUnmerged(*index)) > 0 {
+			fmt.Print("error: Committing is not possible because you have unmerged files.\n" +
+				"hint: Fix them up in the work tree, and then use 'add/rm <file>'\n" +
+				"hint: as appropriate to mark resolution and make a commit.\n" +
+				"fatal: Exiting because of an unresolved conflict.\n")
+			os.Exit(128)
+		}
+
+		// what goes in the commit, and the index to write once it's made
+		// when -a or paths stage more
+		var entries []utils.GitIndexEntry
+		var staged *utils.GitIndex
+		switch {
+		case len(args) > 0:
+			names, err := commitPathspec(repo, *index, headEntries, args)
+			if err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+			s := commitStage(repo, *index, names)
+			staged = &s
+			entries = commitOnlyEntries(s, headEntries, names)
+		case all:
+			modified, deleted := commitTrackedChanges(repo, *index)
+			s := commitStage(repo, *index, append(modified, deleted...))
+			staged = &s
+			entries = s.Entries
+		default:
+			entries = index.Entries
+		}
+
+		if !msgOpts.noVerify {
+			if err := utils.RunHook(repo, "pre-commit", ""); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			// the hook may have staged more, which a pathspec commit leaves out
+			if len(args) == 0 {
+				if index, err = utils.IndexRead(repo); err != nil {
+					utils.ErrorHandler("error in reading index", err)
+					return
+				}
+				entries = index.Entries
+				if all {
+					modified, deleted := commitTrackedChanges(repo, *index)
+					s := commitStage(repo, *index, append(modified, deleted...))
+					staged = &s
+					entries = s.Entries
+				}
+			}
+		}
+		tree := utils.TreeFromIndex(repo, utils.GitIndex{Entries: entries})
+
+		// a commit has to change something compared to its first parent,
+		// unless it concludes a merge
+		parentTree := utils.EmptyTreeSha
+		var parentEntries []utils.GitIndexEntry
+		if len(parents) > 0 {
+			if parentTree, err = utils.PeelTo(repo, parents[0], "tree"); err == nil {
+				parentEntries, err = utils.TreeIndexEntries(repo, parentTree)
+			}
+			if err != nil {
+				utils.ErrorHandler("error reading the parent tree", err)
+				return
+			}
+		}
+		if tree == parentTree && !allowEmpty && len(mergeHeads) == 0 {
+			if amend {
+				fmt.Print("You asked to amend the most recent commit, but doing so would make\n" +
+					"it empty. You can repeat your command with --allow-empty.\n")
+			} else {
+				fmt.Println("nothing to commit (use \"add\" and/or \"commit -a\" to stage changes, or --allow-empty)")
+			}
+			os.Exit(1)
+		}
 
-			fd, err := os.OpenFile(headPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		var signer *utils.SSHSigner
+		sign, _ := cmd.Flags().GetBool("gpg-sign")
+		noSign, _ := cmd.Flags().GetBool("no-gpg-sign")
+		if (sign || utils.ConfigGetBool(repo, "commit", "gpgSign")) && !noSign {
+			signer, err = utils.SigningKey(repo, "")
 			if err != nil {
-				utils.ErrorHandler("error updating HEAD", err)
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+		}
+
+		status := func(mode string) string {
+			var unstaged []string
+			current, err := utils.IndexRead(repo)
+			if staged != nil {
+				current, err = staged, nil
+			}
+			if err == nil {
+				modified, deleted := commitTrackedChanges(repo, *current)
+				for _, name := range modified {
+					unstaged = append(unstaged, "modified:   "+name)
+				}
+				for _, name := range deleted {
+					unstaged = append(unstaged, "deleted:    "+name)
+				}
+			}
+			return commitStatusComment(repo, mode, parentEntries, entries, unstaged)
+		}
+		message, err := commitMessage(repo, msgOpts, status)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		authorFlag, _ := cmd.Flags().GetString("author")
+		dateFlag, _ := cmd.Flags().GetString("date")
+		author, err := commitAuthor(repo, authorFlag, dateFlag, base)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		committer, err := utils.CommitterIdent(repo)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+
+		commit, err := utils.CommitCreate(
+			repo, 
+			tree,
+			parents,
+			author,
+			committer,
+			message,
+			signer,
+		)
+		if err != nil {
+			fmt.Printf("fatal: failed to sign the commit: %v\n", err)
+			os.Exit(128)
+		}
+
+		// moves the branch HEAD is attached to, or HEAD itself when detached
+		err = utils.UpdateRef(repo, "HEAD", commit, commitReflogMessage(repo, commit, amend))
+		if err != nil {
+			fmt.Printf("fatal: couldn't update HEAD: %v\n", err)
+			os.Exit(128)
+		}
+		if staged != nil {
+			if err := utils.IndexWrite(repo, *staged); err != nil {
+				fmt.Printf("error: couldn't write the index: %v\n", err)
 			}
-			defer fd.Close()
+		}
+
+		utils.MergeStateClear(repo)
+		if pickHead != "" {
+			if err := utils.SequencerCommitted(repo, pickHead); err != nil {
+				fmt.Printf("error: %v\n", err)
+			}
+		}
+
+		fmt.Printf("created commit: %v\n", commit)
 
-			fd.Write([]byte("\n")) 
-		}		
+		// too late to stop anything, so how it exits doesn't matter
+		utils.RunHook(repo, "post-commit", "")
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(commitCmd)
 
-	commitCmd.Flags().StringP("message", "m", "", "message to associate a commit with")
+	commitCmd.Flags().StringArrayP("message", "m", nil, "message to associate a commit with, each one a paragraph")
+	commitCmd.Flags().StringP("file", "F", "", "take the message from the file, - for stdin")
+	commitCmd.Flags().StringP("template", "t", "", "start the editor with the file, instead of commit.template")
+	commitCmd.Flags().BoolP("edit", "e", false, "edit the message given with -m, -F or from --amend")
+	commitCmd.Flags().Bool("no-edit", false, "with --amend, keep the message without opening the editor")
+	commitCmd.Flags().String("cleanup", "", "how to tidy the message: strip, whitespace, verbatim, scissors or default")
+	commitCmd.Flags().BoolP("no-verify", "n", false, "skip the pre-commit and commit-msg hooks")
+	commitCmd.Flags().Bool("allow-empty-message", false, "allow a commit with an empty message")
+	commitCmd.Flags().BoolP("all", "a", false, "stage the modified and deleted tracked files first")
+	commitCmd.Flags().Bool("amend", false, "replace HEAD with a new commit, keeping its parents, author and message")
+	commitCmd.Flags().Bool("reset-author", false, "with --amend, take the author from the environment and config instead")
+	commitCmd.Flags().Bool("allow-empty", false, "allow a commit with the same tree as its parent")
+	commitCmd.Flags().String("author", "", "override the author, \"Name <email>\" or a pattern matching an existing author")
+	commitCmd.Flags().String("date", "", "override the author date")
+	commitCmd.Flags().BoolP("gpg-sign", "S", false, "sign the commit with the ssh key in user.signingkey")
+	commitCmd.Flags().Bool("no-gpg-sign", false, "don't sign the commit, even with commit.gpgSign set")
 }
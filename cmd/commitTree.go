@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// commitTreePart is a -m message or a -F file, kept in one list since their
+// order on the command line is the order of the paragraphs.
+type commitTreePart struct {
+	file  bool
+	value string
+}
+
+var commitTreeParts []commitTreePart
+
+// commitTreeFlag is the pflag.Value of -m and -F, adding to commitTreeParts.
+type commitTreeFlag struct{ file bool }
+
+func (f commitTreeFlag) String() string { return "" }
+func (f commitTreeFlag) Type() string   { return "string" }
+
+func (f commitTreeFlag) Set(value string) error {
+	commitTreeParts = append(commitTreeParts, commitTreePart{file: f.file, value: value})
+	return nil
+}
+
+var commitTreeCmd = &cobra.Command{
+	Use:   "commitTree TREE [-p PARENT]... [-m MESSAGE]... [-F FILE]... [-S]",
+	Short: "create a new commit object",
+	Long: `writes a commit of the tree with the parents given and prints its sha, without touching HEAD, the index or
+	any ref. each -m is a paragraph of the message, -F adds the contents of a file, - being stdin, and without
+	either the message is read from stdin. the author and committer come from the environment and config, the
+	same way as for commit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("Usage: commitTree TREE [-p PARENT]... [-m MESSAGE]... [-F FILE]... [-S]")
+			os.Exit(129)
+		}
+		repo := utils.RepoFind(".", true)
+
+		tree := utils.ObjectFind(repo, args[0], "tree", true)
+		if tree == "" {
+			fmt.Printf("fatal: not a valid object name %s\n", args[0])
+			os.Exit(128)
+		}
+
+		var parents []string
+		names, _ := cmd.Flags().GetStringArray("parent")
+		for _, name := range names {
+			parent := utils.ObjectFind(repo, name, "commit", true)
+			if parent == "" {
+				fmt.Printf("fatal: not a valid object name %s\n", name)
+				os.Exit(128)
+			}
+			for _, seen := range parents {
+				if seen == parent {
+					fmt.Printf("error: duplicate parent %s ignored\n", parent)
+					parent = ""
+				}
+			}
+			if parent != "" {
+				parents = append(parents, parent)
+			}
+		}
+
+		// paragraphs from -m and files from -F in the order given, or stdin
+		var message strings.Builder
+		for _, part := range commitTreeParts {
+			if message.Len() > 0 {
+				message.WriteString("\n")
+			}
+			if !part.file {
+				message.WriteString(part.value)
+				if !strings.HasSuffix(part.value, "\n") {
+					message.WriteString("\n")
+				}
+				continue
+			}
+
+			var data []byte
+			var err error
+			if part.value == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(part.value)
+			}
+			if err != nil {
+				fmt.Printf("fatal: could not read log file '%s': %v\n", part.value, err)
+				os.Exit(128)
+			}
+			message.Write(data)
+		}
+		if len(commitTreeParts) == 0 {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("fatal: could not read from stdin: %v\n", err)
+				os.Exit(128)
+			}
+			message.Write(data)
+		}
+
+		var signer *utils.SSHSigner
+		sign, _ := cmd.Flags().GetBool("gpg-sign")
+		noSign, _ := cmd.Flags().GetBool("no-gpg-sign")
+		if (sign || utils.ConfigGetBool(repo, "commit", "gpgSign")) && !noSign {
+			var err error
+			if signer, err = utils.SigningKey(repo, ""); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+		}
+
+		author, err := utils.AuthorIdent(repo, utils.Ident{})
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		committer, err := utils.CommitterIdent(repo)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+
+		sha, err := utils.CommitCreate(repo, tree, parents, author, committer, message.String(), signer)
+		if err != nil {
+			fmt.Printf("fatal: failed to sign the commit: %v\n", err)
+			os.Exit(128)
+		}
+		fmt.Println(sha)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(commitTreeCmd)
+
+	commitTreeCmd.Flags().StringArrayP("parent", "p", nil, "a parent of the commit, can be repeated")
+	commitTreeCmd.Flags().VarP(commitTreeFlag{}, "message", "m", "a paragraph of the message, can be repeated")
+	commitTreeCmd.Flags().VarP(commitTreeFlag{file: true}, "file", "F", "read the message from a file, - for stdin")
+	commitTreeCmd.Flags().BoolP("gpg-sign", "S", false, "sign the commit with the ssh key in user.signingkey, also done when commit.gpgSign is set")
+	commitTreeCmd.Flags().Bool("no-gpg-sign", false, "don't sign the commit")
+}
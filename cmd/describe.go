@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// describeDirty tells whether the index or the worktree differ from HEAD.
+func describeDirty(repo utils.Repo) (bool, error) {
+	head, err := treeToMap(repo, "HEAD", "")
+	if err != nil {
+		return false, err
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return false, err
+	}
+	if len(index.Entries) != len(head) {
+		return true, nil
+	}
+
+	for _, entry := range index.Entries {
+		if head[entry.Name] != entry.SHA {
+			return true, nil
+		}
+
+		stat, err := os.Stat(filepath.Join(repo.Worktree, filepath.FromSlash(entry.Name)))
+		if err != nil {
+			return true, nil
+		}
+		if stat.ModTime().Unix() == int64(entry.Mtime[0]) &&
+			stat.ModTime().Nanosecond() == int(entry.Mtime[1]) &&
+			stat.Size() == int64(entry.Size) {
+			continue
+		}
+		// the stat data changed, the content may not have
+		if checkoutWorktreeSha(repo, entry.Name) != entry.SHA {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var describeCmd = &cobra.Command{
+	Use:   "describe [--tags] [--long] [--abbrev N] [--match PATTERN] [--exclude PATTERN] [--dirty[=MARK]] [--contains] [COMMIT...]",
+	Short: "give a commit a readable name based on the tags reachable from it",
+	Long: `finds the most recent annotated tag (any tag with --tags) reachable from COMMIT (default HEAD) and
+	prints it as TAG-N-gSHA, N being the number of commits on top of the tag. a tagged commit is shown as TAG alone,
+	unless --long is given. --dirty appends MARK (default "-dirty") when the worktree differs from HEAD.
+	--contains instead names the commit after a tag that contains it, like v1.0~2.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var opts utils.DescribeOptions
+		opts.Tags, _ = cmd.Flags().GetBool("tags")
+		opts.Match, _ = cmd.Flags().GetStringArray("match")
+		opts.Exclude, _ = cmd.Flags().GetStringArray("exclude")
+		opts.Candidates, _ = cmd.Flags().GetInt("candidates")
+		long, _ := cmd.Flags().GetBool("long")
+		abbrev, _ := cmd.Flags().GetInt("abbrev")
+		contains, _ := cmd.Flags().GetBool("contains")
+		always, _ := cmd.Flags().GetBool("always")
+
+		dirtyMark := ""
+		if cmd.Flags().Changed("dirty") {
+			dirtyMark, _ = cmd.Flags().GetString("dirty")
+			if len(args) > 0 {
+				fmt.Print("fatal: option '--dirty' and commit-ishes cannot be used together\n")
+				os.Exit(1)
+			}
+		}
+
+		if len(args) == 0 {
+			args = []string{"HEAD"}
+		}
+
+		repo := utils.RepoFind(".", true)
+
+		for _, arg := range args {
+			commit := utils.ObjectFind(repo, arg, "commit", true)
+			if commit == "" {
+				os.Exit(1)
+			}
+
+			var name string
+			var err error
+
+			if contains {
+				name, err = utils.DescribeContains(repo, commit, opts)
+			} else {
+				var tag string
+				var depth int
+				tag, depth, err = utils.Describe(repo, commit, opts)
+
+				switch {
+				case err != nil:
+				case abbrev == 0:
+					name = tag
+				case depth == 0 && !long:
+					name = tag
+				default:
+					name = fmt.Sprintf("%s-%d-g%s", tag, depth, utils.AbbrevSha(repo, commit, abbrev))
+				}
+			}
+
+			if err != nil {
+				if !always {
+					fmt.Printf("fatal: %v\n", err)
+					os.Exit(1)
+				}
+				name = utils.AbbrevSha(repo, commit, abbrev)
+			}
+
+			if dirtyMark != "" {
+				dirty, err := describeDirty(repo)
+				utils.ErrorHandler("couldn't check the worktree", err)
+				if dirty {
+					name += dirtyMark
+				}
+			}
+
+			fmt.Println(name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+
+	describeCmd.Flags().Bool("tags", false, "use lightweight tags too, not only annotated ones")
+	describeCmd.Flags().Bool("long", false, "always use the long format, even for a tagged commit")
+	describeCmd.Flags().Int("abbrev", 7, "digits of the abbreviated sha, 0 to print the tag alone")
+	describeCmd.Flags().StringArray("match", nil, "only consider tags matching this glob pattern")
+	describeCmd.Flags().StringArray("exclude", nil, "leave out the tags matching this glob pattern")
+	describeCmd.Flags().String("dirty", "", "append MARK when the worktree has changes")
+	describeCmd.Flags().Lookup("dirty").NoOptDefVal = "-dirty"
+	describeCmd.Flags().Bool("contains", false, "name the commit after a tag that contains it")
+	describeCmd.Flags().Bool("always", false, "show the abbreviated sha when no tag can describe the commit")
+	describeCmd.Flags().Int("candidates", 10, "how many tags to consider")
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// forEachRefMatch tells whether ref is selected by one of the patterns: a
+// pattern matches the ref itself, everything below it, or as a glob.
+func forEachRefMatch(ref string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		prefix := strings.TrimSuffix(pattern, "/")
+		if ref == prefix || strings.HasPrefix(ref, prefix+"/") {
+			return true
+		}
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachRefFilter holds the --points-at, --merged and --contains filters.
+type forEachRefFilter struct {
+	pointsAt   string
+	merged     []string
+	noMerged   []string
+	contains   []string
+	noContains []string
+}
+
+func (filter forEachRefFilter) wants(repo utils.Repo, ref *utils.RefFields) (bool, error) {
+	if filter.pointsAt != "" {
+		peeled, _ := utils.PeelTo(repo, ref.Sha, "")
+		if ref.Sha != filter.pointsAt && peeled != filter.pointsAt {
+			return false, nil
+		}
+	}
+
+	if len(filter.merged)+len(filter.noMerged)+len(filter.contains)+len(filter.noContains) == 0 {
+		return true, nil
+	}
+
+	// the reachability filters only apply to refs to commits
+	commit, err := utils.PeelTo(repo, ref.Sha, "commit")
+	if err != nil {
+		return false, nil
+	}
+
+	// any reaches: some of others reaches commit (or commit reaches them,
+	// when reverse is set)
+	any := func(others []string, reverse bool) (bool, error) {
+		for _, other := range others {
+			ancestor, descendant := commit, other
+			if reverse {
+				ancestor, descendant = other, commit
+			}
+			ok, err := utils.IsAncestor(repo, ancestor, descendant)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	checks := []struct {
+		others  []string
+		reverse bool
+		want    bool
+	}{
+		{filter.merged, false, true},
+		{filter.noMerged, false, false},
+		{filter.contains, true, true},
+		{filter.noContains, true, false},
+	}
+	for _, check := range checks {
+		if len(check.others) == 0 {
+			continue
+		}
+		ok, err := any(check.others, check.reverse)
+		if err != nil {
+			return false, err
+		}
+		if ok != check.want {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// forEachRefCommits resolves the commits given to --merged and the like.
+func forEachRefCommits(repo utils.Repo, cmd *cobra.Command, flag string) ([]string, error) {
+	names, _ := cmd.Flags().GetStringArray(flag)
+
+	var commits []string
+	for _, name := range names {
+		commit, err := revListCommit(repo, name)
+		if err != nil {
+			return nil, fmt.Errorf("malformed object name %v", name)
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+var forEachRefCmd = &cobra.Command{
+	Use:   "forEachRef [--format FORMAT] [--sort KEY] [--count N] [--points-at OBJECT] [--merged [COMMIT]] [--contains [COMMIT]] [PATTERN...]",
+	Short: "print information about each ref",
+	Long: `prints a line per ref matching one of the PATTERNs (all refs without any), formatted by FORMAT.
+	a pattern matches the ref of that name, the refs below it (refs/heads matches refs/heads/main) or as a glob.
+
+	FORMAT replaces %(atom) by a field of the ref or of the object it points to, %(*atom) by a field of the object an
+	annotated tag points to, %% by a percent sign and %xx by the byte with hex code xx. the default is
+	"%(objectname) %(objecttype)\t%(refname)".
+
+	atoms:
+	refname, objectname, objecttype, objectsize, tree, parent, object, type, tag, subject, body, contents,
+	author, authorname, authoremail, authordate, the same for committer and tagger, creator, creatordate,
+	upstream, symref and HEAD. refname, upstream and symref take :short and :lstrip=N, objectname :short[=N],
+	the dates :unix, :raw, :iso, :iso-strict, :short or :rfc.
+
+	--sort KEY orders by an atom, descending when prefixed with -, by version numbers with version:ATOM.
+	given more than once, the last key is the primary one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		keys, _ := cmd.Flags().GetStringArray("sort")
+		count, _ := cmd.Flags().GetInt("count")
+		pointsAt, _ := cmd.Flags().GetString("points-at")
+
+		repo := utils.RepoFind(".", true)
+
+		var filter forEachRefFilter
+		var err error
+
+		if pointsAt != "" {
+			filter.pointsAt, err = utils.RevParse(repo, pointsAt)
+			if err != nil {
+				fmt.Printf("fatal: malformed object name %v\n", pointsAt)
+				os.Exit(1)
+			}
+		}
+
+		for flag, commits := range map[string]*[]string{
+			"merged":      &filter.merged,
+			"no-merged":   &filter.noMerged,
+			"contains":    &filter.contains,
+			"no-contains": &filter.noContains,
+		} {
+			*commits, err = forEachRefCommits(repo, cmd, flag)
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var refs []*utils.RefFields
+		all := utils.ListRefs(repo, "refs/")
+		for _, name := range utils.SortedRefNames(all) {
+			if !forEachRefMatch(name, args) {
+				continue
+			}
+
+			ref := utils.NewRefFields(repo, name, all[name])
+			ok, err := filter.wants(repo, ref)
+			utils.ErrorHandler("couldn't filter the refs", err)
+			if ok {
+				refs = append(refs, ref)
+			}
+		}
+
+		if err := utils.SortRefFields(refs, keys); err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(1)
+		}
+
+		if count > 0 && count < len(refs) {
+			refs = refs[:count]
+		}
+
+		for _, ref := range refs {
+			line, err := ref.Format(format)
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(line)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(forEachRefCmd)
+
+	forEachRefCmd.Flags().String("format", "%(objectname) %(objecttype)\t%(refname)", "how to print each ref")
+	forEachRefCmd.Flags().StringArray("sort", nil, "sort by this key, the last one given is the primary key")
+	forEachRefCmd.Flags().Int("count", 0, "stop after printing N refs")
+	forEachRefCmd.Flags().String("points-at", "", "only the refs pointing at OBJECT, directly or through a tag")
+
+	for _, flag := range []struct{ name, usage string }{
+		{"merged", "only the refs whose commit is reachable from COMMIT (default HEAD)"},
+		{"no-merged", "only the refs whose commit isn't reachable from COMMIT (default HEAD)"},
+		{"contains", "only the refs whose commit contains COMMIT (default HEAD)"},
+		{"no-contains", "only the refs whose commit doesn't contain COMMIT (default HEAD)"},
+	} {
+		forEachRefCmd.Flags().StringArray(flag.name, nil, flag.usage)
+		forEachRefCmd.Flags().Lookup(flag.name).NoOptDefVal = "HEAD"
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var fsmonitorCmd = &cobra.Command{
+	Use:   "fsmonitor start|stop|status|run",
+	Short: "filesystem monitor daemon used to speed up status and add",
+	Long: `the daemon watches the worktree and remembers which paths changed, so status and add
+	only have to examine those. enable it with core.fsmonitor = true in the repository config.
+	run stays in the foreground, start launches it in the background.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		switch args[0] {
+		case "run":
+			if err := utils.NewFsmonitorDaemon(repo).Run(); err != nil {
+				utils.ErrorHandler("fsmonitor daemon failed", err)
+			}
+		case "start":
+			if _, err := utils.FsmonitorCommand(repo, "status"); err == nil {
+				fmt.Println("fsmonitor daemon is already running")
+				return
+			}
+			if err := utils.FsmonitorSpawn(repo); err != nil {
+				utils.ErrorHandler("couldn't start the fsmonitor daemon", err)
+				return
+			}
+			fmt.Println("fsmonitor daemon started")
+		case "stop", "status":
+			reply, err := utils.FsmonitorCommand(repo, args[0])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Print(reply)
+		default:
+			fmt.Print("Usage: fsmonitor start|stop|status|run\n")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsmonitorCmd)
+}
@@ -23,7 +23,10 @@ func objectHash(repo utils.Repo, file *os.File, format string) string {
 		default: fmt.Printf("Unknown type format %v", format)
 	}
 
-	obj.Deserialize(string(data))
+	if err := obj.Deserialize(string(data)); err != nil {
+		fmt.Printf("fatal: corrupt %v: %v\n", format, err)
+		os.Exit(128)
+	}
 
 	return utils.ObjectWrite(obj, repo)
 }
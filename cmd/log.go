@@ -9,49 +9,141 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func logGraphviz(repo utils.Repo, sha string, seenSet map[string]bool, log string) (string, error) {
-	var err error
-	if seenSet[sha] {
-		return log, err
+func logGraphviz(repo utils.Repo, sha string) (string, error) {
+	walk := utils.NewRevWalk(repo)
+	walk.Push(sha)
+
+	commits, err := walk.Walk()
+	if err != nil {
+		return "", err
 	}
-	seenSet[sha] = true
 
-	object := utils.ObjectRead(repo, sha)
-	commit, ok := object.(*utils.GitCommit)
-	if !ok {
-		return "", fmt.Errorf("error reading commit object: %v", sha)
+	var log strings.Builder
+	for _, sha := range commits {
+		commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+		if !ok {
+			return "", fmt.Errorf("error reading commit object: %v", sha)
+		}
+		message := commit.Data.Message
+		message = strings.ReplaceAll(message, "\\", "\\\\")
+		message = strings.ReplaceAll(message, "\"", "\\\"")
+
+		if strings.Contains(message, "\n") {
+			message = message[:strings.Index(message, "\n")]
+		}
+
+		fmt.Fprintf(&log, " c_%v [label=\"%v: %v\"]", sha, sha[0:7], message)
+
+		for _, parent := range commit.Data.GetAll("parent") {
+			fmt.Fprintf(&log, " c_%v -> c_%v;", sha, parent)
+		}
 	}
-	message := string(commit.Data[""][0])
-	message = strings.ReplaceAll(message, "\\", "\\\\")
-	message = strings.ReplaceAll(message, "\"", "\\\"")
+	return log.String(), nil
+}
+
+// logNotesDefault stands for the notes refs log shows by default, when
+// --notes is given without a ref.
+const logNotesDefault = "(default)"
+
+// logNotesRefs lists the notes refs to show from the --notes values given:
+// the default ones when there are none.
+func logNotesRefs(repo utils.Repo, values []string) []string {
+	if len(values) == 0 {
+		values = []string{logNotesDefault}
+	}
+
+	var refs []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		names := []string{utils.NotesRefName(value)}
+		if value == logNotesDefault {
+			names = utils.NotesDisplayRefs(repo)
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				refs = append(refs, name)
+			}
+		}
+	}
+	return refs
+}
+
+// logNotes gives the notes on sha from each of refs, the way git log shows
+// them below the message.
+func logNotes(repo utils.Repo, sha string, refs []string, notes map[string]*utils.Notes) string {
+	var b strings.Builder
+	for _, ref := range refs {
+		if notes[ref] == nil {
+			n, err := utils.NotesReadRef(repo, ref)
+			if err != nil {
+				continue
+			}
+			notes[ref] = n
+		}
+		text, ok := notes[ref].Note(repo, sha)
+		if !ok {
+			continue
+		}
 
-	if strings.Contains(message, "\n") {
-		message = message[:strings.Index(message, "\n")]
+		if ref == utils.NotesDefaultRef {
+			b.WriteString("\nNotes:\n")
+		} else {
+			fmt.Fprintf(&b, "\nNotes (%v):\n", strings.TrimPrefix(ref, "refs/notes/"))
+		}
+		if text == "" {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			fmt.Fprintf(&b, "    %v\n", line)
+		}
 	}
+	return b.String()
+}
 
-	log += 	fmt.Sprintf(" c_%v [label=\"%v: %v\"]", sha, sha[0:7], message)
+// logListing lists the commits reachable from sha, with the outcome of
+// checking their signatures the way git log --show-signature shows them when
+// signatures is set, and with the notes on them from notesRefs.
+func logListing(repo utils.Repo, sha string, signatures bool, notesRefs []string) (string, error) {
+	walk := utils.NewRevWalk(repo)
+	walk.Push(sha)
 
-	if _, ok := commit.Data["parent"]; !ok {
-		// base case: the initial commit
-		return log, err
+	commits, err := walk.Walk()
+	if err != nil {
+		return "", err
 	}
 
-	for _, parent := range commit.Data["parent"] {
-		log += fmt.Sprintf(" c_%v -> c_%v;", sha, parent)
-		l, err := logGraphviz(repo, parent, seenSet, log)
-		if err != nil {
-			return "", err
+	notes := make(map[string]*utils.Notes)
+	var log strings.Builder
+	for _, sha := range commits {
+		commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+		if !ok {
+			return "", fmt.Errorf("error reading commit object: %v", sha)
+		}
+
+		fmt.Fprintf(&log, "commit %v\n", sha)
+		if signatures {
+			if sig, signed := utils.VerifyCommit(repo, commit); signed {
+				fmt.Fprintf(&log, "%v\n", sig)
+			}
 		}
-		log = l
+
+		subject, _, _ := strings.Cut(strings.TrimSpace(commit.Data.Message), "\n")
+		fmt.Fprintf(&log, "\n    %v\n", subject)
+		log.WriteString(logNotes(repo, sha, notesRefs, notes))
+		log.WriteString("\n")
 	}
-	return log, err
+	return log.String(), nil
 }
 
 var logCmd = &cobra.Command{
 	Use:   "log COMMIT_HASH",
 	Short: "review logging of commit data and its metadata",
 	Long: `review the different commits along with their information like the authors, time stamps etc.
-	use dot -O -Tpdf log.dot to generate a pdf of the commit tree`,
+	use dot -O -Tpdf log.dot to generate a pdf of the commit tree.
+	--show-signature also prints each commit with the outcome of checking its ssh signature.
+	--notes prints each commit with its notes, from refs/notes/commits and the refs matching notes.displayRef,
+	or from the notes ref given with --notes=REF.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			fmt.Print("Usage: log COMMIT_HASH")
@@ -60,19 +152,43 @@ var logCmd = &cobra.Command{
 
 		repo := utils.RepoFind(".", true)
 
-		log := ""
-		log += "digraph wannagitLog{node[shape=rect]"
-		l, err := logGraphviz(repo, utils.ObjectFind(repo, args[0], "", false), make(map[string]bool), log)
+		sha := utils.ObjectFind(repo, args[0], "commit", true)
+		if sha == "" {
+			return
+		}
+
+		l, err := logGraphviz(repo, sha)
 		if err != nil {
 			utils.ErrorHandler("error in getting log data", err)
 			return
 		}
-		l += "}"
+		l = "digraph wannagitLog{node[shape=rect]" + l + "}"
 
 		os.WriteFile("log.dot", []byte(l), os.ModePerm)
+
+		showSignature, _ := cmd.Flags().GetBool("show-signature")
+		if showSignature || cmd.Flags().Changed("notes") {
+			var notesRefs []string
+			if noNotes, _ := cmd.Flags().GetBool("no-notes"); !noNotes {
+				values, _ := cmd.Flags().GetStringArray("notes")
+				notesRefs = logNotesRefs(repo, values)
+			}
+
+			listing, err := logListing(repo, sha, showSignature, notesRefs)
+			if err != nil {
+				utils.ErrorHandler("error in listing the commits", err)
+				return
+			}
+			fmt.Print(listing)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(logCmd)
+
+	logCmd.Flags().Bool("show-signature", false, "print the commits with the outcome of checking their signatures")
+	logCmd.Flags().StringArray("notes", nil, "print the commits with their notes, from the default notes refs or the one given")
+	logCmd.Flags().Lookup("notes").NoOptDefVal = logNotesDefault
+	logCmd.Flags().Bool("no-notes", false, "don't show notes with --show-signature")
 }
@@ -10,6 +10,9 @@ import (
 
 func lsTree(repo utils.Repo, ref string, recursive bool, prefix string)  {
 	sha := utils.ObjectFind(repo, ref, "tree", true)
+	if sha == "" {
+		return
+	}
 	obj := utils.ObjectRead(repo, sha)
 
 	tree, ok := obj.(*utils.GitTree)
@@ -21,8 +24,9 @@ func lsTree(repo utils.Repo, ref string, recursive bool, prefix string)  {
 	var typBits string
 
 	for _, item := range tree.Items {
+		// git writes directories as 40000, without the leading zero
 		if len(item.Mode) == 5 {
-			typBits = item.Mode[0:1]
+			typBits = "0" + item.Mode[0:1]
 		} else {
 			typBits = item.Mode[0:2]
 		}
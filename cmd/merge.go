@@ -0,0 +1,718 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// mergeUnmerged lists the paths left in conflict in the index.
+func mergeUnmerged(index utils.GitIndex) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, e := range index.Entries {
+		if e.Stage != 0 && !seen[e.Name] {
+			seen[e.Name] = true
+			names = append(names, e.Name)
+		}
+	}
+	return names
+}
+
+// mergeJoin lists items as "a, b and c".
+func mergeJoin(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// mergeMessage gives the default message, "Merge branch 'topic'", naming
+// each rev by what kind of ref it is. merging into anything but main or
+// master adds " into <branch>".
+func mergeMessage(repo utils.Repo, names []string) string {
+	kinds := []string{"branch", "remote-tracking branch", "tag", "commit"}
+	plurals := map[string]string{
+		"branch":                 "branches",
+		"remote-tracking branch": "remote-tracking branches",
+		"tag":                    "tags",
+		"commit":                 "commits",
+	}
+
+	groups := make(map[string][]string)
+	for _, name := range names {
+		ref, _ := utils.DwimRef(repo, name)
+		kind := "commit"
+		switch {
+		case strings.HasPrefix(ref, "refs/heads/"):
+			kind = "branch"
+		case strings.HasPrefix(ref, "refs/remotes/"):
+			kind = "remote-tracking branch"
+		case strings.HasPrefix(ref, "refs/tags/"):
+			kind = "tag"
+		}
+		groups[kind] = append(groups[kind], "'"+name+"'")
+	}
+
+	var parts []string
+	for _, kind := range kinds {
+		quoted := groups[kind]
+		switch len(quoted) {
+		case 0:
+			continue
+		case 1:
+			parts = append(parts, kind+" "+quoted[0])
+		default:
+			parts = append(parts, plurals[kind]+" "+mergeJoin(quoted))
+		}
+	}
+	message := "Merge " + mergeJoin(parts)
+
+	into := "HEAD"
+	if branch := utils.HeadBranch(repo); branch != "" {
+		into = strings.TrimPrefix(branch, "refs/heads/")
+	}
+	if into != "main" && into != "master" {
+		message += " into " + into
+	}
+	return message + "\n"
+}
+
+// mergeSquashMessage lists the commits a squash brings in, for SQUASH_MSG.
+func mergeSquashMessage(repo utils.Repo, head string, commits []string) string {
+	walk := utils.NewRevWalk(repo)
+	for _, commit := range commits {
+		walk.Push(commit)
+	}
+	if head != "" {
+		walk.Hide(head)
+	}
+	shas, err := walk.Walk()
+	if err != nil {
+		return ""
+	}
+
+	var msg strings.Builder
+	msg.WriteString("Squashed commit of the following:\n")
+	for _, sha := range shas {
+		commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+		if !ok {
+			continue
+		}
+		author, _ := utils.ParseIdent(commit.Data.Get("author"))
+		fmt.Fprintf(&msg, "\ncommit %s\nAuthor: %s <%s>\nDate:   %s\n\n", sha, author.Name, author.Email,
+			utils.FormatDate(author.When, "default"))
+		for _, line := range strings.Split(strings.TrimRight(commit.Data.Message, "\n"), "\n") {
+			if line == "" {
+				msg.WriteString("\n")
+			} else {
+				msg.WriteString("    " + line + "\n")
+			}
+		}
+	}
+	return msg.String()
+}
+
+// mergeWorktreeFile writes the blob sha to the worktree as a file of mode,
+// or a symlink.
+func mergeWorktreeFile(repo utils.Repo, abspath string, sha string, modeType uint16, modePerms uint16) error {
+	data := utils.ObjectRead(repo, sha).Serialize()
+	if err := os.MkdirAll(filepath.Dir(abspath), 0755); err != nil {
+		return err
+	}
+	os.Remove(abspath)
+
+	if modeType == 0b1010 {
+		return os.Symlink(data, abspath)
+	}
+	perm := os.FileMode(0644)
+	if modePerms&0o111 != 0 {
+		perm = 0755
+	}
+	return os.WriteFile(abspath, []byte(data), perm)
+}
+
+// mergeApply moves the worktree and index from ours, the commit or tree
+// the merge was made on, to its result: the merged files are staged, the
+// conflicts get their stages and the worktree their markers. like checkout
+// it refuses to lose local changes, and with a merge on top the index must
+// match ours.
+func mergeApply(repo utils.Repo, ours string, m *utils.TreeMerge, operation string) error {
+	current := make(map[string]utils.GitIndexEntry)
+	if ours != "" {
+		headTree, err := utils.PeelTo(repo, ours, "tree")
+		if err != nil {
+			return err
+		}
+		entries, err := utils.TreeIndexEntries(repo, headTree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			current[e.Name] = e
+		}
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return err
+	}
+
+	var staged []string
+	inIndex := make(map[string]bool)
+	for _, e := range index.Entries {
+		inIndex[e.Name] = true
+		if c, ok := current[e.Name]; !ok || e.Stage != 0 || c.SHA != e.SHA || c.ModeType != e.ModeType || c.ModePerms != e.ModePerms {
+			staged = append(staged, e.Name)
+		}
+	}
+	for name := range current {
+		if !inIndex[name] {
+			staged = append(staged, name)
+		}
+	}
+	if len(staged) > 0 {
+		sort.Strings(staged)
+		return fmt.Errorf("Your local changes to the following files would be overwritten by %s:\n\t%s\n"+
+			"Please commit your changes or stash them before you %s.", operation, strings.Join(staged, "\n\t"), operation)
+	}
+
+	// what each path holds afterwards, in the worktree
+	targets := make(map[string]utils.GitIndexEntry)
+	for _, e := range m.Entries {
+		if e.Stage == 0 {
+			targets[e.Name] = e
+		}
+	}
+	for name, sha := range m.Worktree {
+		e := utils.GitIndexEntry{Name: name, SHA: sha, ModeType: 0b1000, ModePerms: 0o644}
+		for _, c := range m.Entries {
+			if c.Name == name && (c.Stage == 2 || c.Stage == 3) {
+				e.ModeType, e.ModePerms = c.ModeType, c.ModePerms
+				if c.Stage == 2 {
+					break
+				}
+			}
+		}
+		targets[name] = e
+	}
+
+	var changed []string
+	for name, e := range targets {
+		if c, ok := current[name]; !ok || c.SHA != e.SHA || c.ModeType != e.ModeType || c.ModePerms != e.ModePerms {
+			changed = append(changed, name)
+		}
+	}
+	var removed []string
+	for name := range current {
+		if _, ok := targets[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	var dirty, untracked []string
+	for _, name := range append(append([]string{}, changed...), removed...) {
+		c, tracked := current[name]
+		worktree := checkoutWorktreeSha(repo, name)
+		switch {
+		case tracked && worktree != c.SHA:
+			dirty = append(dirty, name)
+		case !tracked && worktree != "":
+			untracked = append(untracked, name)
+		}
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("Your local changes to the following files would be overwritten by %s:\n\t%s\n"+
+			"Please commit your changes or stash them before you %s.", operation, strings.Join(dirty, "\n\t"), operation)
+	}
+	if len(untracked) > 0 {
+		return fmt.Errorf("The following untracked working tree files would be overwritten by %s:\n\t%s\n"+
+			"Please move or remove them before you %s.", operation, strings.Join(untracked, "\n\t"), operation)
+	}
+
+	// removals first, a file may be in the way of a directory
+	for _, name := range removed {
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		if os.Remove(abspath) == nil {
+			checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+		}
+	}
+	for _, name := range changed {
+		e := targets[name]
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		if err := mergeWorktreeFile(repo, abspath, e.SHA, e.ModeType, e.ModePerms); err != nil {
+			return err
+		}
+	}
+
+	conflicted := make(map[string]bool)
+	for _, name := range m.Conflicts {
+		conflicted[name] = true
+	}
+
+	var entries []utils.GitIndexEntry
+	for _, e := range index.Entries {
+		if t, ok := targets[e.Name]; ok && !conflicted[e.Name] && t.SHA == e.SHA && t.ModeType == e.ModeType && t.ModePerms == e.ModePerms {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range m.Entries {
+		switch {
+		case e.Stage != 0:
+			entries = append(entries, e)
+		case current[e.Name].SHA != e.SHA || current[e.Name].ModeType != e.ModeType || current[e.Name].ModePerms != e.ModePerms:
+			abspath := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+			entry, err := indexEntryFromFile(abspath, e.Name, e.SHA)
+			if err != nil {
+				return err
+			}
+			entry.ModeType, entry.ModePerms = e.ModeType, e.ModePerms
+			entries = append(entries, entry)
+		}
+	}
+
+	index.Entries = entries
+	return utils.IndexWrite(repo, *index)
+}
+
+// mergeAbort puts the index and worktree back to HEAD for the paths the
+// merge touched, and forgets the merge.
+func mergeAbort(repo utils.Repo) error {
+	head := utils.ResolveRef(repo, "HEAD")
+	current := make(map[string]utils.GitIndexEntry)
+	if head != "" {
+		headTree, err := utils.PeelTo(repo, head, "tree")
+		if err != nil {
+			return err
+		}
+		entries, err := utils.TreeIndexEntries(repo, headTree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			current[e.Name] = e
+		}
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return err
+	}
+
+	var entries []utils.GitIndexEntry
+	reset := make(map[string]bool)
+	for _, e := range index.Entries {
+		if c, ok := current[e.Name]; ok && e.Stage == 0 && c.SHA == e.SHA && c.ModeType == e.ModeType && c.ModePerms == e.ModePerms {
+			entries = append(entries, e)
+			continue
+		}
+		reset[e.Name] = true
+	}
+	for name := range current {
+		found := false
+		for _, e := range index.Entries {
+			if e.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			reset[name] = true
+		}
+	}
+
+	for name := range reset {
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		c, ok := current[name]
+		if !ok {
+			if os.Remove(abspath) == nil {
+				checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+			}
+			continue
+		}
+		if err := mergeWorktreeFile(repo, abspath, c.SHA, c.ModeType, c.ModePerms); err != nil {
+			return err
+		}
+		entry, err := indexEntryFromFile(abspath, name, c.SHA)
+		if err != nil {
+			return err
+		}
+		entry.ModeType, entry.ModePerms = c.ModeType, c.ModePerms
+		entries = append(entries, entry)
+	}
+
+	index.Entries = entries
+	if err := utils.IndexWrite(repo, *index); err != nil {
+		return err
+	}
+	utils.MergeStateClear(repo)
+	return nil
+}
+
+// mergeOctopus merges each of commits into head in turn, against what it
+// has in common with everything merged before it. it stops at the first
+// one that doesn't merge cleanly.
+func mergeOctopus(repo utils.Repo, head string, commits []string, names []string) (*utils.TreeMerge, error) {
+	tree, err := utils.PeelTo(repo, head, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	merged := []string{head}
+	var m *utils.TreeMerge
+	for i, commit := range commits {
+		fmt.Printf("Trying simple merge with %v\n", names[i])
+
+		bases, err := utils.MergeBases(repo, commit, merged...)
+		if err != nil {
+			return nil, err
+		}
+		baseTree, err := utils.MergeBaseTree(repo, bases)
+		if err != nil {
+			return nil, err
+		}
+		theirsTree, err := utils.PeelTo(repo, commit, "tree")
+		if err != nil {
+			return nil, err
+		}
+
+		m, err = utils.MergeTrees(repo, baseTree, tree, theirsTree, utils.MergeOptionsFor(repo, "HEAD", names[i]))
+		if err != nil {
+			return nil, err
+		}
+		if !m.Clean() {
+			return m, nil
+		}
+		tree = m.Tree(repo)
+		merged = append(merged, commit)
+	}
+	return m, nil
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [--no-ff | --ff-only] [--squash] [--no-commit] [-m MESSAGE] <rev>... | --abort | --continue",
+	Short: "join two or more development histories together",
+	Long: `merges the given revs into HEAD. HEAD is moved forward when it is an ancestor of the rev, otherwise the changes
+since the merge base are merged file by file and line by line, and committed with a parent for each side.
+conflicts are left in the worktree between <<<<<<< and >>>>>>> markers, with the base, our and their versions
+in index stages 1 to 3; fix them, add the files and run commit or merge --continue.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		abort, _ := cmd.Flags().GetBool("abort")
+		cont, _ := cmd.Flags().GetBool("continue")
+		noFF, _ := cmd.Flags().GetBool("no-ff")
+		ffOnly, _ := cmd.Flags().GetBool("ff-only")
+		// --ff=false is --no-ff, and --ff overrides --no-ff
+		if cmd.Flags().Changed("ff") {
+			ff, _ := cmd.Flags().GetBool("ff")
+			noFF = !ff
+		}
+		squash, _ := cmd.Flags().GetBool("squash")
+		noCommit, _ := cmd.Flags().GetBool("no-commit")
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+		messages, _ := cmd.Flags().GetStringArray("message")
+
+		switch {
+		case abort:
+			if !utils.MergeInProgress(repo) {
+				fmt.Println("fatal: There is no merge to abort (MERGE_HEAD missing).")
+				os.Exit(128)
+			}
+			if err := mergeAbort(repo); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			return
+		case cont:
+			if !utils.MergeInProgress(repo) {
+				fmt.Println("fatal: There is no merge in progress (MERGE_HEAD missing).")
+				os.Exit(128)
+			}
+			commitCmd.Run(commitCmd, nil)
+			return
+		}
+
+		if len(args) == 0 {
+			fmt.Println("fatal: No commit specified and merge.defaultToUpstream not set.")
+			os.Exit(128)
+		}
+
+		// merge.ff=false is --no-ff, merge.ff=only --ff-only
+		if !cmd.Flags().Changed("no-ff") && !cmd.Flags().Changed("ff-only") && !cmd.Flags().Changed("ff") {
+			switch utils.ConfigGet(repo, "merge", "ff") {
+			case "false":
+				noFF = true
+			case "only":
+				ffOnly = true
+			}
+		}
+		if noFF && ffOnly {
+			fmt.Println("fatal: options '--ff-only' and '--no-ff' cannot be used together")
+			os.Exit(128)
+		}
+		if squash && noFF {
+			fmt.Println("fatal: options '--squash' and '--no-ff' cannot be used together")
+			os.Exit(128)
+		}
+
+		if utils.MergeInProgress(repo) {
+			fmt.Print("fatal: You have not concluded your merge (MERGE_HEAD exists).\n" +
+				"Please, commit your changes before you merge.\n")
+			os.Exit(128)
+		}
+		index, err := utils.IndexRead(repo)
+		if err != nil {
+			utils.ErrorHandler("error in reading index", err)
+			return
+		}
+		if len(mergeUnmerged(*index)) > 0 {
+			fmt.Print("error: Merging is not possible because you have unmerged files.\n" +
+				"fatal: Exiting because of an unresolved conflict.\n")
+			os.Exit(128)
+		}
+
+		var commits []string
+		for _, name := range args {
+			sha := utils.ObjectFind(repo, name, "commit", true)
+			if sha == "" {
+				fmt.Printf("merge: %v - not something we can merge\n", name)
+				os.Exit(1)
+			}
+			commits = append(commits, sha)
+		}
+
+		head := utils.ResolveRef(repo, "HEAD")
+
+		// on an unborn branch there is nothing to merge into
+		if head == "" {
+			if len(commits) > 1 {
+				fmt.Println("fatal: Can merge only exactly one commit into empty head")
+				os.Exit(128)
+			}
+			if err := checkoutSwitch(repo, commits[0]); err != nil {
+				fmt.Printf("error: %v\nAborting\n", err)
+				os.Exit(1)
+			}
+			if err := utils.UpdateRef(repo, "HEAD", commits[0], "initial pull"); err != nil {
+				fmt.Printf("fatal: couldn't update HEAD: %v\n", err)
+				os.Exit(128)
+			}
+			return
+		}
+
+		// the revs HEAD already has are left out
+		var remaining, names []string
+		for i, commit := range commits {
+			merged, err := utils.IsAncestor(repo, commit, head)
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			if !merged {
+				remaining = append(remaining, commit)
+				names = append(names, args[i])
+			}
+		}
+		if len(remaining) == 0 {
+			fmt.Println("Already up to date.")
+			return
+		}
+
+		ff := false
+		if len(remaining) == 1 {
+			if ff, err = utils.IsAncestor(repo, head, remaining[0]); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+		}
+		if ffOnly && !ff {
+			fmt.Println("fatal: Not possible to fast-forward, aborting.")
+			os.Exit(128)
+		}
+
+		if ff && !noFF && !squash {
+			fmt.Printf("Updating %s..%s\nFast-forward\n", head[:7], remaining[0][:7])
+			if err := checkoutSwitch(repo, remaining[0]); err != nil {
+				fmt.Printf("error: %v\nAborting\n", err)
+				os.Exit(1)
+			}
+			err := utils.UpdateRef(repo, "HEAD", remaining[0], fmt.Sprintf("merge %s: Fast-forward", names[0]))
+			if err != nil {
+				fmt.Printf("fatal: couldn't update HEAD: %v\n", err)
+				os.Exit(128)
+			}
+			utils.RunHook(repo, "post-merge", "", "0")
+			return
+		}
+
+		strategy := "ort"
+		var m *utils.TreeMerge
+		if len(remaining) > 1 {
+			strategy = "octopus"
+			m, err = mergeOctopus(repo, head, remaining, names)
+		} else {
+			m, err = utils.MergeCommits(repo, head, remaining[0], utils.MergeOptionsFor(repo, "HEAD", names[0]))
+		}
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+
+		// an octopus only goes ahead when every merge is clean
+		if strategy == "octopus" && !m.Clean() {
+			for _, line := range m.Messages {
+				fmt.Println(line)
+			}
+			fmt.Println("Merge with strategy octopus failed.")
+			os.Exit(2)
+		}
+
+		if err := mergeApply(repo, head, m, "merge"); err != nil {
+			fmt.Printf("error: %v\nAborting\n", err)
+			os.Exit(2)
+		}
+		for _, line := range m.Messages {
+			fmt.Println(line)
+		}
+
+		if squash {
+			path, err := utils.RepoFile(repo, false, "SQUASH_MSG")
+			if err == nil {
+				err = os.WriteFile(path, []byte(mergeSquashMessage(repo, head, remaining)), 0644)
+			}
+			if err != nil {
+				fmt.Printf("fatal: couldn't write SQUASH_MSG: %v\n", err)
+				os.Exit(128)
+			}
+			fmt.Println("Squash commit -- not updating HEAD")
+			if !m.Clean() {
+				fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+				os.Exit(1)
+			}
+			utils.RunHook(repo, "post-merge", "", "1")
+			return
+		}
+
+		message := mergeMessage(repo, names)
+		if len(messages) > 0 {
+			message = strings.Join(messages, "\n\n") + "\n"
+		}
+		mode := ""
+		if noFF {
+			mode = "no-ff"
+		}
+
+		if !m.Clean() {
+			message += "\n# Conflicts:\n"
+			for _, name := range m.Conflicts {
+				message += "#\t" + name + "\n"
+			}
+			if err := utils.MergeStateWrite(repo, remaining, message, mode); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+			os.Exit(1)
+		}
+
+		// the hooks see the merge in progress, as they would from commit
+		if err := utils.MergeStateWrite(repo, remaining, message, mode); err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if noCommit {
+			fmt.Println("Automatic merge went well; stopped before committing as requested")
+			return
+		}
+
+		if !noVerify {
+			if err := utils.RunHook(repo, "pre-merge-commit", ""); err != nil {
+				fmt.Println(err)
+				fmt.Println("Not committing merge; use 'commit' to complete the merge.")
+				os.Exit(1)
+			}
+		}
+
+		msgOpts := commitMessageOptions{prepared: message, source: "merge", noVerify: noVerify}
+		msgOpts.edit, _ = cmd.Flags().GetBool("edit")
+		msgOpts.noEdit = !msgOpts.edit
+		msgOpts.cleanup, _ = cmd.Flags().GetString("cleanup")
+		message, err = commitMessage(repo, msgOpts, func(mode string) string {
+			hint := "# Please enter a commit message to explain why this merge is necessary,\n" +
+				"# especially if it merges an updated upstream into a topic branch.\n#\n"
+			if mode == "whitespace" || mode == "verbatim" {
+				return hint + "# Lines starting with '#' will be kept; you may remove them yourself if you want to.\n"
+			}
+			return hint + "# Lines starting with '#' will be ignored, and an empty message aborts\n# the commit.\n"
+		})
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("Not committing merge; use 'commit' to complete the merge.")
+			os.Exit(1)
+		}
+
+		var signer *utils.SSHSigner
+		sign, _ := cmd.Flags().GetBool("gpg-sign")
+		noSign, _ := cmd.Flags().GetBool("no-gpg-sign")
+		if (sign || utils.ConfigGetBool(repo, "commit", "gpgSign")) && !noSign {
+			if signer, err = utils.SigningKey(repo, ""); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+		}
+
+		author, err := utils.AuthorIdent(repo, utils.Ident{})
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		committer, err := utils.CommitterIdent(repo)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+
+		tree := m.Tree(repo)
+		commit, err := utils.CommitCreate(repo, tree, append([]string{head}, remaining...), author, committer, message, signer)
+		if err != nil {
+			fmt.Printf("fatal: failed to sign the commit: %v\n", err)
+			os.Exit(128)
+		}
+
+		reflog := fmt.Sprintf("merge %s: Merge made by the '%s' strategy.", strings.Join(names, " "), strategy)
+		if err := utils.UpdateRef(repo, "HEAD", commit, reflog); err != nil {
+			fmt.Printf("fatal: couldn't update HEAD: %v\n", err)
+			os.Exit(128)
+		}
+		utils.MergeStateClear(repo)
+
+		fmt.Printf("Merge made by the '%s' strategy.\n", strategy)
+		utils.RunHook(repo, "post-merge", "", "0")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().Bool("ff", true, "fast-forward when HEAD is an ancestor of the rev, the default")
+	mergeCmd.Flags().Bool("no-ff", false, "always create a merge commit, even when a fast-forward is possible")
+	mergeCmd.Flags().Bool("ff-only", false, "refuse to merge unless HEAD can be fast-forwarded")
+	mergeCmd.Flags().Bool("squash", false, "merge into the index and worktree without committing or recording the merge")
+	mergeCmd.Flags().Bool("no-commit", false, "stop before creating the merge commit")
+	mergeCmd.Flags().StringArrayP("message", "m", nil, "message for the merge commit, each one a paragraph")
+	mergeCmd.Flags().BoolP("edit", "e", false, "edit the message of the merge commit in the editor")
+	mergeCmd.Flags().String("cleanup", "", "how to tidy the message: strip, whitespace, verbatim, scissors or default")
+	mergeCmd.Flags().Bool("no-verify", false, "skip the pre-merge-commit and commit-msg hooks")
+	mergeCmd.Flags().BoolP("gpg-sign", "S", false, "sign the merge commit with the ssh key in user.signingkey")
+	mergeCmd.Flags().Bool("no-gpg-sign", false, "don't sign the merge commit, even with commit.gpgSign set")
+	mergeCmd.Flags().Bool("abort", false, "give up on the merge in progress, going back to HEAD")
+	mergeCmd.Flags().Bool("continue", false, "commit the merge in progress once its conflicts are resolved")
+}
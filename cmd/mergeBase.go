@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var mergeBaseCmd = &cobra.Command{
+	Use:   "mergeBase [--all] COMMIT COMMIT... | --octopus COMMIT... | --is-ancestor COMMIT COMMIT | --fork-point REF [COMMIT]",
+	Short: "find as good common ancestors as possible for a merge",
+	Long: `prints the best common ancestor of the first COMMIT and the others, one that isn't an ancestor of another
+	common ancestor. criss-cross histories can have several, which --all prints.
+	--octopus gives the bases of all the COMMITs for an octopus merge, --is-ancestor exits with 0 when the first
+	COMMIT is an ancestor of the second and 1 otherwise, and --fork-point finds where COMMIT (HEAD by default)
+	forked from REF, taking into account the earlier values of REF in its reflog.
+	exits with 1 when there is no common ancestor.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		octopus, _ := cmd.Flags().GetBool("octopus")
+		isAncestor, _ := cmd.Flags().GetBool("is-ancestor")
+		forkPoint, _ := cmd.Flags().GetBool("fork-point")
+
+		repo := utils.RepoFind(".", true)
+
+		resolve := func(name string) string {
+			sha, err := revListCommit(repo, name)
+			if err != nil {
+				fmt.Printf("fatal: Not a valid commit name %v\n", name)
+				os.Exit(128)
+			}
+			return sha
+		}
+
+		var bases []string
+		var err error
+		switch {
+		case isAncestor:
+			if len(args) != 2 {
+				fmt.Print("usage: mergeBase --is-ancestor COMMIT COMMIT\n")
+				os.Exit(129)
+			}
+			ok, err := utils.IsAncestor(repo, resolve(args[0]), resolve(args[1]))
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			return
+
+		case forkPoint:
+			if len(args) < 1 || len(args) > 2 {
+				fmt.Print("usage: mergeBase --fork-point REF [COMMIT]\n")
+				os.Exit(129)
+			}
+			ref, _ := utils.DwimRef(repo, args[0])
+			if ref == "" {
+				fmt.Printf("fatal: Not a valid object name: '%v'\n", args[0])
+				os.Exit(128)
+			}
+			commit := "HEAD"
+			if len(args) == 2 {
+				commit = args[1]
+			}
+
+			sha, err := utils.ForkPoint(repo, ref, resolve(commit))
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			if sha == "" {
+				os.Exit(1)
+			}
+			fmt.Println(sha)
+			return
+
+		case octopus:
+			if len(args) < 1 {
+				fmt.Print("usage: mergeBase --octopus COMMIT...\n")
+				os.Exit(129)
+			}
+			var commits []string
+			for _, arg := range args {
+				commits = append(commits, resolve(arg))
+			}
+			bases, err = utils.MergeBasesOctopus(repo, commits...)
+
+		default:
+			if len(args) < 2 {
+				fmt.Print("usage: mergeBase [--all] COMMIT COMMIT...\n")
+				os.Exit(129)
+			}
+			var others []string
+			for _, arg := range args[1:] {
+				others = append(others, resolve(arg))
+			}
+			bases, err = utils.MergeBases(repo, resolve(args[0]), others...)
+		}
+
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if len(bases) == 0 {
+			os.Exit(1)
+		}
+		if !all {
+			bases = bases[:1]
+		}
+		for _, sha := range bases {
+			fmt.Println(sha)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeBaseCmd)
+
+	mergeBaseCmd.Flags().BoolP("all", "a", false, "print all the best common ancestors instead of one")
+	mergeBaseCmd.Flags().Bool("octopus", false, "find the bases for an octopus merge of all the commits")
+	mergeBaseCmd.Flags().Bool("is-ancestor", false, "exit with 0 when the first commit is an ancestor of the second")
+	mergeBaseCmd.Flags().Bool("fork-point", false, "find where a commit forked from REF, using the reflog of REF")
+}
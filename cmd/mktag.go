@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var mktagCmd = &cobra.Command{
+	Use:   "mktag",
+	Short: "create a tag object from its raw text",
+	Long: `reads a tag object from stdin, checks that it is well formed and that the object it names exists with the
+	type it says, then writes it and prints its sha. the tag ref itself is left to updateRef.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Printf("fatal: could not read from stdin: %v\n", err)
+			os.Exit(128)
+		}
+
+		sha, err := utils.MakeTag(repo, string(data))
+		if errors.Is(err, utils.ErrTagFsck) {
+			fmt.Printf("error: %v\n", err)
+			fmt.Println("fatal: tag on stdin did not pass our strict fsck check")
+			os.Exit(128)
+		}
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		fmt.Println(sha)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mktagCmd)
+}
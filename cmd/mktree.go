@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// mktreeParse reads a line of ls-tree output: mode, type, sha and, after a
+// tab, the path.
+func mktreeParse(line string) (utils.GitTreeLeaf, error) {
+	info, name, ok := strings.Cut(line, "\t")
+	fields := strings.Fields(info)
+	if !ok || len(fields) != 3 || name == "" || !utils.IsFullSha(fields[2]) {
+		return utils.GitTreeLeaf{}, fmt.Errorf("input format error: %s", line)
+	}
+	mode, kind, sha := fields[0], fields[1], strings.ToLower(fields[2])
+
+	want := "blob"
+	switch strings.TrimLeft(mode, "0") {
+	case "40000":
+		want = "tree"
+	case "160000":
+		want = "commit"
+	case "100644", "100755", "120000":
+	default:
+		return utils.GitTreeLeaf{}, fmt.Errorf("input format error: %s", line)
+	}
+	if kind != want {
+		return utils.GitTreeLeaf{}, fmt.Errorf("entry '%s' object type (%s) doesn't match mode type (%s)", name, kind, want)
+	}
+	return *utils.NewGitTreeLeaf(mode, name, sha), nil
+}
+
+var mktreeCmd = &cobra.Command{
+	Use:   "mktree [-z] [--missing]",
+	Short: "build a tree object from ls-tree formatted text",
+	Long: `reads lines in the format lsTree prints them, "<mode> <type> <sha>\t<path>", from stdin and writes the tree
+	holding those entries, printing its sha. the paths are entries of that tree itself, so they can't hold a slash.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		nulTerminated, _ := cmd.Flags().GetBool("z")
+		missing, _ := cmd.Flags().GetBool("missing")
+
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(nil, 1<<20)
+		if nulTerminated {
+			scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+				if i := bytes.IndexByte(data, 0); i >= 0 {
+					return i + 1, data[:i], nil
+				}
+				if atEOF && len(data) > 0 {
+					return len(data), data, nil
+				}
+				return 0, nil, nil
+			})
+		}
+
+		var leaves []utils.GitTreeLeaf
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			leaf, err := mktreeParse(scanner.Text())
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			leaves = append(leaves, leaf)
+		}
+
+		sha, err := utils.MakeTree(repo, leaves, missing)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		fmt.Println(sha)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mktreeCmd)
+
+	mktreeCmd.Flags().BoolP("z", "z", false, "the entries end with NUL instead of a newline")
+	mktreeCmd.Flags().Bool("missing", false, "allow objects that are not in the repository")
+}
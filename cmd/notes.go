@@ -0,0 +1,494 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// notesObject resolves the object a note is on, HEAD by default.
+func notesObject(repo utils.Repo, name string) string {
+	if name == "" {
+		name = "HEAD"
+	}
+	sha, err := utils.RevParse(repo, name)
+	if err != nil {
+		fmt.Printf("fatal: failed to resolve '%s' as a valid ref.\n", name)
+		os.Exit(128)
+	}
+	return sha
+}
+
+// notesWritable makes sure ref is one notes can be written to.
+func notesWritable(ref string, action string) {
+	if !strings.HasPrefix(ref, "refs/notes/") {
+		fmt.Printf("fatal: refusing to %s notes in %s (outside of refs/notes/)\n", action, ref)
+		os.Exit(128)
+	}
+}
+
+func notesRead(repo utils.Repo, ref string) *utils.Notes {
+	notes, err := utils.NotesReadRef(repo, ref)
+	if err != nil {
+		fmt.Printf("fatal: could not read notes from %s: %v\n", ref, err)
+		os.Exit(128)
+	}
+	return notes
+}
+
+// notesCommit records notes as a commit on top of parents and moves ref to
+// it, the reflog taking the first line of message.
+func notesCommit(repo utils.Repo, ref string, notes *utils.Notes, parents []string, message string) string {
+	author, err := utils.AuthorIdent(repo, utils.Ident{})
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	committer, err := utils.CommitterIdent(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	sha, err := utils.CommitCreate(repo, notes.Tree(repo), parents, author, committer, message, nil)
+	if err != nil {
+		fmt.Printf("fatal: failed to commit notes: %v\n", err)
+		os.Exit(128)
+	}
+	if ref != "" {
+		subject, _, _ := strings.Cut(message, "\n")
+		if err := utils.UpdateRef(repo, ref, sha, "notes: "+subject); err != nil {
+			fmt.Printf("fatal: cannot update %s: %v\n", ref, err)
+			os.Exit(128)
+		}
+	}
+	return sha
+}
+
+// notesUpdate commits notes on top of the ones they were read from.
+func notesUpdate(repo utils.Repo, ref string, notes *utils.Notes, message string) {
+	var parents []string
+	if notes.Commit != "" {
+		parents = []string{notes.Commit}
+	}
+	notesCommit(repo, ref, notes, parents, message)
+}
+
+// notesMessage is the text of a new note, from -m and -F, each a paragraph
+// of its own, or written in the editor.
+func notesMessage(cmd *cobra.Command, repo utils.Repo, object string, existing string) string {
+	messages, _ := cmd.Flags().GetStringArray("message")
+	file, _ := cmd.Flags().GetString("file")
+
+	paragraphs := append([]string(nil), messages...)
+	if file != "" {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			fmt.Printf("fatal: could not read '%s': %v\n", file, err)
+			os.Exit(128)
+		}
+		paragraphs = append(paragraphs, string(data))
+	}
+
+	if len(paragraphs) > 0 || cmd.Flags().Changed("message") {
+		var b strings.Builder
+		for _, p := range paragraphs {
+			p = utils.CleanupMessage(p, false)
+			if p == "" {
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(p)
+		}
+		return b.String()
+	}
+
+	path, err := utils.RepoFile(repo, false, "NOTES_EDITMSG")
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	template := existing + "\n#\n# Write/edit the notes for the following object:\n#\n# " + object + "\n"
+	if commit, ok := utils.ObjectRead(repo, object).(*utils.GitCommit); ok {
+		template = strings.TrimSuffix(template, "\n") + " " + pickSubject(commit.Data.Message) + "\n"
+	}
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if err := utils.EditFile(repo, path); err != nil {
+		fmt.Println("error: there was a problem with the editor")
+		fmt.Println("Please supply the note contents using either -m or -F option")
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	return utils.CleanupMessage(string(data), true)
+}
+
+// notesSet puts text as the note on object and commits it. an empty text
+// without allowEmpty removes the note instead.
+func notesSet(repo utils.Repo, ref string, notes *utils.Notes, object string, text string, allowEmpty bool, action string) {
+	if text == "" && !allowEmpty {
+		fmt.Printf("Removing note for object %s\n", object)
+		if _, ok := notes.Blobs[object]; ok {
+			delete(notes.Blobs, object)
+			notesUpdate(repo, ref, notes, fmt.Sprintf("Notes removed by 'git notes %s'\n", action))
+		}
+		return
+	}
+
+	blob := utils.GitBlob{}
+	blob.Deserialize(text)
+	notes.Blobs[object] = utils.ObjectWrite(&blob, repo)
+	notesUpdate(repo, ref, notes, fmt.Sprintf("Notes added by 'git notes %s'\n", action))
+}
+
+// notesGitdirPath is the path of name in the gitdir, relative to where the
+// command runs when it can be.
+func notesGitdirPath(repo utils.Repo, name string) string {
+	path := filepath.Join(repo.Gitdir, name)
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil {
+			return rel
+		}
+	}
+	return path
+}
+
+// notesMerge merges the notes of remote into ref. notes changed on both
+// sides are resolved with strategy; with manual the ones that conflict are
+// left in NOTES_MERGE_WORKTREE for notes merge --commit to pick up.
+func notesMerge(repo utils.Repo, ref string, remote string, strategy string) {
+	if utils.NotesMergeInProgress(repo) {
+		fmt.Printf("fatal: You have not concluded your previous notes merge (%s exists).\n", notesGitdirPath(repo, "NOTES_MERGE_*"))
+		fmt.Println("Please, use 'notes merge --commit' or 'notes merge --abort' to commit/abort the previous merge before you start a new notes merge.")
+		os.Exit(128)
+	}
+
+	local := utils.ResolveRef(repo, ref)
+	theirs := utils.ResolveRef(repo, remote)
+	message := fmt.Sprintf("Merged notes from %s into %s", remote, ref)
+
+	// nothing to merge, or nothing to merge into
+	if theirs == "" {
+		return
+	}
+	base := ""
+	if local != "" {
+		bases, err := utils.MergeBases(repo, local, theirs)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if len(bases) > 0 {
+			base = bases[0]
+		}
+	}
+	switch base {
+	case theirs:
+		fmt.Println("Already up to date.")
+		return
+	case local:
+		if err := utils.UpdateRef(repo, ref, theirs, "notes: "+message); err != nil {
+			fmt.Printf("fatal: cannot update %s: %v\n", ref, err)
+			os.Exit(128)
+		}
+		return
+	}
+
+	baseNotes, err := utils.NotesRead(repo, base)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	oursNotes, theirsNotes := notesRead(repo, ref), notesRead(repo, remote)
+	result, err := utils.NotesMerge(repo, baseNotes, oursNotes, theirsNotes, strategy, ref, remote)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	for _, line := range result.Messages {
+		fmt.Println(line)
+	}
+
+	if len(result.Conflicts) == 0 {
+		notesCommit(repo, ref, result.Notes, []string{local, theirs}, message)
+		return
+	}
+
+	// what merged cleanly waits in a commit of its own for the rest
+	objects := make([]string, 0, len(result.Conflicts))
+	for object := range result.Conflicts {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	message += "\n\nConflicts:\n"
+	for _, object := range objects {
+		message += "\t" + object + "\n"
+	}
+	partial := notesCommit(repo, "", result.Notes, []string{local, theirs}, message)
+	if err := utils.NotesMergeStateWrite(repo, partial, ref, result.Conflicts); err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	fmt.Printf("Automatic notes merge failed. Fix conflicts in %s and commit the result with 'notes merge --commit', or abort the merge with 'notes merge --abort'.\n",
+		notesGitdirPath(repo, utils.NotesMergeWorktree))
+	os.Exit(1)
+}
+
+// notesMergeCommit finishes a notes merge that stopped on conflicts, with
+// the notes in NOTES_MERGE_WORKTREE as they were resolved.
+func notesMergeCommit(repo utils.Repo) {
+	data, err := os.ReadFile(filepath.Join(repo.Gitdir, utils.NotesMergePartial))
+	if err != nil {
+		fmt.Printf("fatal: failed to read ref %s\n", utils.NotesMergePartial)
+		os.Exit(128)
+	}
+	partial := strings.TrimSpace(string(data))
+	commit, ok := utils.ObjectRead(repo, partial).(*utils.GitCommit)
+	if !ok {
+		fmt.Printf("fatal: could not parse commit from %s\n", utils.NotesMergePartial)
+		os.Exit(128)
+	}
+	ref, err := utils.ReadSymbolicRef(repo, utils.NotesMergeRef)
+	if err != nil {
+		fmt.Printf("fatal: failed to resolve %s\n", utils.NotesMergeRef)
+		os.Exit(128)
+	}
+
+	notes, err := utils.NotesRead(repo, partial)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	dir := filepath.Join(repo.Gitdir, utils.NotesMergeWorktree)
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if !utils.IsFullSha(f.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		blob := utils.GitBlob{}
+		blob.Deserialize(string(data))
+		notes.Blobs[f.Name()] = utils.ObjectWrite(&blob, repo)
+	}
+
+	notesCommit(repo, ref, notes, commit.Data.GetAll("parent"), commit.Data.Message)
+	utils.NotesMergeStateClear(repo)
+}
+
+var notesCmd = &cobra.Command{
+	Use:   "notes [--ref REF] [list [OBJECT] | show [OBJECT] | add [-f] [-m MSG | -F FILE] [OBJECT] | append [-m MSG | -F FILE] [OBJECT] | remove [--ignore-missing] [OBJECT...] | copy [-f] FROM TO | merge [-s STRATEGY] REF | merge --commit | merge --abort]",
+	Short: "add or inspect object notes",
+	Long: `notes are text attached to objects after the fact, without changing them. they are kept as blobs named after
+	the object in a fanout tree, committed under refs/notes/commits, or the ref given with --ref, GIT_NOTES_REF or
+	core.notesRef, the way git keeps them.
+	list prints each note and the object it is on, show prints the note on an object, add and append write one from
+	-m or -F or in the editor, remove, copy, and merge brings in the notes of another ref, resolving notes changed on
+	both sides with -s manual, ours, theirs, union or cat_sort_uniq.
+	log --notes shows the notes of the default ref and of those matching notes.displayRef.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		name, _ := cmd.Flags().GetString("ref")
+		ref := utils.NotesRef(repo, name)
+
+		action := "list"
+		if len(args) > 0 {
+			action, args = args[0], args[1:]
+		}
+		arg := func(n int) string {
+			if len(args) > n {
+				return args[n]
+			}
+			return ""
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
+
+		switch action {
+		case "list":
+			notes := notesRead(repo, ref)
+			if len(args) > 0 {
+				object := notesObject(repo, args[0])
+				blob, ok := notes.Blobs[object]
+				if !ok {
+					fmt.Printf("error: no note found for object %s.\n", object)
+					os.Exit(1)
+				}
+				fmt.Println(blob)
+				return
+			}
+			objects := make([]string, 0, len(notes.Blobs))
+			for object := range notes.Blobs {
+				objects = append(objects, object)
+			}
+			sort.Strings(objects)
+			for _, object := range objects {
+				fmt.Printf("%s %s\n", notes.Blobs[object], object)
+			}
+
+		case "show":
+			object := notesObject(repo, arg(0))
+			text, ok := notesRead(repo, ref).Note(repo, object)
+			if !ok {
+				fmt.Printf("error: no note found for object %s.\n", object)
+				os.Exit(1)
+			}
+			fmt.Print(text)
+
+		case "add":
+			notesWritable(ref, "add")
+			object := notesObject(repo, arg(0))
+			notes := notesRead(repo, ref)
+			existing, found := notes.Note(repo, object)
+			if found && !force {
+				fmt.Printf("error: Cannot add notes. Found existing notes for object %s. Use '-f' to overwrite existing notes\n", object)
+				os.Exit(1)
+			}
+			text := notesMessage(cmd, repo, object, existing)
+			if found {
+				fmt.Printf("Overwriting existing notes for object %s\n", object)
+			}
+			notesSet(repo, ref, notes, object, text, allowEmpty, "add")
+
+		case "append":
+			notesWritable(ref, "append")
+			object := notesObject(repo, arg(0))
+			notes := notesRead(repo, ref)
+			existing, _ := notes.Note(repo, object)
+			text := notesMessage(cmd, repo, object, "")
+			if existing != "" && text != "" {
+				text = existing + "\n" + text
+			} else if text == "" {
+				text = existing
+			}
+			notesSet(repo, ref, notes, object, text, allowEmpty, "append")
+
+		case "remove":
+			notesWritable(ref, "remove")
+			ignoreMissing, _ := cmd.Flags().GetBool("ignore-missing")
+			if len(args) == 0 {
+				args = []string{"HEAD"}
+			}
+			notes := notesRead(repo, ref)
+			removed, missing := false, false
+			for _, name := range args {
+				object := notesObject(repo, name)
+				if _, ok := notes.Blobs[object]; !ok {
+					fmt.Printf("Object %s has no note\n", name)
+					missing = true
+					continue
+				}
+				fmt.Printf("Removing note for object %s\n", name)
+				delete(notes.Blobs, object)
+				removed = true
+			}
+			if removed {
+				notesUpdate(repo, ref, notes, "Notes removed by 'git notes remove'\n")
+			}
+			if missing && !ignoreMissing {
+				os.Exit(1)
+			}
+
+		case "copy":
+			notesWritable(ref, "copy")
+			if len(args) != 2 {
+				fmt.Println("error: too few parameters")
+				os.Exit(129)
+			}
+			from, to := notesObject(repo, args[0]), notesObject(repo, args[1])
+			notes := notesRead(repo, ref)
+			blob, ok := notes.Blobs[from]
+			if !ok {
+				fmt.Printf("error: missing notes on source object %s. Cannot copy.\n", from)
+				os.Exit(1)
+			}
+			if _, found := notes.Blobs[to]; found {
+				if !force {
+					fmt.Printf("error: Cannot copy notes. Found existing notes for object %s. Use '-f' to overwrite existing notes\n", to)
+					os.Exit(1)
+				}
+				fmt.Printf("Overwriting existing notes for object %s\n", to)
+			}
+			notes.Blobs[to] = blob
+			notesUpdate(repo, ref, notes, "Notes added by 'git notes copy'\n")
+
+		case "merge":
+			if commit, _ := cmd.Flags().GetBool("commit"); commit {
+				notesMergeCommit(repo)
+				return
+			}
+			if abort, _ := cmd.Flags().GetBool("abort"); abort {
+				utils.NotesMergeStateClear(repo)
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("error: must specify a notes ref to merge")
+				os.Exit(129)
+			}
+			notesWritable(ref, "merge")
+
+			strategy, _ := cmd.Flags().GetString("strategy")
+			if strategy == "" {
+				strategy = utils.ConfigGet(repo, fmt.Sprintf("notes \"%s\"", strings.TrimPrefix(ref, "refs/notes/")), "mergeStrategy")
+			}
+			if strategy == "" {
+				strategy = utils.ConfigGet(repo, "notes", "mergeStrategy")
+			}
+			if strategy == "" {
+				strategy = "manual"
+			}
+			known := false
+			for _, s := range utils.NotesStrategies {
+				known = known || s == strategy
+			}
+			if !known {
+				fmt.Printf("error: unknown -s/--strategy: %s\n", strategy)
+				os.Exit(129)
+			}
+			notesMerge(repo, ref, utils.NotesRefName(args[0]), strategy)
+
+		default:
+			fmt.Printf("error: unknown subcommand: `%s'\n", action)
+			os.Exit(129)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notesCmd)
+
+	notesCmd.Flags().String("ref", "", "the notes ref to use, instead of refs/notes/commits")
+	notesCmd.Flags().StringArrayP("message", "m", nil, "add, append: the note, each one a paragraph of its own")
+	notesCmd.Flags().StringP("file", "F", "", "add, append: read the note from a file, - for stdin")
+	notesCmd.Flags().BoolP("force", "f", false, "add, copy: replace a note the object already has")
+	notesCmd.Flags().Bool("allow-empty", false, "add, append: keep an empty note instead of removing it")
+	notesCmd.Flags().Bool("ignore-missing", false, "remove: don't fail for objects without a note")
+	notesCmd.Flags().StringP("strategy", "s", "", "merge: how to resolve notes changed on both sides (manual, ours, theirs, union, cat_sort_uniq)")
+	notesCmd.Flags().Bool("commit", false, "merge: finish a merge once its conflicts are resolved")
+	notesCmd.Flags().Bool("abort", false, "merge: give up on a merge that stopped on conflicts")
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var packRefsCmd = &cobra.Command{
+	Use:   "packRefs [--all] [--no-prune]",
+	Short: "pack the loose refs into the packed-refs file",
+	Long: `moves the loose refs under refs/tags (and every other ref with --all) into packed-refs,
+	recording the peeled object of annotated tags. the loose files are removed unless --no-prune is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		noPrune, _ := cmd.Flags().GetBool("no-prune")
+
+		repo := utils.RepoFind(".", true)
+
+		err := utils.PackRefs(repo, all, !noPrune)
+		utils.ErrorHandler("couldn't pack refs", err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(packRefsCmd)
+
+	packRefsCmd.Flags().Bool("all", false, "pack every ref, not only the tags")
+	packRefsCmd.Flags().Bool("no-prune", false, "keep the loose ref files around")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var readTreeCmd = &cobra.Command{
+	Use:   "readTree [-m] [--prefix PREFIX] TREE...",
+	Short: "read tree information into the index",
+	Long: `replaces the index with the contents of a tree, or with --prefix adds it under that directory.
+	with -m one tree replaces the index keeping the stat data of unchanged entries, two trees move the index from
+	the first to the second keeping what is staged, and three trees merge the two last ones against the first,
+	leaving stages 1, 2 and 3 for the paths changed on both sides. the worktree is not touched.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		merge, _ := cmd.Flags().GetBool("merge")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		if merge && cmd.Flags().Changed("prefix") {
+			fmt.Println("fatal: Which one? -m or --prefix?")
+			os.Exit(128)
+		}
+		if len(args) == 0 || (!merge && len(args) > 1) {
+			fmt.Println("Usage: readTree [-m] [--prefix PREFIX] TREE...")
+			os.Exit(129)
+		}
+
+		var trees []string
+		for _, arg := range args {
+			tree := utils.ObjectFind(repo, arg, "tree", true)
+			if tree == "" {
+				fmt.Printf("fatal: failed to unpack tree object %s\n", arg)
+				os.Exit(128)
+			}
+			trees = append(trees, tree)
+		}
+
+		index, err := utils.IndexRead(repo)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if merge {
+			err = utils.ReadTreeMerge(repo, index, trees)
+		} else {
+			err = utils.ReadTree(repo, index, trees[0], prefix)
+		}
+		if errors.Is(err, utils.ErrIndexUnmerged) {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(128)
+		}
+
+		if err := utils.IndexWrite(repo, *index); err != nil {
+			fmt.Printf("fatal: unable to write new index file: %v\n", err)
+			os.Exit(128)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(readTreeCmd)
+
+	readTreeCmd.Flags().BoolP("merge", "m", false, "merge one to three trees into the index instead of replacing it")
+	readTreeCmd.Flags().String("prefix", "", "read the tree under this directory, keeping the rest of the index")
+}
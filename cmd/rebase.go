@@ -0,0 +1,720 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// rebaseDirty tells why the worktree isn't clean enough to start a rebase,
+// "" when it is.
+func rebaseDirty(repo utils.Repo) (string, error) {
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return "", err
+	}
+	if len(mergeUnmerged(*index)) > 0 {
+		return "You have unmerged files.", nil
+	}
+
+	headTree := utils.EmptyTreeSha
+	if head := utils.ResolveRef(repo, "HEAD"); head != "" {
+		if headTree, err = utils.PeelTo(repo, head, "tree"); err != nil {
+			return "", err
+		}
+	}
+	if utils.TreeFromIndex(repo, *index) != headTree {
+		return "Your index contains uncommitted changes.", nil
+	}
+	if modified, deleted := commitTrackedChanges(repo, *index); len(modified)+len(deleted) > 0 {
+		return "You have unstaged changes.", nil
+	}
+	return "", nil
+}
+
+// rebaseSubject is the first line of the message of commit sha.
+func rebaseSubject(repo utils.Repo, sha string) string {
+	commit, ok := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+	if !ok {
+		return ""
+	}
+	return pickSubject(commit.Data.Message)
+}
+
+// rebaseSquashMessage is the message of a squash or fixup chain so far: the
+// message of the commit it folds into, then those of the squashes, with the
+// fixups' commented out.
+func rebaseSquashMessage(repo utils.Repo, state *utils.RebaseState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# This is a combination of %d commits.\n", len(state.Fixups)+1)
+	b.WriteString("# This is the 1st commit message:\n\n")
+	b.WriteString(strings.TrimRight(state.SquashMessage, "\n") + "\n")
+
+	for i, item := range state.Fixups {
+		message := ""
+		if commit, ok := utils.ObjectRead(repo, item.Sha).(*utils.GitCommit); ok {
+			message = strings.TrimRight(commit.Data.Message, "\n")
+		}
+
+		if item.Command == "squash" {
+			// the subject of a squash! commit only named what to squash into
+			if subject, body, _ := strings.Cut(message, "\n"); strings.HasPrefix(subject, "squash! ") || strings.HasPrefix(subject, "fixup! ") {
+				message = "# " + subject + "\n" + body
+			}
+			fmt.Fprintf(&b, "\n# This is the commit message #%d:\n\n%s\n", i+2, message)
+			continue
+		}
+		fmt.Fprintf(&b, "\n# The commit message #%d will be skipped:\n\n", i+2)
+		for _, line := range strings.Split(message, "\n") {
+			b.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// rebaseEditMessage opens message in the editor, for reword and the end of
+// a squash chain.
+func rebaseEditMessage(repo utils.Repo, message string, source string) (string, error) {
+	return commitMessage(repo, commitMessageOptions{prepared: message, source: source}, func(mode string) string {
+		if mode == "whitespace" || mode == "verbatim" {
+			return "# Please enter the commit message for your changes. Lines starting\n" +
+				"# with '#' will be kept; you may remove them yourself if you want to.\n"
+		}
+		return "# Please enter the commit message for your changes. Lines starting\n" +
+			"# with '#' will be ignored, and an empty message aborts the commit.\n"
+	})
+}
+
+// rebaseCommit commits tree for item on top of HEAD: a new commit with the
+// author and message of the original for pick, reword and edit, or HEAD
+// amended for squash and fixup. a pick that changes nothing anymore is
+// dropped, unless the original was empty to begin with. the reflog names
+// action, the command or "continue" after a stop.
+func rebaseCommit(repo utils.Repo, state *utils.RebaseState, item utils.RebaseItem, tree string, action string) error {
+	original, ok := utils.ObjectRead(repo, item.Sha).(*utils.GitCommit)
+	if !ok {
+		return fmt.Errorf("could not read commit %v", item.Sha)
+	}
+	head := utils.ResolveRef(repo, "HEAD")
+	headCommit, ok := utils.ObjectRead(repo, head).(*utils.GitCommit)
+	if !ok {
+		return fmt.Errorf("could not read HEAD")
+	}
+
+	parents := []string{head}
+	authorLine := original.Data.Get("author")
+	message := original.Data.Message
+
+	switch item.Command {
+	case "squash", "fixup":
+		if len(state.Fixups) == 0 {
+			state.SquashMessage = headCommit.Data.Message
+		}
+		state.Fixups = append(state.Fixups, item)
+
+		// HEAD is replaced, keeping its parents and author
+		parents = headCommit.Data.GetAll("parent")
+		authorLine = headCommit.Data.Get("author")
+
+		squashed := false
+		for _, f := range state.Fixups {
+			squashed = squashed || f.Command == "squash"
+		}
+		last := len(state.Todo) == 0 || (state.Todo[0].Command != "squash" && state.Todo[0].Command != "fixup")
+
+		message = commitCleanup(rebaseSquashMessage(repo, state), "strip", true)
+		if last && squashed {
+			var err error
+			if message, err = rebaseEditMessage(repo, rebaseSquashMessage(repo, state), "squash"); err != nil {
+				return err
+			}
+		}
+		if last {
+			state.Fixups, state.SquashMessage = nil, ""
+		}
+
+	default:
+		headTree, err := utils.PeelTo(repo, head, "tree")
+		if err != nil {
+			return err
+		}
+		if tree == headTree {
+			parentTree := utils.EmptyTreeSha
+			if parent := original.Data.Get("parent"); parent != "" {
+				parentTree, _ = utils.PeelTo(repo, parent, "tree")
+			}
+			if original.Data.Get("tree") != parentTree {
+				return nil
+			}
+		}
+
+		if item.Command == "reword" {
+			if message, err = rebaseEditMessage(repo, message, "message"); err != nil {
+				return err
+			}
+		}
+	}
+
+	base, _ := utils.ParseIdent(authorLine)
+	author, err := utils.AuthorIdent(repo, base)
+	if err != nil {
+		return err
+	}
+	committer, err := utils.CommitterIdent(repo)
+	if err != nil {
+		return err
+	}
+
+	sha, err := utils.CommitCreate(repo, tree, parents, author, committer, message, nil)
+	if err != nil {
+		return err
+	}
+	reflog := fmt.Sprintf("rebase (%s): %s", action, pickSubject(message))
+	return utils.UpdateRef(repo, "HEAD", sha, reflog)
+}
+
+// rebasePick replays one commit onto HEAD. it tells whether the rebase has
+// to stop, with the exit code to stop with: 1 for conflicts, 0 for edit.
+func rebasePick(repo utils.Repo, state *utils.RebaseState, item utils.RebaseItem) (bool, int, error) {
+	commit, ok := utils.ObjectRead(repo, item.Sha).(*utils.GitCommit)
+	if !ok {
+		return false, 0, fmt.Errorf("could not read commit %v", item.Sha)
+	}
+	short := item.Sha[:7]
+	subject := pickSubject(commit.Data.Message)
+
+	parents := commit.Data.GetAll("parent")
+	if len(parents) > 1 {
+		return false, 0, fmt.Errorf("commit %s is a merge, which rebase can't replay", short)
+	}
+	head := utils.ResolveRef(repo, "HEAD")
+
+	// squash and fixup fold into the last commit this rebase made, so HEAD
+	// must have moved off onto, which a drop or a skipped pick leaves it on
+	if (item.Command == "squash" || item.Command == "fixup") && head == state.Onto {
+		return false, 0, fmt.Errorf("cannot '%s' without a previous commit", item.Command)
+	}
+	stop := func(code int) (bool, int, error) {
+		state.StoppedSha = item.Sha
+		return true, code, utils.RebaseHeadWrite(repo, item.Sha)
+	}
+
+	// a commit already on top of HEAD is kept as it is
+	fastForward := len(parents) == 1 && parents[0] == head && (item.Command == "pick" || item.Command == "edit")
+	if fastForward {
+		if err := checkoutSwitch(repo, item.Sha); err != nil {
+			return false, 0, err
+		}
+		if err := utils.UpdateRef(repo, "HEAD", item.Sha, "rebase: fast-forward"); err != nil {
+			return false, 0, err
+		}
+	} else {
+		commitTree, err := utils.PeelTo(repo, item.Sha, "tree")
+		if err != nil {
+			return false, 0, err
+		}
+		parentTree := ""
+		if len(parents) == 1 {
+			if parentTree, err = utils.PeelTo(repo, parents[0], "tree"); err != nil {
+				return false, 0, err
+			}
+		}
+		headTree, err := utils.PeelTo(repo, head, "tree")
+		if err != nil {
+			return false, 0, err
+		}
+
+		label := fmt.Sprintf("%s (%s)", short, subject)
+		opts := utils.MergeOptionsFor(repo, "HEAD", label)
+		opts.Base = "parent of " + label
+		m, err := utils.MergeTrees(repo, parentTree, headTree, commitTree, opts)
+		if err != nil {
+			return false, 0, err
+		}
+		if err := mergeApply(repo, head, m, "rebase"); err != nil {
+			return false, 0, err
+		}
+		// the merge is only worth telling about when it conflicts
+		if !m.Clean() {
+			for _, line := range m.Messages {
+				fmt.Println(line)
+			}
+			fmt.Printf("error: could not apply %s... %s\n", short, subject)
+			fmt.Print("hint: Resolve all conflicts manually, mark them as resolved with\n" +
+				"hint: \"add/rm <conflicted_files>\", then run \"rebase --continue\".\n" +
+				"hint: You can instead skip this commit: run \"rebase --skip\".\n" +
+				"hint: To abort and get back to the state before \"rebase\", run \"rebase --abort\".\n")
+			fmt.Printf("Could not apply %s... %s\n", short, subject)
+			return stop(1)
+		}
+		if err := rebaseCommit(repo, state, item, m.Tree(repo), item.Command); err != nil {
+			return false, 0, err
+		}
+	}
+
+	if item.Command == "edit" {
+		state.Amend = utils.ResolveRef(repo, "HEAD")
+		fmt.Printf("Stopped at %s...  %s\n", short, subject)
+		fmt.Print("You can amend the commit now, with\n\n  commit --amend \n\n" +
+			"Once you are satisfied with your changes, run\n\n  rebase --continue\n")
+		return stop(0)
+	}
+	return false, 0, nil
+}
+
+// rebaseSave writes the state, giving up on the rebase when that fails.
+func rebaseSave(repo utils.Repo, state *utils.RebaseState) {
+	if err := utils.RebaseWrite(repo, state); err != nil {
+		fmt.Printf("fatal: could not save the rebase state: %v\n", err)
+		os.Exit(128)
+	}
+}
+
+// rebaseRun works through the todo list, saving the state before each step
+// so that a stop or a failure can be picked up with --continue.
+func rebaseRun(repo utils.Repo, state *utils.RebaseState) {
+	for len(state.Todo) > 0 {
+		item := state.Todo[0]
+		state.Todo = state.Todo[1:]
+		state.Done = append(state.Done, item)
+		rebaseSave(repo, state)
+
+		switch item.Command {
+		case "drop":
+
+		case "exec":
+			fmt.Printf("Executing: %s\n", item.Rest)
+			run := exec.Command("sh", "-c", item.Rest)
+			run.Dir = repo.Worktree
+			run.Stdin, run.Stdout, run.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := run.Run(); err != nil {
+				fmt.Printf("warning: execution failed: %s\n"+
+					"You can fix the problem, and then run\n\n  rebase --continue\n\n", item.Rest)
+				os.Exit(1)
+			}
+
+		default:
+			stopped, code, err := rebasePick(repo, state, item)
+			if err != nil {
+				// put the commit back, --continue tries it again
+				state.Todo = append([]utils.RebaseItem{item}, state.Todo...)
+				state.Done = state.Done[:len(state.Done)-1]
+				rebaseSave(repo, state)
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+			if stopped {
+				rebaseSave(repo, state)
+				os.Exit(code)
+			}
+		}
+	}
+
+	rebaseFinish(repo, state)
+}
+
+// rebaseFinish moves the branch to where the rebase got and attaches HEAD
+// to it again.
+func rebaseFinish(repo utils.Repo, state *utils.RebaseState) {
+	head := utils.ResolveRef(repo, "HEAD")
+
+	if strings.HasPrefix(state.HeadName, "refs/") {
+		// the branch has to be where the rebase started from, or whatever
+		// moved it since would be lost
+		message := fmt.Sprintf("rebase (finish): %s onto %s", state.HeadName, state.Onto)
+		t := utils.NewRefTransaction(repo)
+		t.Update(state.HeadName, head, state.OrigHead, message)
+		if err := t.Commit(); err != nil {
+			fmt.Printf("fatal: could not update %v: %v\n", state.HeadName, err)
+			os.Exit(128)
+		}
+		if err := utils.WriteSymbolicRef(repo, "HEAD", state.HeadName, "rebase (finish): returning to "+state.HeadName); err != nil {
+			fmt.Printf("fatal: could not attach HEAD to %v: %v\n", state.HeadName, err)
+			os.Exit(128)
+		}
+	}
+
+	utils.RebaseClear(repo)
+	fmt.Printf("Successfully rebased and updated %s.\n", state.HeadName)
+}
+
+// rebaseAmend replaces the commit an edit stopped at with one of tree.
+func rebaseAmend(repo utils.Repo, head string, tree string) error {
+	commit, ok := utils.ObjectRead(repo, head).(*utils.GitCommit)
+	if !ok {
+		return fmt.Errorf("could not read HEAD")
+	}
+	base, _ := utils.ParseIdent(commit.Data.Get("author"))
+	author, err := utils.AuthorIdent(repo, base)
+	if err != nil {
+		return err
+	}
+	committer, err := utils.CommitterIdent(repo)
+	if err != nil {
+		return err
+	}
+
+	sha, err := utils.CommitCreate(repo, tree, commit.Data.GetAll("parent"), author, committer, commit.Data.Message, nil)
+	if err != nil {
+		return err
+	}
+	return utils.UpdateRef(repo, "HEAD", sha, "rebase (continue): "+pickSubject(commit.Data.Message))
+}
+
+// rebaseContinue commits what was resolved or amended where the rebase
+// stopped and goes on with the rest of the todo list.
+func rebaseContinue(repo utils.Repo, state *utils.RebaseState) {
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if unmerged := mergeUnmerged(*index); len(unmerged) > 0 {
+		for _, name := range unmerged {
+			fmt.Printf("%s: needs merge\n", name)
+		}
+		fmt.Print("You must edit all merge conflicts and then\nmark them as resolved using add\n")
+		os.Exit(1)
+	}
+
+	head := utils.ResolveRef(repo, "HEAD")
+	headTree, err := utils.PeelTo(repo, head, "tree")
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	tree := utils.TreeFromIndex(repo, *index)
+
+	switch {
+	case state.Amend != "":
+		// staged changes after an edit stop go into the commit it stopped at
+		if tree != headTree {
+			if head != state.Amend {
+				fmt.Print("error: you have staged changes in your working tree\n" +
+					"commit them, or reset them away, and then run 'rebase --continue' again.\n")
+				os.Exit(1)
+			}
+			if err := rebaseAmend(repo, head, tree); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+	case state.StoppedSha != "" && len(state.Done) > 0:
+		// a squash or fixup still folds its message in when nothing changed
+		item := state.Done[len(state.Done)-1]
+		if tree != headTree || item.Command == "squash" || item.Command == "fixup" {
+			if err := rebaseCommit(repo, state, item, tree, "continue"); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	state.StoppedSha, state.Amend = "", ""
+	os.Remove(filepath.Join(repo.Gitdir, "REBASE_HEAD"))
+	rebaseRun(repo, state)
+}
+
+// rebaseAbort puts the branch, HEAD, index and worktree back the way they
+// were before the rebase.
+func rebaseAbort(repo utils.Repo, state *utils.RebaseState) error {
+	if err := mergeAbort(repo); err != nil {
+		return err
+	}
+	if err := checkoutSwitch(repo, state.OrigHead); err != nil {
+		return err
+	}
+
+	message := "rebase (abort): returning to " + state.HeadName
+	if strings.HasPrefix(state.HeadName, "refs/") {
+		if err := utils.WriteSymbolicRef(repo, "HEAD", state.HeadName, message); err != nil {
+			return err
+		}
+	} else if err := utils.DetachHead(repo, state.OrigHead, "rebase (abort): returning to "+state.OrigHead); err != nil {
+		return err
+	}
+
+	utils.RebaseClear(repo)
+	return nil
+}
+
+// rebaseSwitch checks out the branch to rebase, or detaches HEAD at the
+// commit given instead of one.
+func rebaseSwitch(repo utils.Repo, name string) error {
+	sha, err := revListCommit(repo, name)
+	if err != nil {
+		return fmt.Errorf("no such branch/commit '%v'", name)
+	}
+	if err := checkoutSwitch(repo, sha); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("checkout: moving from %s to %s", checkoutShortName(repo), name)
+	if branch := "refs/heads/" + name; utils.ResolveRef(repo, branch) != "" {
+		return utils.WriteSymbolicRef(repo, "HEAD", branch, message)
+	}
+	return utils.DetachHead(repo, sha, message)
+}
+
+// rebaseTodo lists the commits of upstream..head to replay, oldest first,
+// leaving out merges as git does.
+func rebaseTodo(repo utils.Repo, upstream string, head string) ([]utils.RebaseItem, error) {
+	walk := utils.NewRevWalk(repo)
+	walk.Order = utils.RevOrderTopo
+	walk.Reverse = true
+	walk.Push(head)
+	walk.Hide(upstream)
+
+	shas, err := walk.Walk()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []utils.RebaseItem
+	for _, sha := range shas {
+		if len(utils.CommitParents(repo, sha)) > 1 {
+			continue
+		}
+		items = append(items, utils.RebaseItem{Command: "pick", Sha: sha, Rest: rebaseSubject(repo, sha)})
+	}
+	return items, nil
+}
+
+// rebaseUpToDate tells whether replaying the commits would give them back
+// unchanged: onto is where they already start from, in a line.
+func rebaseUpToDate(repo utils.Repo, onto string, head string, todo []utils.RebaseItem) bool {
+	if ok, err := utils.IsAncestor(repo, onto, head); err != nil || !ok {
+		return false
+	}
+	walk := utils.NewRevWalk(repo)
+	walk.Push(head)
+	walk.Hide(onto)
+	shas, err := walk.Walk()
+	if err != nil || len(shas) != len(todo) {
+		return false
+	}
+	for _, sha := range shas {
+		if len(utils.CommitParents(repo, sha)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase [-i] [--autosquash] [--onto <newbase>] [<upstream> [<branch>]] | --continue | --skip | --abort",
+	Short: "reapply commits on top of another base tip",
+	Long: `replays the commits of upstream..HEAD, oldest first, on top of upstream, or of newbase with --onto, each
+as a three-way merge of its changes, then moves the branch there. with a branch it is checked out first.
+upstream defaults to the branch's upstream. merges are left out.
+-i opens the list of commits in the editor to reorder them, or to change pick to reword, edit, squash,
+fixup or drop, and to add exec lines. --autosquash moves the "fixup! ..." and "squash! ..." commits after the
+commit they name, as fixups and squashes.
+a conflict or an edit stops the rebase with HEAD detached; resolve or amend, add the files and run
+--continue, or drop the commit with --skip, or go back to where it all started with --abort.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		cont, _ := cmd.Flags().GetBool("continue")
+		skip, _ := cmd.Flags().GetBool("skip")
+		abort, _ := cmd.Flags().GetBool("abort")
+
+		if cont || skip || abort {
+			state, err := utils.RebaseRead(repo)
+			if err != nil {
+				fmt.Println("fatal: No rebase in progress?")
+				os.Exit(128)
+			}
+
+			switch {
+			case abort:
+				if err := rebaseAbort(repo, state); err != nil {
+					fmt.Printf("error: %v\n", err)
+					os.Exit(1)
+				}
+			case skip:
+				if err := mergeAbort(repo); err != nil {
+					fmt.Printf("error: %v\n", err)
+					os.Exit(1)
+				}
+				// a skipped fixup can still end the chain it was in
+				if len(state.Todo) == 0 || (state.Todo[0].Command != "squash" && state.Todo[0].Command != "fixup") {
+					state.Fixups, state.SquashMessage = nil, ""
+				}
+				state.StoppedSha, state.Amend = "", ""
+				os.Remove(filepath.Join(repo.Gitdir, "REBASE_HEAD"))
+				rebaseRun(repo, state)
+			default:
+				rebaseContinue(repo, state)
+			}
+			return
+		}
+
+		if utils.RebaseInProgress(repo) {
+			fmt.Print("fatal: It seems that there is already a rebase-merge directory, and\n" +
+				"I wonder if you are in the middle of another rebase.\n" +
+				"hint: try \"rebase (--continue | --abort | --skip)\"\n")
+			os.Exit(128)
+		}
+		if len(args) > 2 {
+			fmt.Print("usage: rebase [-i] [--autosquash] [--onto <newbase>] [<upstream> [<branch>]]\n")
+			os.Exit(129)
+		}
+
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		autosquash, _ := cmd.Flags().GetBool("autosquash")
+		if !cmd.Flags().Changed("autosquash") && interactive {
+			autosquash = utils.ConfigGetBool(repo, "rebase", "autoSquash")
+		}
+
+		// with a branch, it is checked out first
+		if len(args) == 2 {
+			if err := rebaseSwitch(repo, args[1]); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+		}
+
+		upstreamName := ""
+		if len(args) > 0 {
+			upstreamName = args[0]
+		} else if ref, err := utils.Upstream(repo, ""); err == nil {
+			upstreamName = ref
+		} else {
+			fmt.Print("There is no tracking information for the current branch.\n" +
+				"Please specify which branch you want to rebase against.\n")
+			os.Exit(1)
+		}
+		upstream, err := revListCommit(repo, upstreamName)
+		if err != nil {
+			fmt.Printf("fatal: invalid upstream '%v'\n", upstreamName)
+			os.Exit(128)
+		}
+
+		onto, ontoName := upstream, upstreamName
+		if name, _ := cmd.Flags().GetString("onto"); name != "" {
+			if onto, err = revListCommit(repo, name); err != nil {
+				fmt.Printf("fatal: Does not point to a valid commit '%v'\n", name)
+				os.Exit(128)
+			}
+			ontoName = name
+		}
+
+		head := utils.ResolveRef(repo, "HEAD")
+		if head == "" {
+			fmt.Println("fatal: no commits on the current branch to rebase")
+			os.Exit(128)
+		}
+		headName := utils.HeadBranch(repo)
+		if headName == "" {
+			headName = "detached HEAD"
+		}
+
+		if reason, err := rebaseDirty(repo); err != nil || reason != "" {
+			if err != nil {
+				reason = err.Error()
+			}
+			fmt.Printf("error: cannot rebase: %s\nerror: Please commit or stash them.\n", reason)
+			os.Exit(1)
+		}
+
+		todo, err := rebaseTodo(repo, upstream, head)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if autosquash {
+			todo = utils.AutosquashTodo(repo, todo)
+		}
+
+		if !interactive && !autosquash && rebaseUpToDate(repo, onto, head, todo) {
+			if headName == "detached HEAD" {
+				fmt.Println("HEAD is up to date.")
+			} else {
+				fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+			}
+			return
+		}
+
+		state := &utils.RebaseState{HeadName: headName, Onto: onto, OrigHead: head, Interactive: interactive}
+
+		if interactive {
+			path, err := utils.RepoFile(repo, true, "rebase-merge", "git-rebase-todo")
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			what := fmt.Sprintf("%s..%s onto %s", upstream[:7], head[:7], onto[:7])
+			content := utils.FormatRebaseTodo(todo, true) + utils.RebaseTodoHelp(what, len(todo))
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+
+			err = utils.EditFileWith(utils.SequenceEditor(repo), path)
+			if err == nil {
+				var data []byte
+				if data, err = os.ReadFile(path); err == nil {
+					todo, err = utils.ParseRebaseTodo(repo, string(data))
+				}
+			}
+			if err != nil {
+				utils.RebaseClear(repo)
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+			if len(todo) == 0 {
+				utils.RebaseClear(repo)
+				fmt.Println("error: nothing to do")
+				os.Exit(1)
+			}
+		}
+		state.Todo = todo
+
+		if path, err := utils.RepoFile(repo, false, "ORIG_HEAD"); err == nil {
+			os.WriteFile(path, []byte(head+"\n"), 0644)
+		}
+
+		// picks of commits already on onto need no replaying, onto moves up
+		for len(state.Todo) > 0 && state.Todo[0].Command == "pick" {
+			parents := utils.CommitParents(repo, state.Todo[0].Sha)
+			if len(parents) != 1 || parents[0] != state.Onto {
+				break
+			}
+			state.Onto = state.Todo[0].Sha
+			state.Done = append(state.Done, state.Todo[0])
+			state.Todo = state.Todo[1:]
+		}
+
+		if err := checkoutSwitch(repo, state.Onto); err != nil {
+			utils.RebaseClear(repo)
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := utils.DetachHead(repo, state.Onto, "rebase (start): checkout "+ontoName); err != nil {
+			utils.RebaseClear(repo)
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		state.Onto = onto
+
+		rebaseRun(repo, state)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+
+	rebaseCmd.Flags().String("onto", "", "replay the commits onto newbase instead of upstream")
+	rebaseCmd.Flags().BoolP("interactive", "i", false, "edit the list of commits to replay before starting")
+	rebaseCmd.Flags().Bool("autosquash", false, "move fixup! and squash! commits after the commits they name")
+	rebaseCmd.Flags().Bool("continue", false, "go on after resolving a conflict or amending a commit")
+	rebaseCmd.Flags().Bool("skip", false, "drop the commit that stopped and go on with the rest")
+	rebaseCmd.Flags().Bool("abort", false, "give up, going back to the branch as it was before the rebase")
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+func reflogShow(repo utils.Repo, name string) {
+	entries, err := utils.ReflogRead(repo, utils.ReflogRef(repo, name))
+	if err != nil {
+		utils.ErrorHandler("couldn't read the reflog", err)
+		return
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		n := len(entries) - 1 - i
+		fmt.Printf("%s %s@{%d}: %s\n", entries[i].New[:7], name, n, entries[i].Message)
+	}
+}
+
+// reflogAll lists every ref that has a reflog.
+func reflogAll(repo utils.Repo) []string {
+	var refs []string
+	logs := filepath.Join(repo.Gitdir, "logs")
+
+	filepath.WalkDir(logs, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(logs, path)
+			refs = append(refs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return refs
+}
+
+func reflogExpire(repo utils.Repo, refs []string, expire string) error {
+	if expire == "never" {
+		return nil
+	}
+
+	var cutoff time.Time
+	if expire == "all" {
+		cutoff = time.Now().Add(time.Hour)
+	} else {
+		var err error
+		cutoff, err = utils.Approxidate(expire, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range refs {
+		ref = utils.ReflogRef(repo, ref)
+		entries, err := utils.ReflogRead(repo, ref)
+		if err != nil {
+			return err
+		}
+
+		var kept []utils.ReflogEntry
+		for _, e := range entries {
+			if e.Time.After(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+
+		if len(kept) != len(entries) {
+			if err := utils.ReflogWrite(repo, ref, kept); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reflogDelete removes single entries given as <ref>@{<n>}.
+func reflogDelete(repo utils.Repo, specs []string) error {
+	drop := make(map[string][]int)
+
+	for _, spec := range specs {
+		name, index, ok := strings.Cut(spec, "@{")
+		n, err := strconv.Atoi(strings.TrimSuffix(index, "}"))
+		if !ok || err != nil || !strings.HasSuffix(index, "}") {
+			return fmt.Errorf("not a reflog entry: %v", spec)
+		}
+		if name == "" {
+			name = "HEAD"
+		}
+
+		ref := utils.ReflogRef(repo, name)
+		drop[ref] = append(drop[ref], n)
+	}
+
+	for ref, indexes := range drop {
+		entries, err := utils.ReflogRead(repo, ref)
+		if err != nil {
+			return err
+		}
+
+		// @{0} is the newest entry, at the end of the file
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+		for _, n := range indexes {
+			pos := len(entries) - 1 - n
+			if pos < 0 || pos >= len(entries) {
+				return fmt.Errorf("no reflog entry %v@{%d}", ref, n)
+			}
+			entries = append(entries[:pos], entries[pos+1:]...)
+		}
+
+		if err := utils.ReflogWrite(repo, ref, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var reflogCmd = &cobra.Command{
+	Use:   "reflog [show [REF]] | expire [--expire TIME] [--all] [REF...] | delete REF@{N}...",
+	Short: "manage the reflog, the history of where refs pointed",
+	Long: `show lists the reflog of REF (default HEAD), newest entry first. those entries can be used as
+	revisions, REF@{N} being the Nth prior value and REF@{yesterday} or REF@{2.days.ago} the value at that time.
+	expire drops the entries older than --expire (default 90.days.ago, "all" and "never" also work),
+	delete removes single entries.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		action := "show"
+		if len(args) > 0 && (args[0] == "show" || args[0] == "expire" || args[0] == "delete") {
+			action = args[0]
+			args = args[1:]
+		}
+
+		var err error
+		switch action {
+		case "show":
+			ref := "HEAD"
+			if len(args) > 0 {
+				ref = args[0]
+			}
+			reflogShow(repo, ref)
+
+		case "expire":
+			expire, _ := cmd.Flags().GetString("expire")
+			all, _ := cmd.Flags().GetBool("all")
+			if all {
+				args = reflogAll(repo)
+			}
+			err = reflogExpire(repo, args, expire)
+
+		case "delete":
+			if len(args) == 0 {
+				fmt.Print("Usage: reflog delete REF@{N}...\n")
+				return
+			}
+			err = reflogDelete(repo, args)
+		}
+
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reflogCmd)
+
+	reflogCmd.Flags().String("expire", "90.days.ago", "expire the entries older than this")
+	reflogCmd.Flags().Bool("all", false, "expire the reflogs of every ref")
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// revListCommit resolves a revision to the commit it names.
+func revListCommit(repo utils.Repo, name string) (string, error) {
+	if name == "" {
+		name = "HEAD"
+	}
+	sha, err := utils.RevParse(repo, name)
+	if err != nil {
+		return "", err
+	}
+	return utils.PeelTo(repo, sha, "commit")
+}
+
+// revListSetup feeds the revision arguments to the walk: A..B, A...B, ^A,
+// --not and --all. the other arguments are returned untouched.
+func revListSetup(repo utils.Repo, walk *utils.RevWalk, args []string) ([]string, int, error) {
+	negate := false
+	revs := 0
+	var rest []string
+
+	add := func(name string, hide bool) error {
+		sha, err := revListCommit(repo, name)
+		if err != nil {
+			return err
+		}
+		if hide != negate {
+			walk.Hide(sha)
+		} else {
+			walk.Push(sha)
+		}
+		revs++
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "-n" || arg == "--max-count" || arg == "--skip":
+			// the value that follows isn't a revision
+			rest = append(rest, arg)
+			if i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+
+		case arg == "--not":
+			negate = !negate
+
+		case arg == "--all":
+			if head := utils.ResolveRef(repo, "HEAD"); head != "" {
+				if err := add(head, false); err != nil {
+					return nil, 0, err
+				}
+			}
+			refs := utils.ListRefs(repo, "refs/")
+			for _, name := range utils.SortedRefNames(refs) {
+				// refs to trees or blobs have no history to list
+				if sha, err := utils.PeelTo(repo, refs[name], "commit"); err == nil {
+					if err := add(sha, false); err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+
+		case strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+
+		case strings.HasPrefix(arg, "^"):
+			if err := add(arg[1:], true); err != nil {
+				return nil, 0, err
+			}
+
+		case strings.Contains(arg, "..."):
+			left, right, _ := strings.Cut(arg, "...")
+			a, err := revListCommit(repo, left)
+			if err != nil {
+				return nil, 0, err
+			}
+			b, err := revListCommit(repo, right)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			// the symmetric difference: either side, but not what both have
+			bases, err := utils.MergeBases(repo, a, b)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := add(a, false); err != nil {
+				return nil, 0, err
+			}
+			if err := add(b, false); err != nil {
+				return nil, 0, err
+			}
+			for _, base := range bases {
+				if err := add(base, true); err != nil {
+					return nil, 0, err
+				}
+			}
+
+		case strings.Contains(arg, ".."):
+			left, right, _ := strings.Cut(arg, "..")
+			if err := add(left, true); err != nil {
+				return nil, 0, err
+			}
+			if err := add(right, false); err != nil {
+				return nil, 0, err
+			}
+
+		default:
+			if err := add(arg, false); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	return rest, revs, nil
+}
+
+// revListOptions applies the ordering and limiting options.
+func revListOptions(walk *utils.RevWalk, args []string) (count bool, err error) {
+	number := func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %v", s)
+		}
+		return n, nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch {
+		case arg == "--first-parent":
+			walk.FirstParent = true
+		case arg == "--count":
+			count = true
+		case arg == "--topo-order":
+			walk.Order = utils.RevOrderTopo
+		case arg == "--date-order":
+			walk.Order = utils.RevOrderDate
+		case arg == "--reverse":
+			walk.Reverse = true
+
+		case name == "--max-count" || name == "--skip" || arg == "-n":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return false, fmt.Errorf("%v needs a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			n, err := number(value)
+			if err != nil {
+				return false, err
+			}
+			if name == "--skip" {
+				walk.Skip = n
+			} else {
+				walk.MaxCount = n
+			}
+
+		case strings.HasPrefix(arg, "-n"):
+			n, err := number(arg[2:])
+			if err != nil {
+				return false, err
+			}
+			walk.MaxCount = n
+
+		case len(arg) > 1 && arg[1] >= '0' && arg[1] <= '9':
+			// -<n>, like -3
+			n, err := number(arg[1:])
+			if err != nil {
+				return false, err
+			}
+			walk.MaxCount = n
+
+		default:
+			return false, fmt.Errorf("unknown option: %v", arg)
+		}
+	}
+	return count, nil
+}
+
+var revListCmd = &cobra.Command{
+	Use:   "revList [OPTIONS] REVISION...",
+	Short: "list commits in reverse chronological order",
+	Long: `lists the commits reachable from the given revisions, newest first, leaving out those reachable from a
+	revision prefixed with ^. A..B stands for ^A B and A...B for the commits reachable from either side but not both.
+	--not flips the meaning of ^ for the revisions after it, --all adds every ref.
+
+	options:
+	-n, --max-count N    stop after N commits
+	--skip N             skip the first N commits
+	--first-parent       only follow the first parent of merges
+	--count              print the number of commits instead
+	--date-order         never show a parent before all its children, otherwise by date
+	--topo-order         never show a parent before all its children, keeping lines of history together
+	--reverse            print the selected commits oldest first`,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, arg := range args {
+			if arg == "-h" || arg == "--help" {
+				cmd.Help()
+				return
+			}
+		}
+
+		repo := utils.RepoFind(".", true)
+		walk := utils.NewRevWalk(repo)
+
+		options, revs, err := revListSetup(repo, walk, args)
+		if err == nil && revs == 0 {
+			err = fmt.Errorf("no revisions given, usage: revList [OPTIONS] REVISION...")
+		}
+		var count bool
+		if err == nil {
+			count, err = revListOptions(walk, options)
+		}
+
+		var commits []string
+		if err == nil {
+			commits, err = walk.Walk()
+		}
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(1)
+		}
+
+		if count {
+			fmt.Println(len(commits))
+			return
+		}
+		for _, sha := range commits {
+			fmt.Println(sha)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(revListCmd)
+}
@@ -2,30 +2,71 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/Duck-005/wannagit/utils"
 	"github.com/spf13/cobra"
 )
 
+// revParseSymbolic gives the full ref name a revision stands for, "" when
+// it isn't a ref.
+func revParseSymbolic(repo utils.Repo, name string) string {
+	if name == "@" || name == "HEAD" {
+		if branch := utils.HeadBranch(repo); branch != "" {
+			return branch
+		}
+		return "HEAD"
+	}
+	ref, _ := utils.DwimRef(repo, name)
+	return ref
+}
+
 var revParseCmd = &cobra.Command{
-	Use:   "revParse [--type] [TYPE] REFERENCE",
+	Use:   "revParse [--type TYPE] [--short[=N]] [--abbrev-ref] [--symbolic-full-name] REVISION...",
 	Short: "Parse revision (or other objects) identifiers",
-	Long: `Parse revision (or other objects) identifiers`,
+	Long: `prints the object name of each REVISION, which can be written in any of the forms of gitrevisions:
+	a sha or a unique prefix of it, a ref name like main or v1.0 (searched in refs/, refs/tags/, refs/heads/ and
+	refs/remotes/), @ for HEAD, REF@{N}, REF@{DATE}, @{-N} and BRANCH@{upstream}, REV~N and REV^N for ancestors,
+	REV^{TYPE} and REV^{} to peel, REV^{/REGEX} and :/REGEX to find a commit by its message,
+	REV:PATH for a path in a tree and :PATH or :N:PATH for a path in the index.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
-			fmt.Print("Usage: revParse --type TYPE REFERENCE")
-			return 
+			fmt.Print("Usage: revParse [--type TYPE] REVISION...\n")
+			return
 		}
 
 		format, _ := cmd.Flags().GetString("type")
-		
+		short, _ := cmd.Flags().GetInt("short")
+		abbrevRef, _ := cmd.Flags().GetBool("abbrev-ref")
+		symbolic, _ := cmd.Flags().GetBool("symbolic-full-name")
+
 		repo := utils.RepoFind(".", true)
-		sha := utils.ObjectFind(repo, args[0], format, true)
-		
-		if sha == "" {
-			fmt.Print("None")
-		} else {
-			fmt.Print(sha)
+
+		for _, arg := range args {
+			if abbrevRef || symbolic {
+				ref := revParseSymbolic(repo, arg)
+				if abbrevRef {
+					ref = strings.TrimPrefix(ref, "refs/heads/")
+					ref = strings.TrimPrefix(ref, "refs/tags/")
+					ref = strings.TrimPrefix(ref, "refs/remotes/")
+				}
+				fmt.Println(ref)
+				continue
+			}
+
+			sha := utils.ObjectFind(repo, arg, format, true)
+			if sha == "" {
+				if format != "" {
+					fmt.Printf("fatal: %v is not a %v\n", arg, format)
+				}
+				os.Exit(1)
+			}
+
+			if short > 0 && short < len(sha) {
+				sha = sha[:short]
+			}
+			fmt.Println(sha)
 		}
 	},
 }
@@ -33,5 +74,9 @@ var revParseCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(revParseCmd)
 
-	revParseCmd.Flags().StringP("type", "t", "blob", "give the type of object")
+	revParseCmd.Flags().StringP("type", "t", "", "peel the object to TYPE (commit, tree, blob or tag)")
+	revParseCmd.Flags().Int("short", 0, "abbreviate the object name to N digits")
+	revParseCmd.Flags().Lookup("short").NoOptDefVal = "7"
+	revParseCmd.Flags().Bool("abbrev-ref", false, "print the short name of the ref")
+	revParseCmd.Flags().Bool("symbolic-full-name", false, "print the full name of the ref")
 }
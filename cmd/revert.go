@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var revertCmd = &cobra.Command{
+	Use:   "revert [-n] [-m <parent>] <commit>... | --continue | --skip | --abort",
+	Short: "revert some existing commits",
+	Long: `undoes the change each commit made to its parent, as a three-way merge onto HEAD, and commits the result
+as "Revert ...". a range like A..B is reverted newest first.
+when a commit conflicts the run stops with markers in the worktree; resolve them, add the files and run
+--continue, or give up on the commit with --skip or on the whole run with --abort.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pickMain(cmd, args, "revert")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(revertCmd)
+
+	revertCmd.Flags().BoolP("no-commit", "n", false, "apply the reverse changes to the index and worktree without committing")
+	revertCmd.Flags().IntP("mainline", "m", 0, "for a merge, the parent number (from 1) to revert back to")
+	revertCmd.Flags().Bool("continue", false, "commit the resolved revert and go on with the rest")
+	revertCmd.Flags().Bool("skip", false, "drop the commit that stopped and go on with the rest")
+	revertCmd.Flags().Bool("abort", false, "give up, going back to HEAD as it was before the run")
+}
@@ -30,16 +30,23 @@ func rm(repo utils.Repo, paths []string, skipMissing bool, del bool) {
 	var keptEntries []utils.GitIndexEntry // entries to write back to the index
 	var remove []string // list of removed paths, which is used to physically remove paths from filesystem
 
+	// a path in conflict has an entry for each stage, all of them go
+	matched := make(map[string]struct{})
 	for _, e := range index.Entries {
 		fullPath := filepath.Join(repo.Worktree, e.Name)
 
 		if _, ok := abspaths[fullPath]; ok {
-			remove = append(remove, fullPath)
-			delete(abspaths, fullPath)
+			if _, seen := matched[fullPath]; !seen {
+				remove = append(remove, fullPath)
+			}
+			matched[fullPath] = struct{}{}
 		} else {
 			keptEntries = append(keptEntries, e)
 		}
 	}
+	for path := range matched {
+		delete(abspaths, path)
+	}
 
 	if len(abspaths) > 0 && !skipMissing {
 		fmt.Print("cannot remove paths not in the index: ", abspaths)
@@ -1,39 +1,36 @@
 package cmd
 
 import (
-	"os"
 	"fmt"
-	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/Duck-005/wannagit/utils"
 	"github.com/spf13/cobra"
 )
 
+// listRef nests the refs found under path (default "refs"), loose and packed,
+// by their path components.
 func listRef(repo utils.Repo, path string) map[string]any {
-	var err error
-	var basePath string
-
 	if path == "" {
-		basePath, err = utils.RepoDir(repo, false, "refs")
-		utils.ErrorHandler("", err)
 		path = "refs"
-	} else {
-		basePath, err = utils.RepoDir(repo, false, path)
-		utils.ErrorHandler("", err)
 	}
 
 	refMap := make(map[string]any)
 
-	entries, err := os.ReadDir(basePath)
-	utils.ErrorHandler("", err)
+	for name, sha := range utils.ListRefs(repo, path+"/") {
+		parts := strings.Split(strings.TrimPrefix(name, path+"/"), "/")
 
-	for _, entry := range entries {
-		relativePath := filepath.Join(path, entry.Name())
-		if entry.IsDir() {
-			refMap[entry.Name()] = listRef(repo, relativePath)
-		} else {
-			refMap[entry.Name()] = utils.ResolveRef(repo, relativePath)
+		node := refMap
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				node[part] = child
+			}
+			node = child
 		}
+		node[parts[len(parts)-1]] = sha
 	}
 
 	return refMap
@@ -44,8 +41,15 @@ func showRef(repo utils.Repo, refs map[string]any, withHash bool, prefix string)
 		prefix += "/"
 	}
 
-	for k, v := range refs {
-		switch val := v.(type) {
+	// map order is random, refs are listed by name
+	keys := make([]string, 0, len(refs))
+	for k := range refs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch val := refs[k].(type) {
 		case string:
 			if withHash {
 				fmt.Printf("%s %s%s\n", val, prefix, k)
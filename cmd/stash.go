@@ -0,0 +1,663 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// stashMatcher tells whether a repo path is one of the pathspecs given on
+// the command line, a file or a directory above it. everything matches
+// without any.
+func stashMatcher(repo utils.Repo, paths []string) func(name string) bool {
+	var prefixes []string
+	for _, path := range paths {
+		abspath, _ := filepath.Abs(path)
+		rel, err := filepath.Rel(repo.Worktree, abspath)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return func(string) bool { return true }
+		}
+		prefixes = append(prefixes, rel)
+	}
+
+	return func(name string) bool {
+		if len(paths) == 0 {
+			return true
+		}
+		for _, p := range prefixes {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// stashUntracked lists the files in the worktree that are neither tracked
+// nor ignored.
+func stashUntracked(repo utils.Repo, index utils.GitIndex, match func(string) bool) ([]string, error) {
+	ignore, err := gitignoreRead(repo)
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool, len(index.Entries))
+	for _, e := range index.Entries {
+		tracked[e.Name] = true
+	}
+
+	var untracked []string
+	err = filepath.WalkDir(repo.Worktree, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(repo.Worktree, path)
+		rel = filepath.ToSlash(rel)
+		if path == repo.Gitdir || rel == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || tracked[rel] || !match(rel) {
+			return nil
+		}
+		if ignored, err := checkIgnore(ignore, rel); ignored || err != nil {
+			return nil
+		}
+		untracked = append(untracked, rel)
+		return nil
+	})
+	return untracked, err
+}
+
+// stashWorktreeEntry is the entry for the worktree copy of a file, its blob
+// written, false when the file is gone.
+func stashWorktreeEntry(repo utils.Repo, name string, e utils.GitIndexEntry) (utils.GitIndexEntry, bool) {
+	abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+	stat, err := os.Lstat(abspath)
+	if err != nil {
+		return e, false
+	}
+
+	if stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(abspath)
+		if err != nil {
+			return e, false
+		}
+		blob := utils.GitBlob{}
+		blob.Deserialize(target)
+		e.SHA = utils.ObjectWrite(&blob, repo)
+		e.ModeType, e.ModePerms = 0b1010, 0
+		return e, true
+	}
+
+	if sha := checkoutWorktreeSha(repo, name); sha != e.SHA {
+		file, err := os.Open(abspath)
+		if err != nil {
+			return e, false
+		}
+		e.SHA = objectHash(repo, file, "blob")
+		file.Close()
+	}
+	e.ModeType, e.ModePerms = 0b1000, 0o644
+	if stat.Mode()&0o111 != 0 {
+		e.ModePerms = 0o755
+	}
+	return e, true
+}
+
+// stashSetIndex makes the index hold entries, keeping the stat data of the
+// ones that don't change, so that they aren't hashed again.
+func stashSetIndex(repo utils.Repo, index *utils.GitIndex, entries []utils.GitIndexEntry) error {
+	current := make(map[string]utils.GitIndexEntry)
+	for _, e := range index.Entries {
+		if e.Stage == 0 {
+			current[e.Name] = e
+		}
+	}
+
+	var kept []utils.GitIndexEntry
+	for _, e := range entries {
+		if c, ok := current[e.Name]; ok && c.SHA == e.SHA && c.ModeType == e.ModeType && c.ModePerms == e.ModePerms {
+			kept = append(kept, c)
+			continue
+		}
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+		if checkoutWorktreeSha(repo, e.Name) == e.SHA {
+			if entry, err := indexEntryFromFile(abspath, e.Name, e.SHA); err == nil {
+				entry.ModeType, entry.ModePerms = e.ModeType, e.ModePerms
+				e = entry
+			}
+		}
+		kept = append(kept, utils.GitIndexEntry{
+			Ctime: e.Ctime, Mtime: e.Mtime, Dev: e.Dev, Ino: e.Ino, UID: e.UID, GID: e.GID, Size: e.Size,
+			ModeType: e.ModeType, ModePerms: e.ModePerms, SHA: e.SHA, Name: e.Name,
+		})
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	index.Entries = kept
+	return utils.IndexWrite(repo, *index)
+}
+
+// stashReset puts the matching paths of the index and worktree back to
+// HEAD, the way stash leaves them once their changes are saved.
+func stashReset(repo utils.Repo, head []utils.GitIndexEntry, match func(string) bool) error {
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		return err
+	}
+
+	inHead := make(map[string]bool)
+	var entries []utils.GitIndexEntry
+	for _, e := range head {
+		inHead[e.Name] = true
+		entries = append(entries, e)
+		if !match(e.Name) {
+			continue
+		}
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+		// hashed with no gitdir, so that nothing is written
+		if wt, ok := stashWorktreeEntry(utils.Repo{Worktree: repo.Worktree}, e.Name, e); !ok || wt.SHA != e.SHA || wt.ModePerms != e.ModePerms || wt.ModeType != e.ModeType {
+			if err := mergeWorktreeFile(repo, abspath, e.SHA, e.ModeType, e.ModePerms); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range index.Entries {
+		if inHead[e.Name] || !match(e.Name) {
+			continue
+		}
+		// added since HEAD, it goes with the stash
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+		if os.Remove(abspath) == nil {
+			checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+		}
+	}
+
+	// the paths left out keep what they had staged
+	if entries, err = stashKeepUnmatched(index, entries, match); err != nil {
+		return err
+	}
+	return stashSetIndex(repo, index, entries)
+}
+
+// stashKeepUnmatched swaps the HEAD entries of the paths a pathspec left
+// out for what the index holds for them.
+func stashKeepUnmatched(index *utils.GitIndex, head []utils.GitIndexEntry, match func(string) bool) ([]utils.GitIndexEntry, error) {
+	var entries []utils.GitIndexEntry
+	for _, e := range head {
+		if match(e.Name) {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range index.Entries {
+		if !match(e.Name) {
+			if e.Stage != 0 {
+				return nil, fmt.Errorf("%s: needs merge", e.Name)
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// stashChanged tells whether entries differ from HEAD in any of the
+// matching paths.
+func stashChanged(head, entries []utils.GitIndexEntry, match func(string) bool) bool {
+	inHead := make(map[string]utils.GitIndexEntry)
+	for _, e := range head {
+		if match(e.Name) {
+			inHead[e.Name] = e
+		}
+	}
+	for _, e := range entries {
+		if !match(e.Name) {
+			continue
+		}
+		h, ok := inHead[e.Name]
+		if !ok || h.SHA != e.SHA || h.ModeType != e.ModeType || h.ModePerms != e.ModePerms {
+			return true
+		}
+		delete(inHead, e.Name)
+	}
+	return len(inHead) > 0
+}
+
+// stashPush saves the changes to the index and worktree, and with untracked
+// the untracked files, as a stash and resets them to HEAD. the stash is the
+// worktree commit, whose parents are HEAD, the index commit and the
+// untracked files commit, the way git records it.
+func stashPush(repo utils.Repo, message string, untracked bool, paths []string) {
+	head := utils.ResolveRef(repo, "HEAD")
+	if head == "" {
+		fmt.Println("You do not have the initial commit yet")
+		os.Exit(1)
+	}
+	headCommit, ok := utils.ObjectRead(repo, head).(*utils.GitCommit)
+	if !ok {
+		fmt.Println("fatal: HEAD is not a commit")
+		os.Exit(128)
+	}
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if unmerged := mergeUnmerged(*index); len(unmerged) > 0 {
+		for _, name := range unmerged {
+			fmt.Printf("%s: needs merge\n", name)
+		}
+		fmt.Println("error: could not save index tree")
+		os.Exit(1)
+	}
+
+	headTree, _ := utils.PeelTo(repo, head, "tree")
+	headEntries, err := utils.TreeIndexEntries(repo, headTree)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	match := stashMatcher(repo, paths)
+
+	// the index commit is the whole index, the worktree commit takes the
+	// worktree copy of the matching paths only
+	iTree := utils.TreeFromIndex(repo, *index)
+
+	var wEntries []utils.GitIndexEntry
+	for _, e := range index.Entries {
+		if !match(e.Name) {
+			wEntries = append(wEntries, e)
+			continue
+		}
+		if wt, ok := stashWorktreeEntry(repo, e.Name, e); ok {
+			wEntries = append(wEntries, wt)
+		}
+	}
+	wTree := utils.TreeFromIndex(repo, utils.GitIndex{Entries: wEntries})
+
+	var uFiles []string
+	uTree := ""
+	if untracked {
+		if uFiles, err = stashUntracked(repo, *index, match); err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		var uEntries []utils.GitIndexEntry
+		for _, name := range uFiles {
+			if e, ok := stashWorktreeEntry(repo, name, utils.GitIndexEntry{Name: name}); ok {
+				uEntries = append(uEntries, e)
+			}
+		}
+		if len(uEntries) > 0 {
+			uTree = utils.TreeFromIndex(repo, utils.GitIndex{Entries: uEntries})
+		}
+	}
+
+	if !stashChanged(headEntries, index.Entries, match) && !stashChanged(headEntries, wEntries, match) && uTree == "" {
+		fmt.Println("No local changes to save")
+		return
+	}
+
+	branch := "(no branch)"
+	if name := utils.HeadBranch(repo); name != "" {
+		branch = strings.TrimPrefix(name, "refs/heads/")
+	}
+	on := fmt.Sprintf("%s: %s %s", branch, head[:7], pickSubject(headCommit.Data.Message))
+	title := "WIP on " + on
+	if message != "" {
+		title = fmt.Sprintf("On %s: %s", branch, message)
+	}
+
+	author, err := utils.AuthorIdent(repo, utils.Ident{})
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	committer, err := utils.CommitterIdent(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	create := func(tree string, parents []string, message string) string {
+		sha, err := utils.CommitCreate(repo, tree, parents, author, committer, message, nil)
+		if err != nil {
+			fmt.Printf("fatal: could not create the stash: %v\n", err)
+			os.Exit(128)
+		}
+		return sha
+	}
+
+	parents := []string{head, create(iTree, []string{head}, "index on "+on+"\n")}
+	if uTree != "" {
+		parents = append(parents, create(uTree, nil, "untracked files on "+on+"\n"))
+	}
+	// git leaves the newline off this one message
+	stash := create(wTree, parents, title)
+
+	if err := utils.UpdateRef(repo, utils.StashRef, stash, title); err != nil {
+		fmt.Printf("fatal: cannot update %s: %v\n", utils.StashRef, err)
+		os.Exit(128)
+	}
+	fmt.Printf("Saved working directory and index state %s\n", title)
+
+	if err := stashReset(repo, headEntries, match); err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	for _, name := range uFiles {
+		abspath := filepath.Join(repo.Worktree, filepath.FromSlash(name))
+		if os.Remove(abspath) == nil {
+			checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+		}
+	}
+}
+
+var stashSelectorRE = regexp.MustCompile(`^(?:(?:refs/)?stash@\{(\d+)\}|(\d+))$`)
+
+// stashSelect resolves the stash named on the command line, stash@{0} by
+// default, to its number and commit.
+func stashSelect(repo utils.Repo, args []string) (int, string, string) {
+	name := "stash@{0}"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	m := stashSelectorRE.FindStringSubmatch(name)
+	if m == nil {
+		fmt.Printf("error: '%s' is not a stash-like commit\n", name)
+		os.Exit(1)
+	}
+	n, _ := strconv.Atoi(m[1] + m[2])
+
+	entries, err := utils.StashEntries(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No stash entries found.")
+		os.Exit(1)
+	}
+	if n >= len(entries) {
+		fmt.Printf("error: stash@{%d} is not a valid reference\n", n)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		name = "refs/stash@{0}"
+	} else if m[2] != "" {
+		name = fmt.Sprintf("stash@{%d}", n)
+	}
+	return n, entries[n].New, name
+}
+
+// stashApply brings the changes of stash back onto the index and worktree
+// as a three-way merge against the commit it was made on. the index gets
+// back the stashed staged changes with restoreIndex, otherwise only the
+// files the stash added stay staged. it tells whether that went cleanly.
+func stashApply(repo utils.Repo, stash string, restoreIndex bool) bool {
+	commit, ok := utils.ObjectRead(repo, stash).(*utils.GitCommit)
+	var parents []string
+	if ok {
+		parents = commit.Data.GetAll("parent")
+	}
+	if len(parents) < 2 {
+		fmt.Printf("error: '%s' is not a stash-like commit\n", stash)
+		os.Exit(1)
+	}
+	tree := func(sha string) string {
+		t, err := utils.PeelTo(repo, sha, "tree")
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		return t
+	}
+	bTree, iTree, wTree := tree(parents[0]), tree(parents[1]), tree(stash)
+
+	index, err := utils.IndexRead(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if len(mergeUnmerged(*index)) > 0 {
+		fmt.Println("error: Cannot apply a stash in the middle of a merge")
+		os.Exit(1)
+	}
+	cTree := utils.TreeFromIndex(repo, *index)
+
+	// the staged changes go on top of what is staged now
+	var indexMerge *utils.TreeMerge
+	if restoreIndex && iTree != bTree {
+		if indexMerge, err = utils.MergeTrees(repo, bTree, cTree, iTree, utils.MergeOptions{}); err != nil || !indexMerge.Clean() {
+			fmt.Println("Conflicts in index. Try without --index.")
+			os.Exit(1)
+		}
+	}
+
+	// untracked files are put back first, never over existing ones, and
+	// taken away again when the tracked ones can't be merged
+	var restored []string
+	if len(parents) > 2 {
+		files, err := utils.TreeIndexEntries(repo, tree(parents[2]))
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+		exists := false
+		for _, e := range files {
+			if _, err := os.Lstat(filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))); err == nil {
+				fmt.Printf("%s already exists, no checkout\n", e.Name)
+				exists = true
+			}
+		}
+		if exists {
+			fmt.Println("error: could not restore untracked files from stash")
+			os.Exit(1)
+		}
+		for _, e := range files {
+			abspath := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+			if err := mergeWorktreeFile(repo, abspath, e.SHA, e.ModeType, e.ModePerms); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			restored = append(restored, abspath)
+		}
+	}
+
+	opts := utils.MergeOptionsFor(repo, "Updated upstream", "Stashed changes")
+	opts.Base = "Version stash was based on"
+	m, err := utils.MergeTrees(repo, bTree, cTree, wTree, opts)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if err := mergeApply(repo, cTree, m, "merge"); err != nil {
+		for _, abspath := range restored {
+			os.Remove(abspath)
+			checkoutRemoveEmptyDirs(repo, filepath.Dir(abspath))
+		}
+		fmt.Printf("error: %v\nAborting\n", err)
+		return false
+	}
+	for _, line := range m.Messages {
+		fmt.Println(line)
+	}
+	if !m.Clean() {
+		return false
+	}
+
+	// the merge staged everything, take back what wasn't staged before
+	index, err = utils.IndexRead(repo)
+	if err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	var staged []utils.GitIndexEntry
+	if indexMerge != nil {
+		staged = indexMerge.Entries
+	} else {
+		current, _ := utils.TreeIndexEntries(repo, cTree)
+		inCurrent := make(map[string]bool)
+		for _, e := range current {
+			inCurrent[e.Name] = true
+		}
+		staged = current
+		for _, e := range index.Entries {
+			if !inCurrent[e.Name] {
+				staged = append(staged, e)
+			}
+		}
+	}
+	if err := stashSetIndex(repo, index, staged); err != nil {
+		fmt.Printf("fatal: %v\n", err)
+		os.Exit(128)
+	}
+	return true
+}
+
+// stashDrop drops stash n, named name on the command line.
+func stashDrop(repo utils.Repo, n int, name string) {
+	sha, err := utils.StashDrop(repo, n)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Dropped %s (%s)\n", name, sha)
+}
+
+var stashCmd = &cobra.Command{
+	Use:   "stash [push [-m MESSAGE] [-u] [PATHS...] | list | show [-p] [STASH] | apply [--index] [STASH] | pop [--index] [STASH] | drop [STASH] | clear | branch NAME [STASH]]",
+	Short: "stash the changes in a dirty working directory away",
+	Long: `push saves the staged and unstaged changes to tracked files, and with -u the untracked files, as a stash
+	and resets the index and worktree to HEAD, only for PATHS when given. it is what stash does without arguments.
+	stashes are commits in refs/stash and its reflog, the way git keeps them, stash@{0} being the newest.
+	list shows them, show gives the diffstat of a stash or with -p its patch, apply brings its changes back onto
+	the current worktree, pop applies and then drops it, drop and clear remove stashes, and branch creates NAME
+	at the commit the stash was made on and pops it there.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		action := "push"
+		if len(args) > 0 {
+			switch args[0] {
+			case "push", "list", "show", "apply", "pop", "drop", "clear", "branch":
+				action, args = args[0], args[1:]
+			}
+		}
+
+		switch action {
+		case "push":
+			message, _ := cmd.Flags().GetString("message")
+			untracked, _ := cmd.Flags().GetBool("include-untracked")
+			stashPush(repo, message, untracked, args)
+
+		case "list":
+			entries, err := utils.StashEntries(repo)
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			for n, e := range entries {
+				fmt.Printf("stash@{%d}: %s\n", n, e.Message)
+			}
+
+		case "show":
+			_, stash, _ := stashSelect(repo, args)
+			parent := utils.CommitParents(repo, stash)[0]
+			from, _ := utils.PeelTo(repo, parent, "tree")
+			to, _ := utils.PeelTo(repo, stash, "tree")
+			changes, err := utils.DiffTrees(repo, from, to)
+			if err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			if len(changes) == 0 {
+				return
+			}
+			if patch, _ := cmd.Flags().GetBool("patch"); patch {
+				fmt.Print(utils.FormatPatch(repo, changes))
+			} else {
+				fmt.Print(utils.FormatDiffStat(repo, changes))
+			}
+
+		case "apply", "pop":
+			n, stash, name := stashSelect(repo, args)
+			restoreIndex, _ := cmd.Flags().GetBool("index")
+			if !stashApply(repo, stash, restoreIndex) {
+				if action == "pop" {
+					fmt.Println("The stash entry is kept in case you need it again.")
+				}
+				os.Exit(1)
+			}
+			if action == "pop" {
+				stashDrop(repo, n, name)
+			}
+
+		case "drop":
+			n, _, name := stashSelect(repo, args)
+			stashDrop(repo, n, name)
+
+		case "clear":
+			if err := utils.StashClear(repo); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+
+		case "branch":
+			if len(args) < 1 {
+				fmt.Println("fatal: No branch name specified")
+				os.Exit(128)
+			}
+			branch := args[0]
+			n, stash, name := stashSelect(repo, args[1:])
+			if utils.ResolveRef(repo, "refs/heads/"+branch) != "" {
+				fmt.Printf("fatal: a branch named '%s' already exists\n", branch)
+				os.Exit(128)
+			}
+
+			base := utils.CommitParents(repo, stash)[0]
+			if err := checkoutSwitch(repo, base); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+			from := checkoutShortName(repo)
+			if err := utils.UpdateRef(repo, "refs/heads/"+branch, base, "branch: Created from "+base); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			message := fmt.Sprintf("checkout: moving from %s to %s", from, branch)
+			if err := utils.WriteSymbolicRef(repo, "HEAD", "refs/heads/"+branch, message); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
+			fmt.Printf("Switched to a new branch '%s'\n", branch)
+
+			if !stashApply(repo, stash, true) {
+				fmt.Println("The stash entry is kept in case you need it again.")
+				os.Exit(1)
+			}
+			stashDrop(repo, n, name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stashCmd)
+
+	stashCmd.Flags().StringP("message", "m", "", "push: describe the stash, instead of WIP on <branch>")
+	stashCmd.Flags().BoolP("include-untracked", "u", false, "push: stash the untracked files too, and remove them")
+	stashCmd.Flags().BoolP("patch", "p", false, "show: the patch of the stash instead of its diffstat")
+	stashCmd.Flags().Bool("index", false, "apply, pop: bring back the staged changes to the index as well")
+}
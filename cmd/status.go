@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -9,26 +11,22 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Duck-005/wannagit/utils"
 	"github.com/spf13/cobra"
 )
 
 func cmdStatusBranch(repo utils.Repo) {
-	// get the active branch
-	head, _ := os.ReadFile(filepath.Join(repo.Gitdir, "HEAD"))
+	head := utils.ResolveRef(repo, "HEAD")
 
-	var isActiveBranch bool
-	if strings.HasPrefix(string(head), "ref: refs/heads/") {
-		isActiveBranch = true
-	} else {
-		isActiveBranch = false
-	}
-
-	if isActiveBranch {
-		fmt.Printf("On branch %v\n", string(head[16:len(head)-1]))
+	if branch := utils.HeadBranch(repo); branch != "" {
+		fmt.Printf("On branch %v\n", strings.TrimPrefix(branch, "refs/heads/"))
+		if head == "" {
+			fmt.Print("\nNo commits yet\n")
+		}
 	} else {
-		fmt.Printf("HEAD detached at %v\n", utils.ObjectFind(repo, "HEAD", "commit", true))
+		fmt.Printf("HEAD detached at %v\n", head[:7])
 	}
 }
 
@@ -66,8 +64,13 @@ func treeToMap(repo utils.Repo, ref string, prefix string) (map[string]string, e
 func cmdStatusHeadIndex(repo utils.Repo, index utils.GitIndex) {
 	fmt.Println("changes to be committed:")
 
-	head, err := treeToMap(repo, "HEAD", "")
-	utils.ErrorHandler("", err)
+	// nothing is committed yet on an unborn branch
+	head := make(map[string]string)
+	if utils.ResolveRef(repo, "HEAD") != "" {
+		var err error
+		head, err = treeToMap(repo, "HEAD", "")
+		utils.ErrorHandler("", err)
+	}
 
 	for _, entry := range index.Entries {
 		if sha, ok := head[entry.Name]; ok{
@@ -85,73 +88,212 @@ func cmdStatusHeadIndex(repo utils.Repo, index utils.GitIndex) {
 	}
 }
 
+// untrackedCacheScan lists the files below dir which aren't ignored, reading
+// only the directories whose mtime changed since the cache was filled.
+func untrackedCacheScan(repo utils.Repo, cache *utils.UntrackedCache, ignore *utils.GitIgnore, dir string, useFsmonitor bool, seen map[string]bool) ([]string, bool) {
+	seen[dir] = true
+	changed := false
+	cached := cache.Dirs[dir]
+
+	if cached == nil || !(useFsmonitor && cached.Valid) {
+		stat, err := os.Stat(filepath.Join(repo.Worktree, dir))
+		if err != nil || !stat.IsDir() {
+			return nil, cached != nil
+		}
+		mtime := [2]uint32{uint32(stat.ModTime().Unix()), uint32(stat.ModTime().Nanosecond())}
+
+		if cached == nil || cached.Mtime != mtime {
+			entries, err := os.ReadDir(filepath.Join(repo.Worktree, dir))
+			if err != nil {
+				return nil, cached != nil
+			}
+
+			cached = &utils.UntrackedCacheDir{Mtime: mtime}
+			// a directory modified just now may still change within the same
+			// mtime tick, so don't trust it next time
+			if time.Since(stat.ModTime()) < time.Second {
+				cached.Mtime = [2]uint32{}
+			}
+
+			for _, entry := range entries {
+				if dir == "." && (entry.Name() == filepath.Base(repo.Gitdir) || entry.Name() == ".git") {
+					continue
+				}
+
+				if entry.IsDir() {
+					cached.Dirs = append(cached.Dirs, entry.Name())
+				} else if isIgnored, _ := checkIgnore(ignore, path.Join(dir, entry.Name())); isIgnored {
+					cached.Ignored = append(cached.Ignored, entry.Name())
+				} else {
+					cached.Files = append(cached.Files, entry.Name())
+				}
+			}
+
+			cache.Dirs[dir] = cached
+			changed = true
+		}
+		if useFsmonitor && !cached.Valid {
+			cached.Valid = true
+			changed = true
+		}
+	}
+
+	var files []string
+	for _, f := range cached.Files {
+		files = append(files, path.Join(dir, f))
+	}
+	for _, sub := range cached.Dirs {
+		subFiles, subChanged := untrackedCacheScan(repo, cache, ignore, path.Join(dir, sub), useFsmonitor, seen)
+		files = append(files, subFiles...)
+		changed = changed || subChanged
+	}
+
+	return files, changed
+}
+
+// ignoreRulesHash identifies the current ignore rules, the untracked cache is
+// thrown away whenever they change.
+func ignoreRulesHash(repo utils.Repo, index utils.GitIndex) string {
+	h := sha1.New()
+
+	for _, file := range []string{filepath.Join(repo.Gitdir, "info", "exclude"), gitignoreGlobalPath()} {
+		data, _ := os.ReadFile(file)
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+
+	for _, entry := range index.Entries {
+		if entry.Name == ".gitignore" || strings.HasSuffix(entry.Name, "/.gitignore") {
+			h.Write([]byte(entry.Name + "\x00" + entry.SHA + "\x00"))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func cmdStatusIndexWorktree(repo utils.Repo, index utils.GitIndex) ([]string, error){
 	fmt.Println("changes not staged for commit:")
 
 	ignore, err := gitignoreRead(repo)
 	utils.ErrorHandler("error in reading gitignore file", err)
 
-	gitignorePrefix := repo.Gitdir + string(os.PathSeparator)
+	// with the fsmonitor daemon running only the entries it reports need a look
+	useFsmonitor := utils.FsmonitorRefresh(repo, &index)
+	indexChanged := useFsmonitor
+
 	var allFiles []string
+	useCache := utils.UntrackedCacheEnabled(repo)
 
-	_ = filepath.WalkDir(repo.Worktree, func (path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	if useCache {
+		hash := ignoreRulesHash(repo, index)
+		if index.Untracked == nil || index.Untracked.IgnoreHash != hash {
+			index.Untracked = utils.NewUntrackedCache(hash)
 		}
-		rel, _ := filepath.Rel(repo.Worktree, path)
-		
-		if path == repo.Gitdir || strings.HasPrefix(rel, gitignorePrefix) || strings.HasPrefix(rel, ".git") {
-			if d.IsDir() {
-				return filepath.SkipDir
+
+		seen := make(map[string]bool)
+		files, changed := untrackedCacheScan(repo, index.Untracked, ignore, ".", useFsmonitor, seen)
+		for dir := range index.Untracked.Dirs {
+			if !seen[dir] {
+				delete(index.Untracked.Dirs, dir)
+				changed = true
 			}
-			return nil
 		}
 
-		if !d.IsDir() {
-			relPath, err := filepath.Rel(repo.Worktree, path)
+		allFiles = files
+		indexChanged = indexChanged || changed
+	} else {
+		if index.Untracked != nil {
+			index.Untracked = nil
+			indexChanged = true
+		}
+
+		gitignorePrefix := repo.Gitdir + string(os.PathSeparator)
+
+		_ = filepath.WalkDir(repo.Worktree, func (path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			allFiles = append(allFiles, strings.ReplaceAll(relPath, "\\", "/"))
+			rel, _ := filepath.Rel(repo.Worktree, path)
+			
+			if path == repo.Gitdir || strings.HasPrefix(rel, gitignorePrefix) || strings.HasPrefix(rel, ".git") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !d.IsDir() {
+				relPath, err := filepath.Rel(repo.Worktree, path)
+				if err != nil {
+					return err
+				}
+				allFiles = append(allFiles, strings.ReplaceAll(relPath, "\\", "/"))
+			}
+			return nil
+		})
+	}
+
+	tracked := make(map[string]bool, len(index.Entries))
+
+	for i, entry := range index.Entries {
+		tracked[entry.Name] = true
+		if entry.FsmonitorValid {
+			continue
 		}
-		return nil
-	})
 
-	for _, entry := range index.Entries {
 		fullPath := path.Join(repo.Worktree, entry.Name)
 		if stat, err := os.Stat(fullPath); errors.Is(err, os.ErrNotExist) {
 			fmt.Printf("  deleted:  %v\n", entry.Name)
+		} else if err != nil {
+			fmt.Printf("error: could not stat '%v': %v\n", entry.Name, err)
 		} else {
-			mtimeNs := entry.Mtime[0] * 10^9 + entry.Mtime[1]
-			if int64(stat.ModTime().Nanosecond()) != int64(mtimeNs) {
-				file, _ := os.Open(fullPath)
+			modified := false
+			if stat.ModTime().Unix() != int64(entry.Mtime[0]) ||
+				stat.ModTime().Nanosecond() != int(entry.Mtime[1]) ||
+				stat.Size() != int64(entry.Size) {
+				file, err := os.Open(fullPath)
+				if err != nil {
+					fmt.Printf("error: could not open '%v': %v\n", entry.Name, err)
+					continue
+				}
 				newSha := objectHash(utils.Repo{}, file, "blob")
-				defer file.Close()
+				file.Close()
 
-				if newSha != entry.SHA {
-					fmt.Printf("  modified:  %v\n", entry.Name)
-				}
+				modified = newSha != entry.SHA
 			}
-		}
 
-		for i, f := range allFiles {
-			if f == entry.Name {
-				allFiles = append(allFiles[:i], allFiles[i+1:]...)
-				break
+			if modified {
+				fmt.Printf("  modified:  %v\n", entry.Name)
+			} else if useFsmonitor {
+				index.Entries[i].FsmonitorValid = true
 			}
 		}
 	}
 
+	if indexChanged {
+		err := utils.IndexWrite(repo, index)
+		utils.ErrorHandler("couldn't update the index", err)
+	}
+
 	fmt.Println()
 	fmt.Println("untracked files:")
 
+	var untracked []string
 	for _, f := range allFiles {
-		if isIgnored, err := checkIgnore(ignore, f); !isIgnored && err == nil {
-			fmt.Println(" ", f)
+		if tracked[f] {
+			continue
+		}
+		// the cache only holds files which aren't ignored
+		if !useCache {
+			if isIgnored, err := checkIgnore(ignore, f); isIgnored || err != nil {
+				continue
+			}
 		}
+		untracked = append(untracked, f)
+		fmt.Println(" ", f)
 	}
 
-	return allFiles, nil
+	return untracked, nil
 }
 
 var statusCmd = &cobra.Command{
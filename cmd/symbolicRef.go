@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var symbolicRefCmd = &cobra.Command{
+	Use:   "symbolicRef [-q] [--short] NAME | [-m REASON] NAME REF | -d NAME",
+	Short: "read, modify and delete symbolic refs",
+	Long: `with one argument prints the ref the symbolic ref NAME points at, e.g. refs/heads/main for HEAD.
+	with two arguments NAME is made to point at REF, which has to be under refs/.
+	-d deletes the symbolic ref NAME.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		short, _ := cmd.Flags().GetBool("short")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		del, _ := cmd.Flags().GetBool("delete")
+		message, _ := cmd.Flags().GetString("message")
+
+		repo := utils.RepoFind(".", true)
+
+		switch {
+		case del && len(args) == 1:
+			if _, err := utils.ReadSymbolicRef(repo, args[0]); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+			if args[0] == "HEAD" {
+				fmt.Print("error: deleting HEAD is not allowed\n")
+				os.Exit(1)
+			}
+			if err := utils.DeleteRef(repo, args[0]); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+
+		case len(args) == 1:
+			target, err := utils.ReadSymbolicRef(repo, args[0])
+			if err != nil {
+				// -q only silences a ref that is detached
+				if !quiet || errors.Is(err, utils.ErrRefNotFound) {
+					fmt.Printf("fatal: %v\n", err)
+				}
+				os.Exit(1)
+			}
+
+			if short {
+				for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+					if s, ok := strings.CutPrefix(target, prefix); ok {
+						target = s
+						break
+					}
+				}
+			}
+			fmt.Println(target)
+
+		case len(args) == 2 && !del:
+			if !strings.HasPrefix(args[1], "refs/") || !utils.CheckRefFormat(args[1]) {
+				fmt.Printf("error: refusing to point %v outside of refs/: %v\n", args[0], args[1])
+				os.Exit(1)
+			}
+			if err := utils.WriteSymbolicRef(repo, args[0], args[1], message); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+
+		default:
+			fmt.Print("usage: symbolicRef [-q] [--short] NAME | [-m REASON] NAME REF | -d NAME\n")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(symbolicRefCmd)
+
+	symbolicRefCmd.Flags().Bool("short", false, "shorten the printed ref, e.g. main instead of refs/heads/main")
+	symbolicRefCmd.Flags().BoolP("quiet", "q", false, "don't complain when NAME is not a symbolic ref")
+	symbolicRefCmd.Flags().BoolP("delete", "d", false, "delete the symbolic ref NAME")
+	symbolicRefCmd.Flags().StringP("message", "m", "", "record REASON in the reflog of NAME")
+}
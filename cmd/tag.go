@@ -2,59 +2,327 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/Duck-005/wannagit/utils"
 	"github.com/spf13/cobra"
 )
 
-func tagCreate(repo utils.Repo, name string, ref string, createObject bool) {
-	sha := utils.ObjectFind(repo, ref, "", true)
+const tagEditTemplate = `
+#
+# Write a message for tag:
+#   %s
+# Lines starting with '#' will be ignored.
+`
 
-	if createObject {
-		tag := &utils.GitTag{}
-		tag.Data["object"][0] = sha 
-		tag.Data["type"][0] = "commit"
-		tag.Data["tag"][0] = name
-		
-		tag.Data["tagger"][0] = "wannagit <wannagit@example.com>"
-		tag.Data[""][0] = "A tag generated by wannagit, which won't let you customize the message.\n"
+// tagMessage gets the message of an annotated tag from -m, -F or the
+// editor. given tells whether it came from the command line, where an
+// empty message is allowed.
+func tagMessage(repo utils.Repo, name string, messages []string, file string) (message string, given bool, err error) {
+	switch {
+	case len(messages) > 0 && file != "":
+		return "", false, fmt.Errorf("only one -F or -m option is allowed.")
 
-		tagSha := utils.ObjectWrite(tag, repo)
-		createRef(repo, "tags/" + name, tagSha)
-	} else {
-		createRef(repo, "tags/" + name, sha)
+	case len(messages) > 0:
+		// every -m is a paragraph of its own
+		return strings.Join(messages, "\n\n"), true, nil
+
+	case file != "":
+		var data []byte
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("could not open or read '%v': %v", file, err)
+		}
+		return string(data), true, nil
+	}
+
+	editPath, err := utils.RepoFile(repo, false, "TAG_EDITMSG")
+	if err != nil {
+		return "", false, err
+	}
+	if err := os.WriteFile(editPath, []byte(fmt.Sprintf(tagEditTemplate, name)), 0644); err != nil {
+		return "", false, err
+	}
+	if err := utils.EditFile(repo, editPath); err != nil {
+		return "", false, fmt.Errorf("there was a problem with the editor '%v'", utils.Editor(repo))
+	}
+
+	data, err := os.ReadFile(editPath)
+	return string(data), false, err
+}
+
+// tagOptions are the flags tagCreate acts on.
+type tagOptions struct {
+	annotate bool
+	force    bool
+	messages []string // -m, each a paragraph
+	file     string   // -F
+	sign     bool
+	keyID    string // -u, user.signingkey when empty
+}
+
+// tagObjectCreate writes an annotated tag object pointing at sha, signed
+// with signer unless it is nil.
+func tagObjectCreate(repo utils.Repo, name string, sha string, message string, signer *utils.SSHSigner) (string, error) {
+	target := utils.ObjectRead(repo, sha)
+	if target == nil {
+		return "", fmt.Errorf("bad object %v", sha)
+	}
+
+	tagger, err := utils.CommitterIdent(repo)
+	if err != nil {
+		return "", err
+	}
+
+	tag := &utils.GitTag{}
+	tag.Data.Add("object", sha)
+	tag.Data.Add("type", target.Format())
+	tag.Data.Add("tag", name)
+	tag.Data.Add("tagger", tagger.String())
+	tag.Data.Message = message
+
+	if signer != nil {
+		if err := utils.SignTag(tag, signer); err != nil {
+			return "", fmt.Errorf("failed to sign the tag: %v", err)
+		}
+	}
+
+	return utils.ObjectWrite(tag, repo), nil
+}
+
+// tagCreate points refs/tags/<name> at object, through a new tag object
+// when annotating. an existing tag is only replaced with force.
+func tagCreate(repo utils.Repo, name string, object string, opts tagOptions) error {
+	ref := "refs/tags/" + name
+	if !utils.CheckRefFormat(ref) {
+		return fmt.Errorf("'%v' is not a valid tag name.", name)
+	}
+
+	sha, err := utils.RevParse(repo, object)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve '%v' as a valid ref.", object)
+	}
+
+	previous := utils.ResolveRef(repo, ref)
+	if previous != "" && !opts.force {
+		return fmt.Errorf("tag '%v' already exists", name)
+	}
+
+	if opts.annotate {
+		// load the key first, not to lose the message to a missing one
+		var signer *utils.SSHSigner
+		if opts.sign {
+			signer, err = utils.SigningKey(repo, opts.keyID)
+			if err != nil {
+				return err
+			}
+		}
+
+		message, given, err := tagMessage(repo, name, opts.messages, opts.file)
+		if err != nil {
+			return err
+		}
+
+		message = utils.CleanupMessage(message, true)
+		if message == "" && !given {
+			return fmt.Errorf("no tag message?")
+		}
+
+		sha, err = tagObjectCreate(repo, name, sha, message, signer)
+		if err != nil {
+			return err
+		}
+	}
+
+	// without force the tag must not have appeared in the meantime
+	old := utils.ZeroSha
+	if opts.force {
+		old = ""
 	}
+
+	t := utils.NewRefTransaction(repo)
+	t.Update(ref, sha, old, "")
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	if previous != "" && previous != sha {
+		fmt.Printf("Updated tag '%v' (was %v)\n", name, utils.AbbrevSha(repo, previous, 7))
+	}
+	return nil
 }
 
-func createRef(repo utils.Repo, refName string, sha string) {
-	path, err := utils.RepoFile(repo, true, "refs/" + refName)
-	utils.ErrorHandler("couldn't create ref object file", err)
+// tagDelete removes the given tags, going on after a missing one.
+func tagDelete(repo utils.Repo, names []string) bool {
+	ok := true
+
+	for _, name := range names {
+		ref := "refs/tags/" + name
+		sha := utils.ResolveRef(repo, ref)
+		if sha == "" {
+			fmt.Printf("error: tag '%v' not found.\n", name)
+			ok = false
+			continue
+		}
+
+		t := utils.NewRefTransaction(repo)
+		t.Delete(ref, sha, "")
+		if err := t.Commit(); err != nil {
+			fmt.Printf("error: could not delete tag '%v': %v\n", name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("Deleted tag '%v' (was %v)\n", name, utils.AbbrevSha(repo, sha, 7))
+	}
+	return ok
+}
 
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
-	utils.ErrorHandler("couldn't create tag object", err)
+// tagAnnotation is the first n lines of the tag message, or of the commit
+// message for a lightweight tag, continuation lines indented.
+func tagAnnotation(repo utils.Repo, sha string, n int) string {
+	var message string
+	switch obj := utils.ObjectRead(repo, sha).(type) {
+	case *utils.GitTag:
+		message, _ = utils.SplitTagMessage(obj.GetData().Message)
+	case *utils.GitCommit:
+		message = obj.GetData().Message
+	}
 
-	file.WriteString(sha + "\n")
+	lines := strings.Split(strings.TrimSuffix(message, "\n"), "\n")
+	if message == "" {
+		lines = nil
+	}
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return strings.Join(lines, "\n    ")
+}
+
+// tagList prints the tags matching any of the glob patterns, with n lines
+// of their annotation when n is positive.
+func tagList(repo utils.Repo, patterns []string, n int) {
+	refs := utils.ListRefs(repo, "refs/tags/")
+
+	for _, ref := range utils.SortedRefNames(refs) {
+		name := strings.TrimPrefix(ref, "refs/tags/")
+
+		matched := len(patterns) == 0
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if n > 0 {
+			fmt.Printf("%-15s %s\n", name, tagAnnotation(repo, refs[ref], n))
+		} else {
+			fmt.Println(name)
+		}
+	}
+}
+
+// tagParseFlags parses the flags itself, so that -n3 gives three lines:
+// pflag would take it for -n -3, -n having an optional value.
+func tagParseFlags(cmd *cobra.Command, args []string) ([]string, error) {
+	var rewritten []string
+	for i, arg := range args {
+		if arg == "--" {
+			rewritten = append(rewritten, args[i:]...)
+			break
+		}
+		if digits, ok := strings.CutPrefix(arg, "-n"); ok && digits != "" {
+			if _, err := strconv.Atoi(digits); err == nil {
+				arg = "--n=" + digits
+			}
+		}
+		rewritten = append(rewritten, arg)
+	}
+
+	if err := cmd.Flags().Parse(rewritten); err != nil {
+		return nil, err
+	}
+	return cmd.Flags().Args(), nil
 }
 
 var tagCmd = &cobra.Command{
-	Use:   "tag [NAME] [OBJECT] [-a]",
-	Short: "add a reference in refs/tags/",
-	Long: `create direct and indirect references to objects`,
+	Use:   "tag [-a | -s | -u KEYFILE] [-f] [-m MESSAGE | -F FILE] NAME [OBJECT] | -d NAME... | [-l] [-n[N]] [PATTERN...]",
+	Short: "create, list or delete tags in refs/tags/",
+	Long: `creates the tag NAME pointing at OBJECT (default HEAD). with -a, -m or -F it is an annotated tag: a tag object
+	recording the tagger and a message, which is taken from the editor unless -m or -F gives it. an existing tag is
+	only replaced with -f. -s signs the tag with the ssh key in user.signingkey, -u with the one given.
+
+	without NAME, or with -l, lists the tags matching any of the glob PATTERNs. -n prints the first N lines (default 1)
+	of each tag message, or of the commit message for lightweight tags. -d deletes the named tags.`,
+	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) < 2 {
-			fmt.Print("Usage: tag [NAME] [OBJECT] [-a]")
-			return 
+		args, err := tagParseFlags(cmd, args)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(129)
+		}
+		if help, _ := cmd.Flags().GetBool("help"); help {
+			cmd.Help()
+			return
 		}
 
-		repo := utils.RepoFind(args[1], true)
-		createObject, _ := cmd.Flags().GetBool("storeTrue")
+		var opts tagOptions
+		opts.annotate, _ = cmd.Flags().GetBool("annotate")
+		opts.messages, _ = cmd.Flags().GetStringArray("message")
+		opts.file, _ = cmd.Flags().GetString("file")
+		opts.force, _ = cmd.Flags().GetBool("force")
+		opts.sign, _ = cmd.Flags().GetBool("sign")
+		opts.keyID, _ = cmd.Flags().GetString("local-user")
+		noSign, _ := cmd.Flags().GetBool("no-sign")
+		remove, _ := cmd.Flags().GetBool("delete")
+		list, _ := cmd.Flags().GetBool("list")
+		lines, _ := cmd.Flags().GetInt("n")
 
-		if args[0] != "" {
-			tagCreate(repo, args[0], args[1], createObject)
-		} else {
-			refs := listRef(repo, "")
-			showRef(repo, refs["tags"].(map[string]any), false, "")
+		repo := utils.RepoFind(".", true)
+
+		switch {
+		case remove:
+			if len(args) == 0 {
+				fmt.Print("Usage: tag -d NAME...\n")
+				os.Exit(1)
+			}
+			if !tagDelete(repo, args) {
+				os.Exit(1)
+			}
+
+		case list || cmd.Flags().Changed("n") || len(args) == 0:
+			tagList(repo, args, lines)
+
+		default:
+			if len(args) > 2 {
+				fmt.Print("fatal: too many arguments\n")
+				os.Exit(128)
+			}
+
+			object := "HEAD"
+			if len(args) == 2 {
+				object = args[1]
+			}
+
+			explicit := opts.annotate || len(opts.messages) > 0 || opts.file != ""
+			opts.sign = (opts.sign || opts.keyID != "" || (explicit && utils.ConfigGetBool(repo, "tag", "gpgSign"))) && !noSign
+			opts.annotate = explicit || opts.sign
+
+			if err := tagCreate(repo, args[0], object, opts); err != nil {
+				fmt.Printf("fatal: %v\n", err)
+				os.Exit(128)
+			}
 		}
 	},
 }
@@ -62,5 +330,15 @@ var tagCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(tagCmd)
 
-	tagCmd.Flags().BoolP("storeTrue", "a", false, "create a new tag object pointing at HEAD or OBJECT")
+	tagCmd.Flags().BoolP("annotate", "a", false, "create an annotated tag object")
+	tagCmd.Flags().StringArrayP("message", "m", nil, "use MESSAGE as the tag message, each -m is a paragraph")
+	tagCmd.Flags().StringP("file", "F", "", "take the tag message from FILE, - for the standard input")
+	tagCmd.Flags().BoolP("force", "f", false, "replace an existing tag")
+	tagCmd.Flags().BoolP("sign", "s", false, "make an annotated tag signed with the ssh key in user.signingkey")
+	tagCmd.Flags().StringP("local-user", "u", "", "make a signed tag with the ssh key in KEYFILE")
+	tagCmd.Flags().Bool("no-sign", false, "don't sign the tag, even with tag.gpgSign set")
+	tagCmd.Flags().BoolP("delete", "d", false, "delete the named tags")
+	tagCmd.Flags().BoolP("list", "l", false, "list the tags, only those matching PATTERN if given")
+	tagCmd.Flags().IntP("n", "n", 0, "print the first N lines of each tag message when listing")
+	tagCmd.Flags().Lookup("n").NoOptDefVal = "1"
 }
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+// updateRefValue turns a <new> or <old> argument into a sha. an empty value
+// stands for a ref that doesn't exist.
+func updateRefValue(repo utils.Repo, value string) (string, error) {
+	if value == "" || value == utils.ZeroSha {
+		return utils.ZeroSha, nil
+	}
+	if utils.IsFullSha(value) {
+		return strings.ToLower(value), nil
+	}
+
+	sha := utils.ObjectFind(repo, value, "", true)
+	if sha == "" {
+		return "", fmt.Errorf("not a valid object name: %v", value)
+	}
+	return sha, nil
+}
+
+// updateRefNew is updateRefValue for a <new> argument, which must name an
+// object that exists unless it is the null sha asking for a deletion.
+func updateRefNew(repo utils.Repo, ref string, value string) (string, error) {
+	sha, err := updateRefValue(repo, value)
+	if err != nil || sha == utils.ZeroSha {
+		return sha, err
+	}
+	if !utils.ObjectExists(repo, sha) {
+		return "", fmt.Errorf("trying to write ref '%v' with nonexistent object %v", ref, sha)
+	}
+	return sha, nil
+}
+
+// updateRefStdin queues the commands read from r, one per line:
+//
+//	update <ref> <new> [<old>]
+//	create <ref> <new>
+//	delete <ref> [<old>]
+//	verify <ref> [<old>]
+func updateRefStdin(repo utils.Repo, t *utils.RefTransaction, r io.Reader, message string, noDeref bool) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, " ")
+		command, args := fields[0], fields[1:]
+
+		// value gives the sha of args[i], and whether it was there at all
+		value := func(i int) (string, bool, error) {
+			if i >= len(args) {
+				return "", false, nil
+			}
+			sha, err := updateRefValue(repo, args[i])
+			return sha, true, err
+		}
+		newValue := func(i int) (string, error) {
+			return updateRefNew(repo, args[0], args[i])
+		}
+
+		var u *utils.RefUpdate
+		switch {
+		case command == "update" && (len(args) == 2 || len(args) == 3):
+			newSha, err := newValue(1)
+			if err != nil {
+				return err
+			}
+			old, _, err := value(2)
+			if err != nil {
+				return err
+			}
+			// like git, a null <new> deletes the ref
+			if newSha == utils.ZeroSha {
+				u = t.Delete(args[0], old, message)
+			} else {
+				u = t.Update(args[0], newSha, old, message)
+			}
+
+		case command == "create" && len(args) == 2:
+			newSha, err := newValue(1)
+			if err != nil {
+				return err
+			}
+			if newSha == utils.ZeroSha {
+				return fmt.Errorf("create %v: zero <new-oid>", args[0])
+			}
+			u = t.Create(args[0], newSha, message)
+
+		case command == "delete" && (len(args) == 1 || len(args) == 2):
+			old, _, err := value(1)
+			if err != nil {
+				return err
+			}
+			u = t.Delete(args[0], old, message)
+
+		case command == "verify" && (len(args) == 1 || len(args) == 2):
+			// verify without <old> checks that the ref doesn't exist
+			old, given, err := value(1)
+			if err != nil {
+				return err
+			}
+			if !given {
+				old = utils.ZeroSha
+			}
+			u = t.Verify(args[0], old)
+
+		default:
+			return fmt.Errorf("unknown command: %v", line)
+		}
+
+		u.NoDeref = noDeref
+	}
+
+	return scanner.Err()
+}
+
+var updateRefCmd = &cobra.Command{
+	Use:   "updateRef [-m REASON] [--no-deref] REF NEW [OLD] | -d REF [OLD] | --stdin",
+	Short: "update the object name stored in a ref safely",
+	Long: `points REF at NEW, but only if REF currently is at OLD when that is given (all zeros meaning REF must not exist).
+	a NEW of all zeros, or empty, deletes REF, and any other NEW must name an existing object.
+	-d deletes REF, again checking OLD. --stdin reads "update", "create", "delete" and "verify" commands one per line
+	and applies all of them or none: every ref is locked and checked before any of them is changed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		del, _ := cmd.Flags().GetBool("delete")
+		stdin, _ := cmd.Flags().GetBool("stdin")
+		noDeref, _ := cmd.Flags().GetBool("no-deref")
+		message, _ := cmd.Flags().GetString("message")
+
+		repo := utils.RepoFind(".", true)
+		t := utils.NewRefTransaction(repo)
+
+		var err error
+		switch {
+		case stdin && len(args) == 0:
+			err = updateRefStdin(repo, t, os.Stdin, message, noDeref)
+
+		case del && (len(args) == 1 || len(args) == 2):
+			var old string
+			if len(args) == 2 {
+				old, err = updateRefValue(repo, args[1])
+			}
+			t.Delete(args[0], old, message).NoDeref = noDeref
+
+		case !del && (len(args) == 2 || len(args) == 3):
+			var newSha, old string
+			newSha, err = updateRefNew(repo, args[0], args[1])
+			if err == nil && len(args) == 3 {
+				old, err = updateRefValue(repo, args[2])
+			}
+			// like git, a null NEW deletes the ref
+			if newSha == utils.ZeroSha {
+				t.Delete(args[0], old, message).NoDeref = noDeref
+			} else {
+				t.Update(args[0], newSha, old, message).NoDeref = noDeref
+			}
+
+		default:
+			fmt.Print("usage: updateRef [-m REASON] [--no-deref] REF NEW [OLD] | -d REF [OLD] | --stdin\n")
+			return
+		}
+
+		if err == nil {
+			err = t.Commit()
+		}
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateRefCmd)
+
+	updateRefCmd.Flags().BoolP("delete", "d", false, "delete REF after checking it is at OLD")
+	updateRefCmd.Flags().Bool("stdin", false, "read a batch of updates from standard input and apply them together")
+	updateRefCmd.Flags().Bool("no-deref", false, "update a symbolic ref itself instead of the ref it points to")
+	updateRefCmd.Flags().StringP("message", "m", "", "reason recorded in the reflog")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifyCommitCmd = &cobra.Command{
+	Use:   "verifyCommit [-v] COMMIT...",
+	Short: "check the ssh signature of commits",
+	Long: `checks the signature in the gpgsig header of each COMMIT, and that the key which made it belongs to a
+	signer listed in the file gpg.ssh.allowedSignersFile names. -v prints the signed commit contents first.
+	exits with 1 when any of the commits isn't signed by an allowed signer.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Print("Usage: verifyCommit [-v] COMMIT...\n")
+			os.Exit(1)
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		repo := utils.RepoFind(".", true)
+
+		ok := true
+		for _, arg := range args {
+			sha := utils.ObjectFind(repo, arg, "commit", true)
+			commit, isCommit := utils.ObjectRead(repo, sha).(*utils.GitCommit)
+			if sha == "" || !isCommit {
+				fmt.Printf("error: %v: cannot verify a non-commit object\n", arg)
+				ok = false
+				continue
+			}
+
+			payload, _, _ := utils.CommitSignature(commit)
+			sig, signed := utils.VerifyCommit(repo, commit)
+			if !signed {
+				fmt.Print("error: no signature found\n")
+				ok = false
+				continue
+			}
+
+			if verbose {
+				fmt.Print(string(payload))
+			}
+			fmt.Println(sig)
+			ok = ok && sig.Good()
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCommitCmd)
+
+	verifyCommitCmd.Flags().BoolP("verbose", "v", false, "print the contents of the commit before checking it")
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifyTagCmd = &cobra.Command{
+	Use:   "verifyTag [-v] TAG...",
+	Short: "check the ssh signature of tags",
+	Long: `checks the signature at the end of the message of each annotated TAG, and that the key which made it belongs
+	to a signer listed in the file gpg.ssh.allowedSignersFile names. -v prints the signed tag contents first.
+	exits with 1 when any of the tags isn't signed by an allowed signer.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Print("Usage: verifyTag [-v] TAG...\n")
+			os.Exit(1)
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		repo := utils.RepoFind(".", true)
+
+		ok := true
+		for _, arg := range args {
+			sha, err := utils.RevParse(repo, arg)
+			if err != nil {
+				fmt.Printf("error: tag '%v' not found.\n", arg)
+				ok = false
+				continue
+			}
+
+			obj := utils.ObjectRead(repo, sha)
+			tag, isTag := obj.(*utils.GitTag)
+			if !isTag {
+				fmt.Printf("error: %v: cannot verify a non-tag object of type %v.\n", arg, obj.Format())
+				ok = false
+				continue
+			}
+
+			payload, _, _ := utils.TagSignature(tag)
+			sig, signed := utils.VerifyTag(repo, tag)
+			if !signed {
+				fmt.Print("error: no signature found\n")
+				ok = false
+				continue
+			}
+
+			if verbose {
+				fmt.Print(string(payload))
+			}
+			fmt.Println(sig)
+			ok = ok && sig.Good()
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyTagCmd)
+
+	verifyTagCmd.Flags().BoolP("verbose", "v", false, "print the contents of the tag before checking it")
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Duck-005/wannagit/utils"
+	"github.com/spf13/cobra"
+)
+
+var writeTreeCmd = &cobra.Command{
+	Use:   "writeTree [--missing-ok] [--prefix PREFIX]",
+	Short: "create a tree object from the index",
+	Long: `writes the index as tree objects and prints the sha of the root tree, or with --prefix of the tree for
+	that directory. the index must have no unmerged entries.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := utils.RepoFind(".", true)
+
+		index, err := utils.IndexRead(repo)
+		if err != nil {
+			fmt.Printf("fatal: %v\n", err)
+			os.Exit(128)
+		}
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+		missingOK, _ := cmd.Flags().GetBool("missing-ok")
+		sha, err := utils.WriteTree(repo, *index, prefix, missingOK)
+		if err != nil {
+			fmt.Printf("fatal: writeTree: %v\n", err)
+			os.Exit(128)
+		}
+		fmt.Println(sha)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(writeTreeCmd)
+
+	writeTreeCmd.Flags().String("prefix", "", "print the tree of this directory instead of the root one")
+	writeTreeCmd.Flags().Bool("missing-ok", false, "don't check that the objects the index names exist")
+}
@@ -2,10 +2,13 @@ module github.com/Duck-005/wannagit
 
 go 1.24.3
 
+require (
+	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
+	gopkg.in/ini.v1 v1.67.0
+)
+
 require (
 	github.com/bigkevmcd/go-configparser v0.0.0-20250311182818-a679eef33309 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
 )
@@ -1,16 +1,16 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
-	"bytes"
 )
 
 // GitCommit -----------------------------------
 
 type GitCommit struct {
 	BaseGitObject
-	Data map[string][]string
+	Data KVLM
 }
 
 func (b *GitCommit) Serialize() string {
@@ -18,85 +18,160 @@ func (b *GitCommit) Serialize() string {
 	return string(SerializeKVLM(b.Data))
 }
 
-func (b *GitCommit) Deserialize(data string) {
-	b.Data = ParseKVLM([]byte(data))
+func (b *GitCommit) Deserialize(data string) error {
+	var err error
+	b.Data, err = ParseKVLM([]byte(data))
 	b.format = "commit"
-} 
+	return err
+}
 
-func (b *GitCommit) GetData() map[string][]string {
-	return b.Data
+func (b *GitCommit) GetData() *KVLM {
+	return &b.Data
 }
 
 // helper functions -------------------------------
 
-type KVLM map[string][]string
-
-func ParseKVLM(raw []byte) KVLM {
-	return parseKVLM(raw, 0, make(KVLM))
+// KVLMField is one header line of a commit or tag, continuation lines
+// included in Value without their leading space.
+type KVLMField struct {
+	Key   string
+	Value string
 }
 
-func parseKVLM(raw []byte, start int, dict KVLM) KVLM {
-	spaceIdx := bytes.IndexByte(raw[start:], ' ')
-	newLineIdx := bytes.IndexByte(raw[start:], '\n')
+// KVLM (key-value list with message) keeps the headers in the order they
+// were read or added, so objects serialize back to the same bytes.
+type KVLM struct {
+	Fields      []KVLMField
+	Message     string
+	HeadersOnly bool // the headers end the object, without a blank line after them
+}
 
-	if spaceIdx == -1 || newLineIdx < spaceIdx {
-		// Base case: no more key-value pairs, only commit message
-		if newLineIdx != 0 {
-			panic("expected newline at start of commit message")
+// Get returns the value of the first key header, "" when there is none.
+func (k *KVLM) Get(key string) string {
+	for _, f := range k.Fields {
+		if f.Key == key {
+			return f.Value
 		}
-		dict[""] = []string{string(raw[start+1:])}
-		return dict
 	}
+	return ""
+}
 
-	spaceIdx += start
-	newLineIdx += start
-	key := string(raw[start:spaceIdx])
-
-	// Find end of value (handling continuation lines)
-	end := spaceIdx
-	for {
-		nextNewLine := bytes.IndexByte(raw[end+1:], '\n')
-		if nextNewLine == -1 {
-			panic("unterminated header value")
+// GetAll returns the values of every key header, like all the parents.
+func (k *KVLM) GetAll(key string) []string {
+	var values []string
+	for _, f := range k.Fields {
+		if f.Key == key {
+			values = append(values, f.Value)
 		}
-		nextNewLine += end + 1
-		if nextNewLine+1 >= len(raw) || raw[nextNewLine+1] != ' ' {
-			end = nextNewLine
-			break
+	}
+	return values
+}
+
+// Has tells whether there is a key header.
+func (k *KVLM) Has(key string) bool {
+	for _, f := range k.Fields {
+		if f.Key == key {
+			return true
 		}
-		end = nextNewLine
 	}
+	return false
+}
 
-	valBytes := raw[spaceIdx+1 : end]
-	valBytes = bytes.ReplaceAll(valBytes, []byte("\n "), []byte("\n"))
-	value := string(valBytes)
+// Add appends a header, after the others.
+func (k *KVLM) Add(key string, value string) {
+	k.Fields = append(k.Fields, KVLMField{Key: key, Value: value})
+}
 
-	if existing, ok := dict[key]; ok {
-		dict[key] = append(existing, value)
-	} else {
-		dict[key] = []string{value}
+// Set replaces the first key header and drops the others, or appends one.
+func (k *KVLM) Set(key string, value string) {
+	for i, f := range k.Fields {
+		if f.Key == key {
+			k.Fields[i].Value = value
+			rest := k.Fields[:i+1]
+			for _, other := range k.Fields[i+1:] {
+				if other.Key != key {
+					rest = append(rest, other)
+				}
+			}
+			k.Fields = rest
+			return
+		}
 	}
+	k.Add(key, value)
+}
 
-	return parseKVLM(raw, end+1, dict)
+// Del removes every key header.
+func (k *KVLM) Del(key string) {
+	var rest []KVLMField
+	for _, f := range k.Fields {
+		if f.Key != key {
+			rest = append(rest, f)
+		}
+	}
+	k.Fields = rest
 }
 
-func SerializeKVLM(dict KVLM) []byte {
-	var buf bytes.Buffer
+// ParseKVLM reads the headers and message of a commit or tag. the headers
+// either end the object or are followed by a blank line and the message.
+func ParseKVLM(raw []byte) (KVLM, error) {
+	var dict KVLM
+	start := 0
+
+	for {
+		if start == len(raw) && len(dict.Fields) > 0 {
+			dict.HeadersOnly = true
+			return dict, nil
+		}
 
-	for key, values := range dict {
-		if key == "" {
-			continue
+		spaceIdx := bytes.IndexByte(raw[start:], ' ')
+		newLineIdx := bytes.IndexByte(raw[start:], '\n')
+
+		if spaceIdx == -1 || newLineIdx < spaceIdx {
+			// no more key-value pairs, only the message after a blank line
+			if newLineIdx != 0 {
+				return KVLM{}, fmt.Errorf("expected newline at start of commit message")
+			}
+			dict.Message = string(raw[start+1:])
+			return dict, nil
 		}
 
-		for _, v := range values {
-			escaped := strings.ReplaceAll(v, "\n", "\n")
-			buf.WriteString(fmt.Sprintf("%s %s\n", key, escaped))
+		spaceIdx += start
+		key := string(raw[start:spaceIdx])
+
+		// the value goes on over the lines starting with a space
+		end := spaceIdx
+		for {
+			nextNewLine := bytes.IndexByte(raw[end+1:], '\n')
+			if nextNewLine == -1 {
+				return KVLM{}, fmt.Errorf("unterminated header value")
+			}
+			end = nextNewLine + end + 1
+			if end+1 >= len(raw) || raw[end+1] != ' ' {
+				break
+			}
 		}
+
+		value := bytes.ReplaceAll(raw[spaceIdx+1:end], []byte("\n "), []byte("\n"))
+		dict.Add(key, string(value))
+
+		start = end + 1
+	}
+}
+
+func SerializeKVLM(dict KVLM) []byte {
+	var buf bytes.Buffer
+
+	for _, f := range dict.Fields {
+		// continuation lines start with a space
+		buf.WriteString(f.Key)
+		buf.WriteByte(' ')
+		buf.WriteString(strings.ReplaceAll(f.Value, "\n", "\n "))
+		buf.WriteByte('\n')
 	}
 
-	buf.WriteByte('\n')
-	if msg, ok := dict[""]; ok {
-		buf.WriteString(strings.Join(msg, "\n"))
+	if !dict.HeadersOnly || dict.Message != "" {
+		buf.WriteByte('\n')
+		buf.WriteString(dict.Message)
 	}
 
 	return buf.Bytes()
@@ -0,0 +1,69 @@
+package utils
+
+import "testing"
+
+func TestKVLMRoundTrip(t *testing.T) {
+	raws := []string{
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"parent 329fe267500baaa3c3b71f9aa5a3101236071589\n" +
+			"parent b774411cd1ddb7579ff602160ddda02276a77a48\n" +
+			"author A U Thor <a@example.com> 1700000000 +0000\n" +
+			"committer C O Mitter <c@example.com> 1700000000 +0000\n" +
+			"encoding ISO-8859-1\n" +
+			"gpgsig -----BEGIN SSH SIGNATURE-----\n" +
+			" U1NIU0lHAAAAAQ==\n" +
+			" \n" +
+			" -----END SSH SIGNATURE-----\n" +
+			"\n" +
+			"subject\n\nbody\n",
+		"object 329fe267500baaa3c3b71f9aa5a3101236071589\n" +
+			"type commit\n" +
+			"tag v1\n" +
+			"tagger T <t@example.com> 1700000000 +0000\n" +
+			"\n",
+		"object 329fe267500baaa3c3b71f9aa5a3101236071589\n" +
+			"type commit\n" +
+			"tag v1\n" +
+			"tagger T <t@example.com> 1700000000 +0000\n",
+	}
+
+	for _, raw := range raws {
+		kvlm, err := ParseKVLM([]byte(raw))
+		if err != nil {
+			t.Fatalf("ParseKVLM(%q): %v", raw, err)
+		}
+		if got := string(SerializeKVLM(kvlm)); got != raw {
+			t.Errorf("round trip changed the object:\n got %q\nwant %q", got, raw)
+		}
+	}
+}
+
+func TestKVLMFields(t *testing.T) {
+	raw := "tree t\nparent p1\nparent p2\nmergetag object x\n type commit\n\nmessage\n"
+	kvlm, err := ParseKVLM([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := kvlm.GetAll("parent"); len(got) != 2 || got[0] != "p1" || got[1] != "p2" {
+		t.Errorf("parents = %q, want [p1 p2]", got)
+	}
+	if got := kvlm.Get("mergetag"); got != "object x\ntype commit" {
+		t.Errorf("continued value = %q, want the lines without their leading space", got)
+	}
+	if kvlm.Message != "message\n" {
+		t.Errorf("message = %q", kvlm.Message)
+	}
+}
+
+func TestParseKVLMMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"tree t\nparent p",
+		"tree t\ngpgsig a\n b",
+	} {
+		if _, err := ParseKVLM([]byte(raw)); err == nil {
+			t.Errorf("ParseKVLM(%q) succeeded, want an error", raw)
+		}
+	}
+}
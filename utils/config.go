@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"gopkg.in/ini.v1"
+)
+
+// configFiles lists the config files in increasing order of priority,
+// the system file first and the repository's own config last.
+func configFiles(repo Repo) []string {
+	var files []string
+
+	if runtime.GOOS == "windows" {
+		files = append(files, `C:\ProgramData\Git\config`)
+	} else {
+		files = append(files, "/etc/gitconfig")
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+
+	if repo.Gitdir != "" {
+		files = append(files, repoPath(repo, "config"))
+	}
+
+	return files
+}
+
+// ConfigGet returns the value of section.key, the repository config taking
+// priority over the global and system ones. Missing keys give "".
+func ConfigGet(repo Repo, section string, key string) string {
+	files := configFiles(repo)
+
+	for i := len(files) - 1; i >= 0; i-- {
+		cfg, err := ini.Load(files[i])
+		if err != nil {
+			continue
+		}
+
+		if sec, err := cfg.GetSection(section); err == nil && sec.HasKey(key) {
+			return sec.Key(key).String()
+		}
+	}
+	return ""
+}
+
+// ConfigGetAll returns every value of a multi-valued section.key, from the
+// system config to the repository's own.
+func ConfigGetAll(repo Repo, section string, key string) []string {
+	var values []string
+	for _, file := range configFiles(repo) {
+		cfg, err := ini.ShadowLoad(file)
+		if err != nil {
+			continue
+		}
+
+		if sec, err := cfg.GetSection(section); err == nil && sec.HasKey(key) {
+			values = append(values, sec.Key(key).ValueWithShadows()...)
+		}
+	}
+	return values
+}
+
+// ConfigGetBool reads section.key as a git style boolean.
+func ConfigGetBool(repo Repo, section string, key string) bool {
+	switch ConfigGet(repo, section, key) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// ConfigSet writes section.key into the repository config.
+func ConfigSet(repo Repo, section string, key string, value string) error {
+	path := repoPath(repo, "config")
+
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return err
+	}
+
+	cfg.Section(section).Key(key).SetValue(value)
+	return cfg.SaveTo(path)
+}
+
+// ConfigUnset removes section.key from the repository config, dropping the
+// section once it is empty.
+func ConfigUnset(repo Repo, section string, key string) error {
+	path := repoPath(repo, "config")
+
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return err
+	}
+
+	if sec, err := cfg.GetSection(section); err == nil {
+		sec.DeleteKey(key)
+		if len(sec.Keys()) == 0 {
+			cfg.DeleteSection(section)
+		}
+	}
+	return cfg.SaveTo(path)
+}
+
+// ConfigRenameSection moves every key of a section under a new name,
+// used for the per branch sections.
+func ConfigRenameSection(repo Repo, oldName string, newName string) error {
+	path := repoPath(repo, "config")
+
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return err
+	}
+
+	old, err := cfg.GetSection(oldName)
+	if err != nil {
+		return nil
+	}
+
+	cfg.DeleteSection(newName)
+	renamed := cfg.Section(newName)
+	for _, key := range old.Keys() {
+		renamed.Key(key.Name()).SetValue(key.Value())
+	}
+	cfg.DeleteSection(oldName)
+
+	return cfg.SaveTo(path)
+}
+
+// ConfigRemoveSection drops a whole section from the repository config.
+func ConfigRemoveSection(repo Repo, section string) error {
+	path := repoPath(repo, "config")
+
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return err
+	}
+
+	cfg.DeleteSection(section)
+	return cfg.SaveTo(path)
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DescribeOptions struct {
+	Tags       bool     // lightweight tags count too, not only annotated ones
+	Match      []string // glob patterns the tag name has to match, any of them
+	Exclude    []string // glob patterns of tag names to leave out
+	Candidates int      // how many tags to consider, 10 when zero
+}
+
+type describeTag struct {
+	name      string
+	annotated bool
+	date      int64 // tagger date of annotated tags
+}
+
+func (opts DescribeOptions) wants(name string) bool {
+	for _, pattern := range opts.Exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(opts.Match) == 0 {
+		return true
+	}
+	for _, pattern := range opts.Match {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagDate is the tagger timestamp of an annotated tag.
+func tagDate(tag *GitTag) int64 {
+	fields := strings.Fields(tag.GetData().Get("tagger"))
+	if len(fields) < 2 {
+		return 0
+	}
+	epoch, _ := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	return epoch
+}
+
+// describeTags maps every tagged commit to the tag that best names it:
+// annotated over lightweight, then the most recent.
+func describeTags(repo Repo, opts DescribeOptions, lightweight bool) map[string]describeTag {
+	names := make(map[string]describeTag)
+
+	refs := ListRefs(repo, "refs/tags/")
+	for _, ref := range SortedRefNames(refs) {
+		name := strings.TrimPrefix(ref, "refs/tags/")
+		if !opts.wants(name) {
+			continue
+		}
+
+		sha := refs[ref]
+		tag, annotated := ObjectRead(repo, sha).(*GitTag)
+		if !annotated && !lightweight {
+			continue
+		}
+
+		commit, err := PeelTo(repo, sha, "commit")
+		if err != nil {
+			continue
+		}
+
+		candidate := describeTag{name: name, annotated: annotated}
+		if annotated {
+			candidate.date = tagDate(tag)
+		}
+
+		current, ok := names[commit]
+		if !ok || (candidate.annotated && !current.annotated) ||
+			(candidate.annotated == current.annotated && candidate.date > current.date) {
+			names[commit] = candidate
+		}
+	}
+	return names
+}
+
+// Describe finds the nearest tag reachable from commit and the number of
+// commits since it, like "v1.4.2" and 7 for v1.4.2-7-gabc1234.
+func Describe(repo Repo, commit string, opts DescribeOptions) (string, int, error) {
+	names := describeTags(repo, opts, opts.Tags)
+	if len(names) == 0 {
+		return "", 0, fmt.Errorf("no names found, cannot describe anything")
+	}
+
+	if tag, ok := names[commit]; ok {
+		return tag.name, 0, nil
+	}
+
+	max := opts.Candidates
+	if max == 0 {
+		max = 10
+	}
+
+	// walk back newest first, collecting the first tags met
+	graph := newCommitGraph(repo)
+	queue := newCommitQueue()
+	seen := map[string]bool{commit: true}
+
+	start, err := graph.get(commit)
+	if err != nil {
+		return "", 0, err
+	}
+	queue.push(start)
+
+	var candidates []string
+	for queue.Len() > 0 && len(candidates) < max {
+		c := queue.pop()
+		if _, ok := names[c.sha]; ok {
+			candidates = append(candidates, c.sha)
+		}
+
+		for _, p := range c.parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+
+			pc, err := graph.get(p)
+			if err != nil {
+				return "", 0, err
+			}
+			queue.push(pc)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", 0, fmt.Errorf("no tags can describe '%v'", commit)
+	}
+
+	// the distance is what commit has that the tag doesn't
+	depths := make(map[string]int, len(candidates))
+	for _, tagged := range candidates {
+		walk := NewRevWalk(repo)
+		walk.graph = graph
+		walk.Push(commit)
+		walk.Hide(tagged)
+
+		commits, err := walk.Walk()
+		if err != nil {
+			return "", 0, err
+		}
+		depths[tagged] = len(commits)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return depths[candidates[i]] < depths[candidates[j]]
+	})
+
+	best := candidates[0]
+	return names[best].name, depths[best], nil
+}
+
+// how much stepping to a second parent costs against first parent steps
+// when naming a commit after a tag
+const nameRevMergeWeight = 65535
+
+type revName struct {
+	name       string // e.g. "v1.0^2"
+	generation int    // first parent steps after name, "~generation"
+	distance   int
+	date       int64 // of the tag, older tags win
+	peeled     bool  // named straight after an annotated tag
+}
+
+func (n revName) String() string {
+	switch {
+	case n.generation > 0:
+		return fmt.Sprintf("%s~%d", n.name, n.generation)
+	case n.peeled:
+		return n.name + "^0"
+	}
+	return n.name
+}
+
+// betterThan prefers names based on the older tag, even if farther away.
+func (n revName) betterThan(other revName) bool {
+	if n.date != other.date {
+		return n.date < other.date
+	}
+	return n.distance < other.distance
+}
+
+// DescribeContains names commit after a tag that contains it, the way
+// name-rev does: v1.0~2 is two first parents back from v1.0, v1.0^2 the
+// second parent of the merge it points to.
+func DescribeContains(repo Repo, commit string, opts DescribeOptions) (string, error) {
+	graph := newCommitGraph(repo)
+	names := make(map[string]revName)
+
+	refs := ListRefs(repo, "refs/tags/")
+	for _, ref := range SortedRefNames(refs) {
+		name := strings.TrimPrefix(ref, "refs/tags/")
+		if !opts.wants(name) {
+			continue
+		}
+
+		sha := refs[ref]
+		tip, err := PeelTo(repo, sha, "commit")
+		if err != nil {
+			continue
+		}
+
+		start := revName{name: name}
+		if tag, ok := ObjectRead(repo, sha).(*GitTag); ok {
+			start.peeled = true
+			start.date = tagDate(tag)
+		} else if c, err := graph.get(tip); err == nil {
+			start.date = c.time
+		}
+
+		if err := nameRevFrom(graph, names, tip, start); err != nil {
+			return "", err
+		}
+	}
+
+	name, ok := names[commit]
+	if !ok {
+		return "", fmt.Errorf("cannot describe '%v'", commit)
+	}
+	return name.String(), nil
+}
+
+// nameRevFrom hands name down from tip to all its ancestors, keeping
+// whichever name is better where a commit already has one.
+func nameRevFrom(graph *commitGraph, names map[string]revName, tip string, name revName) error {
+	type item struct {
+		sha  string
+		name revName
+	}
+	stack := []item{{tip, name}}
+
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if current, ok := names[it.sha]; ok && !it.name.betterThan(current) {
+			continue
+		}
+		names[it.sha] = it.name
+
+		c, err := graph.get(it.sha)
+		if err != nil {
+			return err
+		}
+
+		for i := len(c.parents) - 1; i >= 0; i-- {
+			var parent revName
+			if i == 0 {
+				parent = it.name
+				parent.generation++
+				parent.distance++
+				parent.peeled = false
+			} else {
+				parent = revName{
+					name:     fmt.Sprintf("%s^%d", strings.TrimSuffix(it.name.String(), "^0"), i+1),
+					distance: it.name.distance + nameRevMergeWeight,
+					date:     it.name.date,
+				}
+			}
+			stack = append(stack, item{c.parents[i], parent})
+		}
+	}
+	return nil
+}
+
+// AbbrevSha shortens sha to at least n digits, more when that isn't
+// enough to tell it apart from other objects.
+func AbbrevSha(repo Repo, sha string, n int) string {
+	if n < 4 {
+		n = 4
+	}
+	for ; n < len(sha); n++ {
+		if len(objectsByPrefix(repo, sha[:n])) <= 1 {
+			return sha[:n]
+		}
+	}
+	return sha
+}
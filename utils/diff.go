@@ -0,0 +1,716 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SplitLines cuts text into lines, each keeping its "\n". the last line
+// lacks it when the text doesn't end in a newline.
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// DiffMatches diffs a and b the way git's xdiff does by default, so that
+// merges pick the same lines git would among equally short diffs. it gives,
+// for each line of a, the line of b it is kept as, or -1 when it is deleted.
+func DiffMatches(a []string, b []string) []int {
+	classes := make(map[string]int)
+	classify := func(lines []string) []int {
+		ids := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := classes[line]
+			if !ok {
+				id = len(classes)
+				classes[line] = id
+			}
+			ids[i] = id
+		}
+		return ids
+	}
+
+	f1 := newDiffFile(classify(a))
+	f2 := newDiffFile(classify(b))
+	diffFiles(f1, f2)
+
+	match := make([]int, len(a))
+	i, j := 0, 0
+	for {
+		for i < len(a) && f1.changed(i) {
+			match[i] = -1
+			i++
+		}
+		for j < len(b) && f2.changed(j) {
+			j++
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+		match[i] = j
+		i++
+		j++
+	}
+	for ; i < len(a); i++ {
+		match[i] = -1
+	}
+	return match
+}
+
+// diffFile is one side of a diff: its lines as classes, equal lines having
+// equal classes, and which of them changed.
+type diffFile struct {
+	recs []int
+	rchg []bool // rchg[i+1] is for line i, the ends stay false
+
+	// the lines the search looks at, the others are known to change
+	ha     []int
+	rindex []int
+}
+
+func newDiffFile(recs []int) *diffFile {
+	return &diffFile{recs: recs, rchg: make([]bool, len(recs)+2)}
+}
+
+func (f *diffFile) changed(i int) bool {
+	return f.rchg[i+1]
+}
+
+func (f *diffFile) setChanged(i int, changed bool) {
+	f.rchg[i+1] = changed
+}
+
+// diffFiles marks the changed lines of f1 and f2.
+func diffFiles(f1 *diffFile, f2 *diffFile) {
+	prepareDiff(f1, f2)
+
+	n1, n2 := len(f1.ha), len(f2.ha)
+	search := &diffSearch{
+		f1:     f1,
+		f2:     f2,
+		kvdf:   make([]int, n1+n2+3),
+		kvdb:   make([]int, n1+n2+3),
+		offset: n2 + 1,
+	}
+	search.compare(0, n1, 0, n2)
+
+	compactChanges(f1, f2)
+	compactChanges(f2, f1)
+}
+
+// bogoSqrt is xdiff's rough square root.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// prepareDiff leaves the common ends out of the search, and the lines the
+// other side doesn't have at all, which can only change. so do the lines
+// found many times over in the other side when they sit among those.
+func prepareDiff(f1 *diffFile, f2 *diffFile) {
+	count1 := make(map[int]int)
+	for _, id := range f1.recs {
+		count1[id]++
+	}
+	count2 := make(map[int]int)
+	for _, id := range f2.recs {
+		count2[id]++
+	}
+
+	n1, n2 := len(f1.recs), len(f2.recs)
+	start := 0
+	lim := min(n1, n2)
+	for start < lim && f1.recs[start] == f2.recs[start] {
+		start++
+	}
+	end := 0
+	for end < lim-start && f1.recs[n1-1-end] == f2.recs[n2-1-end] {
+		end++
+	}
+
+	discard := func(f *diffFile, other map[int]int, dend int) {
+		mlim := min(bogoSqrt(len(f.recs)), 1024)
+
+		dis := make([]int, len(f.recs)+1)
+		for i := start; i <= dend; i++ {
+			switch nm := other[f.recs[i]]; {
+			case nm == 0:
+				dis[i] = 0
+			case nm >= mlim:
+				dis[i] = 2
+			default:
+				dis[i] = 1
+			}
+		}
+
+		for i := start; i <= dend; i++ {
+			if dis[i] == 1 || (dis[i] == 2 && !cleanMultimatch(dis, i, start, dend)) {
+				f.rindex = append(f.rindex, i)
+				f.ha = append(f.ha, f.recs[i])
+			} else {
+				f.setChanged(i, true)
+			}
+		}
+	}
+	discard(f1, count2, n1-end-1)
+	discard(f2, count1, n2-end-1)
+}
+
+// cleanMultimatch tells whether the line i, found many times in the other
+// side, sits among lines without a match enough to be dropped as well.
+func cleanMultimatch(dis []int, i int, s int, e int) bool {
+	const window = 100
+	s = max(s, i-window)
+	e = min(e, i+window)
+
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			rdis0++
+		} else if dis[i-r] == 2 {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			rdis1++
+		} else if dis[i+r] == 2 {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*4 < rpdis1+rdis1
+}
+
+// diffSearch is Myers' search for the middle snake, splitting the diff in
+// two halves until they are trivial.
+type diffSearch struct {
+	f1, f2     *diffFile
+	kvdf, kvdb []int // furthest reaching paths forward and backward, by diagonal
+	offset     int   // index of diagonal 0 in kvdf and kvdb
+}
+
+func (s *diffSearch) compare(off1 int, lim1 int, off2 int, lim2 int) {
+	ha1, ha2 := s.f1.ha, s.f2.ha
+
+	for off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			s.f2.setChanged(s.f2.rindex[off2], true)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			s.f1.setChanged(s.f1.rindex[off1], true)
+		}
+	default:
+		i1, i2 := s.split(off1, lim1, off2, lim2)
+		s.compare(off1, i1, off2, i2)
+		s.compare(i1, lim1, i2, lim2)
+	}
+}
+
+// split finds where the forward and backward searches meet.
+func (s *diffSearch) split(off1 int, lim1 int, off2 int, lim2 int) (int, int) {
+	ha1, ha2 := s.f1.ha, s.f2.ha
+	kvdf := func(d int) *int { return &s.kvdf[d+s.offset] }
+	kvdb := func(d int) *int { return &s.kvdb[d+s.offset] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for {
+		// grow the diagonals by one, the ones outside the box get a value
+		// that never wins
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return i1, i2
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = math.MaxInt
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = math.MaxInt
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return i1, i2
+			}
+		}
+	}
+}
+
+// a run of changed lines, start to end, in one side of a diff. the groups
+// of both sides go in step, an empty group standing for no change.
+type diffGroup struct {
+	start, end int
+}
+
+func (f *diffFile) groupInit() diffGroup {
+	g := diffGroup{}
+	for f.changed(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (f *diffFile) groupNext(g *diffGroup) bool {
+	if g.end == len(f.recs) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; f.changed(g.end); g.end++ {
+	}
+	return true
+}
+
+func (f *diffFile) groupPrevious(g *diffGroup) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; f.changed(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (f *diffFile) groupSlideDown(g *diffGroup) bool {
+	if g.end < len(f.recs) && f.recs[g.start] == f.recs[g.end] {
+		f.setChanged(g.start, false)
+		f.setChanged(g.end, true)
+		g.start++
+		g.end++
+		for f.changed(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (f *diffFile) groupSlideUp(g *diffGroup) bool {
+	if g.start > 0 && f.recs[g.start-1] == f.recs[g.end-1] {
+		g.start--
+		g.end--
+		f.setChanged(g.start, true)
+		f.setChanged(g.end, false)
+		for f.changed(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// compactChanges slides each run of changed lines in f as far down as it
+// goes, merging the runs it meets, unless it can line up with a change in
+// other on the way.
+func compactChanges(f *diffFile, other *diffFile) {
+	g, go_ := f.groupInit(), other.groupInit()
+
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			endMatchingOther := -1
+
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+
+				for f.groupSlideUp(&g) {
+					other.groupPrevious(&go_)
+				}
+				earliestEnd = g.end
+				if go_.end > go_.start {
+					endMatchingOther = g.end
+				}
+
+				for f.groupSlideDown(&g) {
+					other.groupNext(&go_)
+					if go_.end > go_.start {
+						endMatchingOther = g.end
+					}
+				}
+
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for go_.end == go_.start {
+					f.groupSlideUp(&g)
+					other.groupPrevious(&go_)
+				}
+			}
+		}
+
+		if !f.groupNext(&g) {
+			break
+		}
+		other.groupNext(&go_)
+	}
+}
+
+// unified diffs -----------------------------------
+
+// a change between two texts: chg1 lines of a from i1 give way to chg2
+// lines of b from i2
+type diffChange struct {
+	i1, chg1, i2, chg2 int
+}
+
+func diffChanges(a []string, b []string) []diffChange {
+	match := DiffMatches(a, b)
+
+	var changes []diffChange
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && match[i] == j {
+			i++
+			j++
+			continue
+		}
+		c := diffChange{i1: i, i2: j}
+		for i < len(a) && match[i] == -1 {
+			i++
+		}
+		next := len(b)
+		if i < len(a) {
+			next = match[i]
+		}
+		j = next
+		c.chg1, c.chg2 = i-c.i1, j-c.i2
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// diffFuncName finds the line a hunk starting at line start of a belongs
+// to, like git without a diff driver: the closest line above it starting
+// with a letter, "_" or "$".
+func diffFuncName(a []string, start int) string {
+	for i := start - 1; i >= 0; i-- {
+		line := a[i]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			line = strings.TrimRight(line, " \t\r\n")
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// diffRange is how a hunk header gives a side: the first line and the count,
+// which is left out when it is 1.
+func diffRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// UnifiedDiff gives the hunks of a unified diff from a to b with context
+// lines around the changes, "" when they are the same. changes closer than
+// twice the context share a hunk.
+func UnifiedDiff(a []string, b []string, context int) string {
+	changes := diffChanges(a, b)
+
+	var out strings.Builder
+	line := func(prefix string, text string) {
+		out.WriteString(prefix + text)
+		if !strings.HasSuffix(text, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	for len(changes) > 0 {
+		n := 1
+		for n < len(changes) && changes[n].i1-(changes[n-1].i1+changes[n-1].chg1) <= 2*context {
+			n++
+		}
+		first, last := changes[0], changes[n-1]
+
+		s1 := max(0, first.i1-context)
+		s2 := first.i2 - (first.i1 - s1)
+		e1 := min(len(a), last.i1+last.chg1+context)
+		e2 := last.i2 + last.chg2 + (e1 - (last.i1 + last.chg1))
+
+		header := fmt.Sprintf("@@ -%s +%s @@", diffRange(s1, e1-s1), diffRange(s2, e2-s2))
+		if name := diffFuncName(a, s1); name != "" {
+			header += " " + name
+		}
+		out.WriteString(header + "\n")
+
+		i := s1
+		for _, c := range changes[:n] {
+			for ; i < c.i1; i++ {
+				line(" ", a[i])
+			}
+			for k := 0; k < c.chg1; k++ {
+				line("-", a[c.i1+k])
+			}
+			for k := 0; k < c.chg2; k++ {
+				line("+", b[c.i2+k])
+			}
+			i = c.i1 + c.chg1
+		}
+		for ; i < e1; i++ {
+			line(" ", a[i])
+		}
+
+		changes = changes[n:]
+	}
+	return out.String()
+}
+
+// DiffCount counts the lines added and removed from a to b.
+func DiffCount(a []string, b []string) (added int, removed int) {
+	for _, c := range diffChanges(a, b) {
+		added += c.chg2
+		removed += c.chg1
+	}
+	return added, removed
+}
+
+// tree diffs -----------------------------------
+
+// TreeChange is a file that differs between two trees, Old or New being nil
+// when it was added or deleted.
+type TreeChange struct {
+	Name string
+	Old  *GitIndexEntry
+	New  *GitIndexEntry
+}
+
+// DiffTrees lists the files that differ from tree a to tree b, by name.
+// either can be "" for no tree at all.
+func DiffTrees(repo Repo, a string, b string) ([]TreeChange, error) {
+	old, err := treeFiles(repo, a)
+	if err != nil {
+		return nil, err
+	}
+	new, err := treeFiles(repo, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []TreeChange
+	for name, o := range old {
+		if n, ok := new[name]; !ok || !sameEntry(o, n) {
+			changes = append(changes, TreeChange{Name: name, Old: o, New: new[name]})
+		}
+	}
+	for name, n := range new {
+		if _, ok := old[name]; !ok {
+			changes = append(changes, TreeChange{Name: name, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+func changeBlob(repo Repo, e *GitIndexEntry) string {
+	if e == nil {
+		return ""
+	}
+	if blob := ObjectRead(repo, e.SHA); blob != nil {
+		return blob.Serialize()
+	}
+	return ""
+}
+
+// FormatPatch writes changes as a git style patch.
+func FormatPatch(repo Repo, changes []TreeChange) string {
+	var out strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&out, "diff --git a/%s b/%s\n", c.Name, c.Name)
+
+		oldSha, newSha := ZeroSha, ZeroSha
+		oldName, newName := "a/"+c.Name, "b/"+c.Name
+		mode := ""
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(&out, "new file mode %06o\n", entryMode(c.New))
+			newSha, oldName = c.New.SHA, "/dev/null"
+		case c.New == nil:
+			fmt.Fprintf(&out, "deleted file mode %06o\n", entryMode(c.Old))
+			oldSha, newName = c.Old.SHA, "/dev/null"
+		default:
+			oldSha, newSha = c.Old.SHA, c.New.SHA
+			if entryMode(c.Old) != entryMode(c.New) {
+				fmt.Fprintf(&out, "old mode %06o\nnew mode %06o\n", entryMode(c.Old), entryMode(c.New))
+			} else {
+				mode = fmt.Sprintf(" %06o", entryMode(c.New))
+			}
+		}
+		if oldSha == newSha {
+			continue
+		}
+		fmt.Fprintf(&out, "index %s..%s%s\n", oldSha[:7], newSha[:7], mode)
+
+		a, b := changeBlob(repo, c.Old), changeBlob(repo, c.New)
+		if isBinary(a) || isBinary(b) {
+			fmt.Fprintf(&out, "Binary files %s and %s differ\n", oldName, newName)
+			continue
+		}
+		fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+		out.WriteString(UnifiedDiff(SplitLines(a), SplitLines(b), 3))
+	}
+	return out.String()
+}
+
+// FormatDiffStat writes the --stat summary of changes: a line per file with
+// a graph of its added and removed lines, scaled to fit 80 columns, and the
+// totals.
+func FormatDiffStat(repo Repo, changes []TreeChange) string {
+	type stat struct {
+		name           string
+		added, removed int
+		binary         string
+	}
+	var stats []stat
+	nameWidth, maxChange, totalAdded, totalRemoved := 0, 0, 0, 0
+	for _, c := range changes {
+		a, b := changeBlob(repo, c.Old), changeBlob(repo, c.New)
+		s := stat{name: c.Name}
+		if isBinary(a) || isBinary(b) {
+			s.binary = fmt.Sprintf("Bin %d -> %d bytes", len(a), len(b))
+		} else {
+			s.added, s.removed = DiffCount(SplitLines(a), SplitLines(b))
+		}
+		stats = append(stats, s)
+
+		nameWidth = max(nameWidth, len(s.name))
+		maxChange = max(maxChange, s.added+s.removed)
+		totalAdded += s.added
+		totalRemoved += s.removed
+	}
+
+	numberWidth := len(fmt.Sprint(maxChange))
+	graphWidth := 80 - nameWidth - numberWidth - 6
+	graphWidth = max(graphWidth, 6)
+	scale := func(n int) int {
+		if maxChange <= graphWidth || n == 0 {
+			return n
+		}
+		return 1 + n*(graphWidth-1)/maxChange
+	}
+
+	var out strings.Builder
+	for _, s := range stats {
+		if s.binary != "" {
+			fmt.Fprintf(&out, " %-*s | %s\n", nameWidth, s.name, s.binary)
+			continue
+		}
+		graph := strings.Repeat("+", scale(s.added)) + strings.Repeat("-", scale(s.removed))
+		fmt.Fprintf(&out, " %-*s | %*d %s\n", nameWidth, s.name, numberWidth, s.added+s.removed, graph)
+	}
+
+	plural := func(n int, one string, many string) string {
+		if n == 1 {
+			return one
+		}
+		return many
+	}
+	fmt.Fprintf(&out, " %d %s changed", len(stats), plural(len(stats), "file", "files"))
+	if totalAdded > 0 || totalRemoved == 0 {
+		fmt.Fprintf(&out, ", %d %s(+)", totalAdded, plural(totalAdded, "insertion", "insertions"))
+	}
+	if totalRemoved > 0 || totalAdded == 0 {
+		fmt.Fprintf(&out, ", %d %s(-)", totalRemoved, plural(totalRemoved, "deletion", "deletions"))
+	}
+	out.WriteString("\n")
+	return out.String()
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Editor picks the editor like git does: GIT_EDITOR, core.editor, VISUAL,
+// EDITOR and finally vi.
+func Editor(repo Repo) string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor := ConfigGet(repo, "core", "editor"); editor != "" {
+		return editor
+	}
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(env); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// SequenceEditor is the editor for rebase todo lists: GIT_SEQUENCE_EDITOR,
+// sequence.editor, and otherwise the one for commit messages.
+func SequenceEditor(repo Repo) string {
+	if editor := os.Getenv("GIT_SEQUENCE_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor := ConfigGet(repo, "sequence", "editor"); editor != "" {
+		return editor
+	}
+	return Editor(repo)
+}
+
+// EditFile opens path in the editor and waits for it to exit. the editor
+// setting is a shell command, it may carry arguments of its own.
+func EditFile(repo Repo, path string) error {
+	return EditFileWith(Editor(repo), path)
+}
+
+// EditFileWith is EditFile with a given editor, like the SequenceEditor.
+func EditFileWith(editor string, path string) error {
+	if editor == ":" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// CleanupMessage tidies a message the way git's stripspace does: trailing
+// whitespace and leading and trailing blank lines go, runs of blank lines
+// become one, and with stripComments so do the lines starting with #.
+func CleanupMessage(message string, stripComments bool) string {
+	var lines []string
+	blank := false
+
+	for _, line := range strings.Split(message, "\n") {
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
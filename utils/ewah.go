@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// EWAH compressed bitmaps, as used by the index extensions.
+//
+// layout: uint32 bit count, uint32 word count, the 64-bit words, uint32
+// position of the last running length word. every running length word holds
+// the run bit (bit 0), the run length in words (bits 1-32) and the number of
+// literal words following it (bits 33-63).
+
+const (
+	ewahMaxRun      = 1<<32 - 1
+	ewahMaxLiterals = 1<<31 - 1
+)
+
+func ewahEncode(bits []bool) []byte {
+	words := make([]uint64, (len(bits)+63)/64)
+	for i, set := range bits {
+		if set {
+			words[i/64] |= 1 << (i % 64)
+		}
+	}
+
+	var out []uint64
+	rlwPos := 0
+
+	for i := 0; i < len(words) || len(out) == 0; {
+		rlwPos = len(out)
+		out = append(out, 0)
+
+		var runBit uint64
+		run := 0
+		if i < len(words) && (words[i] == 0 || words[i] == ^uint64(0)) {
+			if words[i] != 0 {
+				runBit = 1
+			}
+			for i < len(words) && run < ewahMaxRun && words[i] == -runBit {
+				run++
+				i++
+			}
+		}
+
+		literals := 0
+		for i < len(words) && literals < ewahMaxLiterals && words[i] != 0 && words[i] != ^uint64(0) {
+			out = append(out, words[i])
+			literals++
+			i++
+		}
+
+		out[rlwPos] = runBit | uint64(run)<<1 | uint64(literals)<<33
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(bits)))
+	binary.Write(&buf, binary.BigEndian, uint32(len(out)))
+	for _, w := range out {
+		binary.Write(&buf, binary.BigEndian, w)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(rlwPos))
+
+	return buf.Bytes()
+}
+
+// ewahDecode returns the bitmap and the number of bytes it took up in raw.
+func ewahDecode(raw []byte) ([]bool, int, error) {
+	if len(raw) < 8 {
+		return nil, 0, fmt.Errorf("truncated ewah bitmap")
+	}
+
+	bitCount := int(binary.BigEndian.Uint32(raw[0:4]))
+	wordCount := int(binary.BigEndian.Uint32(raw[4:8]))
+	size := 8 + 8*wordCount + 4
+	if len(raw) < size {
+		return nil, 0, fmt.Errorf("truncated ewah bitmap")
+	}
+
+	bits := make([]bool, 0, bitCount)
+	push := func(word uint64, n int) {
+		for b := 0; b < n && len(bits) < bitCount; b++ {
+			bits = append(bits, word&(1<<b) != 0)
+		}
+	}
+
+	for i := 0; i < wordCount; {
+		rlw := binary.BigEndian.Uint64(raw[8+8*i:])
+		i++
+
+		var fill uint64
+		if rlw&1 != 0 {
+			fill = ^uint64(0)
+		}
+		for run := (rlw >> 1) & ewahMaxRun; run > 0; run-- {
+			push(fill, 64)
+		}
+
+		for literals := int(rlw >> 33); literals > 0 && i < wordCount; literals-- {
+			push(binary.BigEndian.Uint64(raw[8+8*i:]), 64)
+			i++
+		}
+	}
+
+	for len(bits) < bitCount {
+		bits = append(bits, false)
+	}
+
+	return bits, size, nil
+}
@@ -0,0 +1,364 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the fsmonitor daemon watches the worktree and hands out tokens. asking it
+// with an older token gives every path that changed since then, or "/" when
+// it can't tell (daemon restarted, events lost) and everything must be checked.
+
+const (
+	fsmonitorSocket     = "fsmonitor--daemon.ipc"
+	fsmonitorCookieDir  = "fsmonitor--cookies"
+	fsmonitorMaxChanges = 100000
+)
+
+type fsmonitorChange struct {
+	seq  uint64
+	path string
+}
+
+type FsmonitorDaemon struct {
+	repo    Repo
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	oldest  uint64 // changes before this seq were dropped
+	changes []fsmonitorChange
+	cookies map[string]chan struct{}
+	cookie  int
+}
+
+func FsmonitorEnabled(repo Repo) bool {
+	return ConfigGetBool(repo, "core", "fsmonitor")
+}
+
+func fsmonitorSocketPath(repo Repo) string {
+	return repoPath(repo, fsmonitorSocket)
+}
+
+// index extension ---------------------------------
+
+// FSMN version 2: token, then an ewah bitmap with a bit set for every entry
+// that is not fsmonitor valid.
+func fsmonitorReadExtension(index *GitIndex, data []byte) error {
+	if len(data) < 4 || binary.BigEndian.Uint32(data[:4]) != 2 {
+		return fmt.Errorf("unsupported fsmonitor extension version")
+	}
+
+	nullIdx := bytes.IndexByte(data[4:], 0)
+	if nullIdx == -1 || len(data) < 4+nullIdx+1+4 {
+		return fmt.Errorf("malformed fsmonitor extension")
+	}
+	token := string(data[4 : 4+nullIdx])
+	bitmap := data[4+nullIdx+1+4:]
+
+	dirty, _, err := ewahDecode(bitmap)
+	if err != nil {
+		return err
+	}
+
+	index.FsmonitorToken = token
+	for i := range index.Entries {
+		index.Entries[i].FsmonitorValid = i >= len(dirty) || !dirty[i]
+	}
+	return nil
+}
+
+func fsmonitorWriteExtension(index *GitIndex) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	buf.WriteString(index.FsmonitorToken)
+	buf.WriteByte(0)
+
+	dirty := make([]bool, len(index.Entries))
+	for i, e := range index.Entries {
+		dirty[i] = !e.FsmonitorValid
+	}
+	bitmap := ewahEncode(dirty)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(bitmap)))
+	buf.Write(bitmap)
+	return buf.Bytes()
+}
+
+// client ------------------------------------------
+
+// FsmonitorQuery asks the daemon what changed since token. full is true when
+// the daemon can't answer precisely and every path has to be examined.
+func FsmonitorQuery(repo Repo, token string) (newToken string, paths []string, full bool, err error) {
+	conn, err := net.DialTimeout("unix", fsmonitorSocketPath(repo), time.Second)
+	if err != nil {
+		return "", nil, true, fmt.Errorf("fsmonitor daemon is not running: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "query %s\n", token)
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", nil, true, err
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(reply), "\x00"), "\x00")
+	if len(fields) == 0 || fields[0] == "" {
+		return "", nil, true, fmt.Errorf("bad reply from fsmonitor daemon")
+	}
+
+	for _, f := range fields[1:] {
+		if f == "/" {
+			full = true
+		} else if f != "" {
+			paths = append(paths, f)
+		}
+	}
+	return fields[0], paths, full, nil
+}
+
+// FsmonitorCommand sends a control command ("status" or "stop") to the daemon.
+func FsmonitorCommand(repo Repo, command string) (string, error) {
+	conn, err := net.DialTimeout("unix", fsmonitorSocketPath(repo), time.Second)
+	if err != nil {
+		return "", fmt.Errorf("fsmonitor daemon is not running")
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "%s\n", command)
+
+	reply, err := io.ReadAll(conn)
+	return string(reply), err
+}
+
+// FsmonitorRefresh updates the fsmonitor valid flags of the index entries
+// using the daemon. it returns false if the daemon couldn't be used, in which
+// case every entry has to be examined.
+func FsmonitorRefresh(repo Repo, index *GitIndex) bool {
+	if !FsmonitorEnabled(repo) {
+		return false
+	}
+
+	token, paths, full, err := FsmonitorQuery(repo, index.FsmonitorToken)
+	if err != nil {
+		for i := range index.Entries {
+			index.Entries[i].FsmonitorValid = false
+		}
+		if index.Untracked != nil {
+			index.Untracked.InvalidateAll()
+		}
+		return false
+	}
+
+	if full || index.FsmonitorToken == "" {
+		for i := range index.Entries {
+			index.Entries[i].FsmonitorValid = false
+		}
+		if index.Untracked != nil {
+			index.Untracked.InvalidateAll()
+		}
+	} else {
+		changed := make(map[string]bool, len(paths))
+		for _, p := range paths {
+			changed[p] = true
+
+			// the path may be a directory itself, or an entry of its parent
+			if index.Untracked != nil {
+				index.Untracked.Invalidate(p)
+				index.Untracked.Invalidate(filepath.ToSlash(filepath.Dir(p)))
+			}
+		}
+
+		for i, e := range index.Entries {
+			if !e.FsmonitorValid {
+				continue
+			}
+			// a changed directory (renamed, deleted) invalidates all of its entries
+			for dir := e.Name; dir != "."; dir = filepath.Dir(dir) {
+				if changed[filepath.ToSlash(dir)] {
+					index.Entries[i].FsmonitorValid = false
+					break
+				}
+			}
+		}
+	}
+
+	index.FsmonitorToken = token
+	return true
+}
+
+// daemon ------------------------------------------
+
+func NewFsmonitorDaemon(repo Repo) *FsmonitorDaemon {
+	return &FsmonitorDaemon{
+		repo:    repo,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		cookies: make(map[string]chan struct{}),
+	}
+}
+
+func (d *FsmonitorDaemon) token() string {
+	return fmt.Sprintf("wannagit:%s:%d", d.epoch, d.seq)
+}
+
+// record is called by the platform watcher for every changed path, relative
+// to the worktree with forward slashes.
+func (d *FsmonitorDaemon) record(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cookiePrefix := filepath.ToSlash(filepath.Join(filepath.Base(d.repo.Gitdir), fsmonitorCookieDir)) + "/"
+	if strings.HasPrefix(path, cookiePrefix) {
+		if ch, ok := d.cookies[path[len(cookiePrefix):]]; ok {
+			close(ch)
+			delete(d.cookies, path[len(cookiePrefix):])
+		}
+		return
+	}
+
+	d.seq++
+	d.changes = append(d.changes, fsmonitorChange{seq: d.seq, path: path})
+
+	if len(d.changes) > fsmonitorMaxChanges {
+		drop := len(d.changes) - fsmonitorMaxChanges
+		d.oldest = d.changes[drop].seq
+		d.changes = append([]fsmonitorChange(nil), d.changes[drop:]...)
+	}
+}
+
+// resync forgets everything, used when the watcher lost events.
+func (d *FsmonitorDaemon) resync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	d.seq = 0
+	d.oldest = 0
+	d.changes = nil
+}
+
+// sync writes a cookie file and waits for its event, so that every change
+// made before the query has been seen by the watcher.
+func (d *FsmonitorDaemon) sync() {
+	d.mu.Lock()
+	d.cookie++
+	name := strconv.Itoa(os.Getpid()) + "-" + strconv.Itoa(d.cookie)
+	ch := make(chan struct{})
+	d.cookies[name] = ch
+	d.mu.Unlock()
+
+	path := filepath.Join(d.repo.Gitdir, fsmonitorCookieDir, name)
+	if err := os.WriteFile(path, nil, 0644); err == nil {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+		}
+		os.Remove(path)
+	}
+
+	d.mu.Lock()
+	delete(d.cookies, name)
+	d.mu.Unlock()
+}
+
+func (d *FsmonitorDaemon) answer(token string) []byte {
+	d.sync()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteString(d.token())
+	buf.WriteByte(0)
+
+	parts := strings.Split(token, ":")
+	var since uint64
+	valid := len(parts) == 3 && parts[0] == "wannagit" && parts[1] == d.epoch
+	if valid {
+		var err error
+		since, err = strconv.ParseUint(parts[2], 10, 64)
+		valid = err == nil && since >= d.oldest && since <= d.seq
+	}
+
+	if !valid {
+		buf.WriteString("/\x00")
+		return buf.Bytes()
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range d.changes {
+		if c.seq > since && !seen[c.path] {
+			seen[c.path] = true
+			buf.WriteString(c.path)
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Run watches the worktree and serves queries until a stop command arrives.
+func (d *FsmonitorDaemon) Run() error {
+	_, err := RepoDir(d.repo, true, fsmonitorCookieDir)
+	if err != nil {
+		return err
+	}
+
+	socket := fsmonitorSocketPath(d.repo)
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("fsmonitor daemon is already running")
+	}
+	os.Remove(socket)
+
+	stop, err := fsmonitorWatch(d)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "query"):
+			go func(conn net.Conn, token string) {
+				conn.Write(d.answer(token))
+				conn.Close()
+			}(conn, strings.TrimSpace(strings.TrimPrefix(line, "query")))
+		case line == "status":
+			fmt.Fprintf(conn, "fsmonitor daemon is watching %v\n", d.repo.Worktree)
+			conn.Close()
+		case line == "stop":
+			fmt.Fprintf(conn, "fsmonitor daemon stopped\n")
+			conn.Close()
+			return nil
+		default:
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+type inotifyWatcher struct {
+	fd     int
+	mu     sync.Mutex
+	dirs   map[int]string // watch descriptor -> directory relative to the worktree
+	daemon *FsmonitorDaemon
+}
+
+// fsmonitorWatch starts an inotify watch on every directory of the worktree,
+// plus the cookie directory inside the gitdir.
+func fsmonitorWatch(d *FsmonitorDaemon) (func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		fd:     fd,
+		dirs:   make(map[int]string),
+		daemon: d,
+	}
+
+	w.addTree(".")
+	w.add(filepath.Join(filepath.Base(d.repo.Gitdir), fsmonitorCookieDir))
+
+	go w.loop()
+
+	return func() { syscall.Close(fd) }, nil
+}
+
+func (w *inotifyWatcher) add(rel string) {
+	wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.daemon.repo.Worktree, rel), inotifyMask)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.dirs[wd] = rel
+	w.mu.Unlock()
+}
+
+// addTree watches rel and every directory below it, skipping the gitdir.
+// new directories are also recorded, since files may land in them before
+// the watch is in place.
+func (w *inotifyWatcher) addTree(rel string) {
+	root := filepath.Join(w.daemon.repo.Worktree, rel)
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path == w.daemon.repo.Gitdir {
+			return filepath.SkipDir
+		}
+
+		relPath, _ := filepath.Rel(w.daemon.repo.Worktree, path)
+		if d.IsDir() {
+			w.add(relPath)
+		}
+		if rel != "." {
+			w.daemon.record(filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) loop() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err != nil || n <= 0 {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.daemon.resync()
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, int(event.Wd))
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			rel := filepath.Join(dir, name)
+			if rel == "." {
+				continue
+			}
+
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				w.addTree(rel)
+			}
+			w.daemon.record(filepath.ToSlash(rel))
+		}
+	}
+}
+
+// FsmonitorSpawn starts `fsmonitor run` as a background process detached
+// from the terminal.
+func FsmonitorSpawn(repo Repo) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, "fsmonitor", "run")
+	cmd.Dir = repo.Worktree
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
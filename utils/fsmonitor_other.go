@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import "fmt"
+
+func fsmonitorWatch(d *FsmonitorDaemon) (func(), error) {
+	return nil, fmt.Errorf("fsmonitor is only supported on linux")
+}
+
+func FsmonitorSpawn(repo Repo) error {
+	return fmt.Errorf("fsmonitor is only supported on linux")
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HookPath finds the executable for the hook name in core.hooksPath, or in
+// the hooks directory of the repository. it is "" when there is none.
+func HookPath(repo Repo, name string) string {
+	dir := filepath.Join(repo.Gitdir, "hooks")
+	if hooksPath := ConfigGet(repo, "core", "hooksPath"); hooksPath != "" {
+		dir = ExpandHome(hooksPath)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(repo.Worktree, dir)
+		}
+	}
+
+	path := filepath.Join(dir, name)
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() || stat.Mode().Perm()&0o111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// RunHook runs the hook name, if there is one, from the top of the worktree
+// with args and stdin. its output goes to stderr, and a non zero exit makes
+// an error.
+func RunHook(repo Repo, name string, stdin string, args ...string) error {
+	path := HookPath(repo, name)
+	if path == "" {
+		return nil
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = repo.Worktree
+	cmd.Env = append(os.Environ(), "GIT_DIR="+repo.Gitdir, "GIT_INDEX_FILE="+filepath.Join(repo.Gitdir, "index"))
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("the %v hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// runTransactionHook tells the reference-transaction hook what the
+// transaction does, one "<old> <new> <ref>" line per ref, in state
+// "prepared", "committed" or "aborted".
+func (t *RefTransaction) runTransactionHook(state string) error {
+	if HookPath(t.repo, "reference-transaction") == "" {
+		return nil
+	}
+
+	var stdin bytes.Buffer
+	for _, u := range t.updates {
+		old, new := u.prev, u.New
+		if old == "" {
+			old = ZeroSha
+		}
+		switch {
+		case u.Verify:
+			new = old
+		case u.Delete:
+			new = ZeroSha
+		}
+		fmt.Fprintf(&stdin, "%s %s %s\n", old, new, u.ref)
+	}
+
+	return RunHook(t.repo, "reference-transaction", stdin.String(), state)
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ident is who made a commit or tag, and when.
+type Ident struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// String gives the form author, committer and tagger headers store:
+// "name <email> epoch ±HHMM".
+func (i Ident) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", i.Name, i.Email, i.When.Unix(), FormatTimezone(i.When))
+}
+
+// ParseIdent reads a header like "name <email> epoch ±HHMM".
+func ParseIdent(person string) (Ident, error) {
+	name, email, when := splitPerson(person)
+	if email == "" {
+		return Ident{}, fmt.Errorf("malformed identity: %v", person)
+	}
+
+	ident := Ident{Name: name, Email: strings.Trim(email, "<>")}
+	ident.When, _ = ParsePersonDate(when)
+	return ident, nil
+}
+
+// ParseNameEmail reads "name <email>", as given to --author.
+func ParseNameEmail(s string) (Ident, bool) {
+	name, email, rest := splitPerson(s)
+	if email == "" || rest != "" {
+		return Ident{}, false
+	}
+	return Ident{Name: name, Email: strings.Trim(email, "<>")}, true
+}
+
+// identFor gathers an identity for role ("author" or "committer") the way
+// git does: GIT_<ROLE>_NAME, GIT_<ROLE>_EMAIL and GIT_<ROLE>_DATE first,
+// then <role>.name and <role>.email, then user.name and user.email, at the
+// current time. what override sets takes priority over all of them.
+func identFor(repo Repo, role string, override Ident) (Ident, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+
+	pick := func(key string, given string) string {
+		if given != "" {
+			return given
+		}
+		if value := os.Getenv(env + strings.ToUpper(key)); value != "" {
+			return value
+		}
+		if value := ConfigGet(repo, role, key); value != "" {
+			return value
+		}
+		return ConfigGet(repo, "user", key)
+	}
+
+	ident := Ident{Name: pick("name", override.Name), Email: pick("email", override.Email), When: override.When}
+	if ident.Email == "" {
+		ident.Email = os.Getenv("EMAIL")
+	}
+	if ident.Name == "" || ident.Email == "" {
+		return Ident{}, fmt.Errorf("%s identity unknown, set user.name and user.email in the config", role)
+	}
+
+	if ident.When.IsZero() {
+		ident.When = time.Now()
+		if date := os.Getenv(env + "DATE"); date != "" {
+			when, err := ParseDate(date)
+			if err != nil {
+				return Ident{}, fmt.Errorf("invalid date format: %v", date)
+			}
+			ident.When = when
+		}
+	}
+	return ident, nil
+}
+
+// AuthorIdent is who wrote a change, from GIT_AUTHOR_* or the config,
+// unless override says otherwise, as --author and --date do.
+func AuthorIdent(repo Repo, override Ident) (Ident, error) {
+	return identFor(repo, "author", override)
+}
+
+// CommitterIdent is who records a commit or tag, from GIT_COMMITTER_* or
+// the config.
+func CommitterIdent(repo Repo) (Ident, error) {
+	return identFor(repo, "committer", Ident{})
+}
+
+// dates -----------------------------------
+
+var (
+	epochDateRE = regexp.MustCompile(`^@?(\d+)(?: ([+-]\d{4}))?$`)
+	rawDateRE   = regexp.MustCompile(`^(\d+) ([+-]\d{4})$`)
+)
+
+// layouts of the dates ParseDate takes, those without a zone are in the
+// local one
+var (
+	dateLayouts = []string{
+		"Mon, 2 Jan 2006 15:04:05 -0700", // RFC 2822
+		"2 Jan 2006 15:04:05 -0700",
+		"Mon Jan 2 15:04:05 2006 -0700", // git's default format
+		time.RFC3339,                    // ISO 8601, strict
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02 15:04:05 -0700", // ISO 8601 like, git --date=iso
+		"2006-01-02 15:04:05 Z07:00",
+		"2006-01-02 15:04:05Z07:00",
+	}
+	localDateLayouts = []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"Mon Jan 2 15:04:05 2006",
+	}
+)
+
+// ParseDate reads the dates --date and GIT_AUTHOR_DATE take: RFC 2822,
+// ISO 8601, git's raw "<epoch> ±HHMM" and "@<epoch>", and the relative
+// ones like "yesterday" or "2 days ago". the zone given is kept.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	m := rawDateRE.FindStringSubmatch(s)
+	if m == nil && strings.HasPrefix(s, "@") {
+		m = epochDateRE.FindStringSubmatch(s)
+	}
+	if m != nil {
+		epoch, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		loc := time.UTC
+		if m[2] != "" {
+			if loc, err = ParseTimezone(m[2]); err != nil {
+				return time.Time{}, err
+			}
+		}
+		return time.Unix(epoch, 0).In(loc), nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return Approxidate(s, time.Now())
+}
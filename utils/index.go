@@ -2,12 +2,14 @@ package utils
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math"
 	"os"
 	"slices"
+	"sort"
 )
 
 func IndexRead(repo Repo) (*GitIndex, error) {
@@ -16,7 +18,7 @@ func IndexRead(repo Repo) (*GitIndex, error) {
 	data, err := os.ReadFile(indexFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &GitIndex{}, nil 
+			return &GitIndex{Version: 2}, nil 
 		}
 		return nil, err
 	}
@@ -116,21 +118,76 @@ func IndexRead(repo Repo) (*GitIndex, error) {
 		entries = append(entries, entry)
 	}
 
-	return &GitIndex{
+	index := &GitIndex{
 		Version: version,
 		Entries: entries,
-	}, nil
+	}
+
+	// extensions sit between the entries and the trailing checksum
+	rest := content[idx:]
+	if len(rest) >= sha1.Size {
+		sum := sha1.Sum(data[:len(data)-sha1.Size])
+		if bytes.Equal(sum[:], data[len(data)-sha1.Size:]) {
+			err = indexReadExtensions(index, rest[:len(rest)-sha1.Size])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return index, nil
+}
+
+func indexReadExtensions(index *GitIndex, raw []byte) error {
+	for len(raw) >= 8 {
+		signature := string(raw[:4])
+		size := int(binary.BigEndian.Uint32(raw[4:8]))
+		if 8+size > len(raw) {
+			return fmt.Errorf("truncated index extension %v", signature)
+		}
+		data := raw[8 : 8+size]
+
+		switch signature {
+		case "FSMN":
+			if err := fsmonitorReadExtension(index, data); err != nil {
+				return err
+			}
+		case "UNTC":
+			// a broken cache is simply rebuilt
+			if err := untrackedReadExtension(index, data); err != nil {
+				index.Untracked = nil
+			}
+		default:
+			// lowercase signatures mark extensions that must be understood
+			if signature[0] < 'A' || signature[0] > 'Z' {
+				return fmt.Errorf("unsupported required index extension: %v", signature)
+			}
+		}
+
+		raw = raw[8+size:]
+	}
+	return nil
+}
+
+func indexWriteExtension(buf *bytes.Buffer, signature string, data []byte) {
+	buf.WriteString(signature)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
 }
 
 func IndexWrite(repo Repo, index GitIndex) error {
 	path, err := RepoFile(repo, false, "index")
 	ErrorHandler("error in reading index file", err)
 
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	// git requires the entries sorted by name, then stage
+	sort.SliceStable(index.Entries, func(i, j int) bool {
+		if index.Entries[i].Name != index.Entries[j].Name {
+			return index.Entries[i].Name < index.Entries[j].Name
+		}
+		return index.Entries[i].Stage < index.Entries[j].Stage
+	})
+
+	f := &bytes.Buffer{}
 
 	f.Write([]byte("DIRC"))
 
@@ -174,7 +231,7 @@ func IndexWrite(repo Repo, index GitIndex) error {
 			nameLen = 0xFFF
 		}
 
-		flags := flagAssumeValid | (e.Stage & 0x3000) | uint16(nameLen)
+		flags := flagAssumeValid | ((e.Stage << 12) & 0x3000) | uint16(nameLen)
 		binary.Write(f, binary.BigEndian, flags)
 
 		f.Write(nameBytes)
@@ -189,5 +246,15 @@ func IndexWrite(repo Repo, index GitIndex) error {
 		}
 	}
 
-	return nil
+	if index.FsmonitorToken != "" {
+		indexWriteExtension(f, "FSMN", fsmonitorWriteExtension(&index))
+	}
+	if index.Untracked != nil {
+		indexWriteExtension(f, "UNTC", untrackedWriteExtension(index.Untracked))
+	}
+
+	sum := sha1.Sum(f.Bytes())
+	f.Write(sum[:])
+
+	return os.WriteFile(path, f.Bytes(), 0644)
 }
@@ -0,0 +1,627 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the width of <<<<<<<, ======= and >>>>>>>
+const conflictMarkerSize = 7
+
+// MergeOptions says how conflicts are written out.
+type MergeOptions struct {
+	Base   string // name of the base in diff3 style conflicts
+	Ours   string // name of our side, e.g. HEAD
+	Theirs string // name of their side, e.g. the branch being merged
+	Diff3  bool   // show the base lines too, merge.conflictStyle=diff3
+}
+
+// MergeOptionsFor reads merge.conflictStyle from the config.
+func MergeOptionsFor(repo Repo, ours string, theirs string) MergeOptions {
+	style := ConfigGet(repo, "merge", "conflictStyle")
+	return MergeOptions{
+		Base:   "merged common ancestors",
+		Ours:   ours,
+		Theirs: theirs,
+		Diff3:  style == "diff3" || style == "zdiff3",
+	}
+}
+
+// three-way file merge -----------------------------------
+
+// a run of lines in a three-way merge
+type mergeChunk struct {
+	kind    int      // chunkStable, chunkChanged or chunkConflict
+	lines   []string // the result, unless in conflict
+	o, a, b []string // the base and the two sides of a conflict
+}
+
+const (
+	chunkStable = iota
+	chunkChanged
+	chunkConflict
+)
+
+// Merge3 applies both the changes from base to ours and from base to theirs.
+// where both sides changed the same lines differently the result holds
+// conflict markers, and clean is false. conflicts are kept as small as git
+// keeps them when merging.
+func Merge3(base string, ours string, theirs string, opts MergeOptions) (merged string, clean bool) {
+	chunks := merge3Chunks(SplitLines(base), SplitLines(ours), SplitLines(theirs))
+
+	// diff3 style shows whole conflicts, like git's merge level "eager"
+	if !opts.Diff3 {
+		chunks = simplifyConflicts(refineConflicts(chunks))
+	}
+
+	var out strings.Builder
+	clean = true
+	for _, c := range chunks {
+		if c.kind == chunkConflict {
+			clean = false
+			writeConflict(&out, c, opts)
+		} else {
+			writeLines(&out, c.lines)
+		}
+	}
+	return out.String(), clean
+}
+
+// merge3Chunks walks the base along the lines both sides kept, the chunks in
+// between are where one side, or both, changed something.
+func merge3Chunks(o []string, a []string, b []string) []mergeChunk {
+	matchA, matchB := DiffMatches(o, a), DiffMatches(o, b)
+	var chunks []mergeChunk
+
+	unstable := func(chunkO, chunkA, chunkB []string) {
+		switch {
+		case len(chunkO) == 0 && len(chunkA) == 0 && len(chunkB) == 0:
+		case linesEqual(chunkA, chunkO):
+			chunks = append(chunks, mergeChunk{kind: chunkChanged, lines: chunkB})
+		case linesEqual(chunkB, chunkO), linesEqual(chunkA, chunkB):
+			chunks = append(chunks, mergeChunk{kind: chunkChanged, lines: chunkA})
+		default:
+			chunks = append(chunks, mergeChunk{kind: chunkConflict, o: chunkO, a: chunkA, b: chunkB})
+		}
+	}
+
+	i, j, k := 0, 0, 0
+	for i < len(o) || j < len(a) || k < len(b) {
+		// lines all three agree on
+		n := 0
+		for i+n < len(o) && matchA[i+n] == j+n && matchB[i+n] == k+n {
+			n++
+		}
+		if n > 0 {
+			chunks = append(chunks, mergeChunk{kind: chunkStable, lines: o[i : i+n]})
+			i, j, k = i+n, j+n, k+n
+			continue
+		}
+
+		// up to the next base line both sides kept
+		next := i
+		for next < len(o) && (matchA[next] < 0 || matchB[next] < 0) {
+			next++
+		}
+		if next == len(o) {
+			unstable(o[i:], a[j:], b[k:])
+			break
+		}
+		unstable(o[i:next], a[j:matchA[next]], b[k:matchB[next]])
+		i, j, k = next, matchA[next], matchB[next]
+	}
+	return chunks
+}
+
+// refineConflicts compares the two sides of each conflict, leaving only
+// the lines they don't agree on in conflict.
+func refineConflicts(chunks []mergeChunk) []mergeChunk {
+	var refined []mergeChunk
+
+	for _, c := range chunks {
+		if c.kind != chunkConflict {
+			refined = append(refined, c)
+			continue
+		}
+
+		match := DiffMatches(c.a, c.b)
+		i, j := 0, 0
+		for i < len(c.a) || j < len(c.b) {
+			n := 0
+			for i+n < len(c.a) && match[i+n] == j+n {
+				n++
+			}
+			if n > 0 {
+				refined = append(refined, mergeChunk{kind: chunkStable, lines: c.a[i : i+n]})
+				i, j = i+n, j+n
+				continue
+			}
+
+			next := i
+			for next < len(c.a) && match[next] < 0 {
+				next++
+			}
+			nextB := len(c.b)
+			if next < len(c.a) {
+				nextB = match[next]
+			}
+			refined = append(refined, mergeChunk{kind: chunkConflict, a: c.a[i:next], b: c.b[j:nextB]})
+			i, j = next, nextB
+		}
+	}
+	return refined
+}
+
+// simplifyConflicts joins conflicts with no more than three unchanged lines
+// between them, which read better as one.
+func simplifyConflicts(chunks []mergeChunk) []mergeChunk {
+	var out []mergeChunk
+	for _, c := range chunks {
+		// runs of unchanged lines count as one
+		if last := len(out) - 1; last >= 0 && c.kind == chunkStable && out[last].kind == chunkStable {
+			out[last].lines = append(append([]string(nil), out[last].lines...), c.lines...)
+			continue
+		}
+		out = append(out, c)
+	}
+
+	for i := 0; i+2 < len(out); {
+		first, gap, second := out[i], out[i+1], out[i+2]
+		if first.kind != chunkConflict || gap.kind != chunkStable || second.kind != chunkConflict || len(gap.lines) > 3 {
+			i++
+			continue
+		}
+
+		joined := mergeChunk{kind: chunkConflict}
+		joined.o = concatLines(first.o, gap.lines, second.o)
+		joined.a = concatLines(first.a, gap.lines, second.a)
+		joined.b = concatLines(first.b, gap.lines, second.b)
+		out = append(append(out[:i:i], joined), out[i+3:]...)
+	}
+	return out
+}
+
+func concatLines(parts ...[]string) []string {
+	var lines []string
+	for _, part := range parts {
+		lines = append(lines, part...)
+	}
+	return lines
+}
+
+func linesEqual(x []string, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeConflict puts markers around the two sides of a conflict.
+func writeConflict(out *strings.Builder, c mergeChunk, opts MergeOptions) {
+	marker := func(ch byte, label string) {
+		out.WriteString(strings.Repeat(string(ch), conflictMarkerSize))
+		if label != "" {
+			out.WriteString(" " + label)
+		}
+		out.WriteString("\n")
+	}
+	side := func(lines []string) {
+		writeLines(out, lines)
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			out.WriteString("\n")
+		}
+	}
+
+	marker('<', opts.Ours)
+	side(c.a)
+	if opts.Diff3 {
+		marker('|', opts.Base)
+		side(c.o)
+	}
+	marker('=', "")
+	side(c.b)
+	marker('>', opts.Theirs)
+}
+
+// tree merge -----------------------------------
+
+// TreeMerge is the outcome of merging two trees against their base.
+type TreeMerge struct {
+	Entries   []GitIndexEntry   // stage 0 when clean, stages 1 to 3 for the sides of a conflict
+	Conflicts []string          // the paths in conflict
+	Worktree  map[string]string // what the worktree gets for the conflicted paths, as blob shas
+	Messages  []string          // Auto-merging and CONFLICT lines, as git prints them
+}
+
+// Clean tells whether the merge went through without conflicts.
+func (m *TreeMerge) Clean() bool {
+	return len(m.Conflicts) == 0
+}
+
+// Tree writes the merged tree. conflicts go in as they are in the worktree,
+// markers and all, as for the virtual base of a criss-cross merge.
+func (m *TreeMerge) Tree(repo Repo) string {
+	var entries []GitIndexEntry
+	resolved := make(map[string]bool)
+
+	for _, e := range m.Entries {
+		switch {
+		case e.Stage == 0:
+			entries = append(entries, e)
+		case resolved[e.Name]:
+		case m.Worktree[e.Name] != "":
+			e.Stage, e.SHA = 0, m.Worktree[e.Name]
+			entries = append(entries, e)
+			resolved[e.Name] = true
+		}
+	}
+	return TreeFromIndex(repo, GitIndex{Entries: entries})
+}
+
+func entryMode(e *GitIndexEntry) uint32 {
+	return uint32(e.ModeType)<<12 | uint32(e.ModePerms)
+}
+
+func sameEntry(x *GitIndexEntry, y *GitIndexEntry) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return x.SHA == y.SHA && entryMode(x) == entryMode(y)
+}
+
+// isBinary looks for a NUL byte in the first 8000 bytes, like git.
+func isBinary(data string) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return strings.IndexByte(data, 0) >= 0
+}
+
+func treeFiles(repo Repo, tree string) (map[string]*GitIndexEntry, error) {
+	files := make(map[string]*GitIndexEntry)
+	if tree == "" || tree == EmptyTreeSha {
+		return files, nil
+	}
+
+	entries, err := TreeIndexEntries(repo, tree)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		files[entries[i].Name] = &entries[i]
+	}
+	return files, nil
+}
+
+// MergeTrees merges the changes from base to ours and from base to theirs,
+// file by file. base can be "" when the sides share no history.
+func MergeTrees(repo Repo, base string, ours string, theirs string, opts MergeOptions) (*TreeMerge, error) {
+	o, err := treeFiles(repo, base)
+	if err != nil {
+		return nil, err
+	}
+	a, err := treeFiles(repo, ours)
+	if err != nil {
+		return nil, err
+	}
+	b, err := treeFiles(repo, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, files := range []map[string]*GitIndexEntry{o, a, b} {
+		for name := range files {
+			paths[name] = true
+		}
+	}
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := &TreeMerge{Worktree: make(map[string]string)}
+	for _, name := range names {
+		eo, ea, eb := o[name], a[name], b[name]
+
+		switch {
+		case sameEntry(ea, eb):
+			if ea != nil {
+				m.add(ea, 0)
+			}
+		case sameEntry(eo, ea):
+			if eb != nil {
+				m.add(eb, 0)
+			}
+		case sameEntry(eo, eb):
+			if ea != nil {
+				m.add(ea, 0)
+			}
+		case ea != nil && eb != nil:
+			m.mergeFile(repo, name, eo, ea, eb, opts)
+		default:
+			m.modifyDelete(name, eo, ea, eb, opts)
+		}
+	}
+
+	m.fileDirectory(a, opts)
+	return m, nil
+}
+
+func (m *TreeMerge) add(e *GitIndexEntry, stage uint16) {
+	entry := *e
+	entry.Stage = stage
+	m.Entries = append(m.Entries, entry)
+}
+
+func (m *TreeMerge) conflict(name string, message string, o *GitIndexEntry, a *GitIndexEntry, b *GitIndexEntry) {
+	for stage, e := range []*GitIndexEntry{o, a, b} {
+		if e != nil {
+			m.add(e, uint16(stage+1))
+		}
+	}
+	m.Conflicts = append(m.Conflicts, name)
+	m.Messages = append(m.Messages, message)
+}
+
+// mergeFile merges a file both sides changed.
+func (m *TreeMerge) mergeFile(repo Repo, name string, o *GitIndexEntry, a *GitIndexEntry, b *GitIndexEntry, opts MergeOptions) {
+	kind := "content"
+	if o == nil {
+		kind = "add/add"
+	}
+
+	// a mode only one side changed wins
+	mode := entryMode(a)
+	modeClean := true
+	switch {
+	case entryMode(a) == entryMode(b):
+	case o != nil && entryMode(o) == entryMode(a):
+		mode = entryMode(b)
+	case o != nil && entryMode(o) == entryMode(b):
+	default:
+		modeClean = false
+	}
+
+	merged := *a
+	merged.ModeType, merged.ModePerms = uint16(mode>>12), uint16(mode&0o7777)
+
+	if a.SHA == b.SHA {
+		if modeClean {
+			m.add(&merged, 0)
+			return
+		}
+		m.Worktree[name] = a.SHA
+		m.conflict(name, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name), o, a, b)
+		return
+	}
+
+	m.Messages = append(m.Messages, "Auto-merging "+name)
+
+	// only regular files get their lines merged
+	if a.ModeType != 0b1000 || b.ModeType != 0b1000 {
+		m.Worktree[name] = a.SHA
+		m.conflict(name, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name), o, a, b)
+		return
+	}
+
+	var baseData string
+	if o != nil {
+		baseData = ObjectRead(repo, o.SHA).Serialize()
+	}
+	oursData := ObjectRead(repo, a.SHA).Serialize()
+	theirsData := ObjectRead(repo, b.SHA).Serialize()
+
+	if isBinary(baseData) || isBinary(oursData) || isBinary(theirsData) {
+		m.Messages = append(m.Messages, fmt.Sprintf("warning: Cannot merge binary files: %s (%s vs. %s)", name, opts.Ours, opts.Theirs))
+		m.Worktree[name] = a.SHA
+		m.conflict(name, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name), o, a, b)
+		return
+	}
+
+	text, clean := Merge3(baseData, oursData, theirsData, opts)
+	blob := &GitBlob{}
+	blob.Deserialize(text)
+	sha := ObjectWrite(blob, repo)
+
+	if clean && modeClean {
+		merged.SHA = sha
+		m.add(&merged, 0)
+		return
+	}
+	m.Worktree[name] = sha
+	m.conflict(name, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name), o, a, b)
+}
+
+// modifyDelete handles a file one side deleted and the other changed. the
+// changed version stays in the worktree.
+func (m *TreeMerge) modifyDelete(name string, o *GitIndexEntry, a *GitIndexEntry, b *GitIndexEntry, opts MergeOptions) {
+	deletedIn, modifiedIn, kept := opts.Ours, opts.Theirs, b
+	if a != nil {
+		deletedIn, modifiedIn, kept = opts.Theirs, opts.Ours, a
+	}
+
+	m.Worktree[name] = kept.SHA
+	m.conflict(name, fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.",
+		name, deletedIn, modifiedIn, modifiedIn, name), o, a, b)
+}
+
+// fileDirectory finds the files that are in the way of a directory the
+// other side added. they stay in the index as a conflict, and go to the
+// worktree as <name>~<side>.
+func (m *TreeMerge) fileDirectory(ours map[string]*GitIndexEntry, opts MergeOptions) {
+	dirs := make(map[string]bool)
+	for _, e := range m.Entries {
+		for dir := e.Name; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			dirs[dir] = true
+		}
+	}
+
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Stage != 0 || !dirs[e.Name] {
+			continue
+		}
+
+		stage, label := uint16(3), opts.Theirs
+		if ours[e.Name] != nil && ours[e.Name].SHA == e.SHA {
+			stage, label = 2, opts.Ours
+		}
+		moved := e.Name + "~" + strings.ReplaceAll(label, "/", "_")
+
+		e.Stage = stage
+		m.Worktree[moved] = e.SHA
+		m.Conflicts = append(m.Conflicts, e.Name)
+		m.Messages = append(m.Messages, fmt.Sprintf("CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.",
+			e.Name, label, moved))
+	}
+}
+
+// commit merge -----------------------------------
+
+// MergeBaseTree gives the tree to merge against for bases. several bases,
+// from a criss-cross history, are merged into a virtual one first, and
+// none at all gives "".
+func MergeBaseTree(repo Repo, bases []string) (string, error) {
+	if len(bases) == 0 {
+		return "", nil
+	}
+
+	virtual := bases[0]
+	for _, next := range bases[1:] {
+		inner, err := MergeBases(repo, virtual, next)
+		if err != nil {
+			return "", err
+		}
+		innerTree, err := MergeBaseTree(repo, inner)
+		if err != nil {
+			return "", err
+		}
+
+		virtualTree, err := PeelTo(repo, virtual, "tree")
+		if err != nil {
+			return "", err
+		}
+		nextTree, err := PeelTo(repo, next, "tree")
+		if err != nil {
+			return "", err
+		}
+
+		opts := MergeOptions{Ours: "Temporary merge branch 1", Theirs: "Temporary merge branch 2"}
+		m, err := MergeTrees(repo, innerTree, virtualTree, nextTree, opts)
+		if err != nil {
+			return "", err
+		}
+
+		// the merged bases get a commit of their own, so that the next one
+		// can find what it has in common with them
+		if virtual, err = virtualCommit(repo, m.Tree(repo), virtual, next); err != nil {
+			return "", err
+		}
+	}
+
+	return PeelTo(repo, virtual, "tree")
+}
+
+func virtualCommit(repo Repo, tree string, parents ...string) (string, error) {
+	var when int64
+	for _, parent := range parents {
+		commit, err := readCommit(repo, parent)
+		if err != nil {
+			return "", err
+		}
+		if t := CommitTime(commit); t > when {
+			when = t
+		}
+	}
+
+	commit := &GitCommit{}
+	commit.Data.Add("tree", tree)
+	for _, parent := range parents {
+		commit.Data.Add("parent", parent)
+	}
+	ident := fmt.Sprintf("wannagit <wannagit@localhost> %d +0000", when)
+	commit.Data.Add("author", ident)
+	commit.Data.Add("committer", ident)
+	commit.Data.Message = "merged common ancestors\n"
+	return ObjectWrite(commit, repo), nil
+}
+
+// MergeCommits merges theirs into ours, against their merge bases.
+func MergeCommits(repo Repo, ours string, theirs string, opts MergeOptions) (*TreeMerge, error) {
+	bases, err := MergeBases(repo, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	baseTree, err := MergeBaseTree(repo, bases)
+	if err != nil {
+		return nil, err
+	}
+
+	oursTree, err := PeelTo(repo, ours, "tree")
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := PeelTo(repo, theirs, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return MergeTrees(repo, baseTree, oursTree, theirsTree, opts)
+}
+
+// merge state -----------------------------------
+
+// MergeHeads reads MERGE_HEAD, the commits a merge in progress brings in.
+func MergeHeads(repo Repo) []string {
+	data, err := os.ReadFile(repoPath(repo, "MERGE_HEAD"))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// MergeInProgress tells whether a merge stopped for conflicts.
+func MergeInProgress(repo Repo) bool {
+	return len(MergeHeads(repo)) > 0
+}
+
+// MergeStateWrite records a merge waiting to be committed: MERGE_HEAD,
+// MERGE_MSG and MERGE_MODE.
+func MergeStateWrite(repo Repo, heads []string, message string, mode string) error {
+	var buf bytes.Buffer
+	for _, head := range heads {
+		buf.WriteString(head + "\n")
+	}
+
+	files := map[string]string{"MERGE_HEAD": buf.String(), "MERGE_MSG": message, "MERGE_MODE": mode}
+	for name, content := range files {
+		if err := os.WriteFile(repoPath(repo, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeStateClear forgets the merge in progress, or the cherry-pick or
+// revert stopped for conflicts.
+func MergeStateClear(repo Repo) {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "SQUASH_MSG", "CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		os.Remove(repoPath(repo, name))
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	opts := MergeOptions{Base: "base", Ours: "HEAD", Theirs: "side"}
+	diff3 := opts
+	diff3.Diff3 = true
+
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name   string
+		ours   string
+		theirs string
+		opts   MergeOptions
+		merged string
+		clean  bool
+	}{
+		{"both sides change different lines", "a\nB\nc\nd\ne\n", "a\nb\nc\nd\nE\n", opts, "a\nB\nc\nd\nE\n", true},
+		{"both sides make the same change", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", opts, "a\nB\nc\nd\ne\n", true},
+		{"only theirs changes", base, "a\nb\nc\n", opts, "a\nb\nc\n", true},
+		{
+			"both sides change the same line", "a\nB\nc\nd\ne\n", "a\nX\nc\nd\ne\n", opts,
+			"a\n<<<<<<< HEAD\nB\n=======\nX\n>>>>>>> side\nc\nd\ne\n", false,
+		},
+		{
+			"diff3 shows the base", "a\nB\nc\nd\ne\n", "a\nX\nc\nd\ne\n", diff3,
+			"a\n<<<<<<< HEAD\nB\n||||||| base\nb\n=======\nX\n>>>>>>> side\nc\nd\ne\n", false,
+		},
+	}
+
+	for _, tt := range tests {
+		merged, clean := Merge3(base, tt.ours, tt.theirs, tt.opts)
+		if merged != tt.merged || clean != tt.clean {
+			t.Errorf("%v: got clean=%v\n%v\nwant clean=%v\n%v", tt.name, clean, merged, tt.clean, tt.merged)
+		}
+	}
+}
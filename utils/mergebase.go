@@ -0,0 +1,224 @@
+package utils
+
+const (
+	paintParent1 = 1 << iota
+	paintParent2
+	paintStale
+	paintResult
+)
+
+// paintDownToCommon walks back from one and twos in date order, painting
+// what each side reaches. the commits reached from both sides first are the
+// candidates for a merge base.
+func paintDownToCommon(graph *commitGraph, one string, twos []string) ([]string, map[string]int, error) {
+	flags := make(map[string]int)
+	queue := newCommitQueue()
+
+	push := func(sha string, f int) error {
+		c, err := graph.get(sha)
+		if err != nil {
+			return err
+		}
+		flags[sha] |= f
+		queue.push(c)
+		return nil
+	}
+
+	if err := push(one, paintParent1); err != nil {
+		return nil, nil, err
+	}
+	for _, two := range twos {
+		if err := push(two, paintParent2); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	stillInteresting := func() bool {
+		for _, c := range queue.items {
+			if flags[c.sha]&paintStale == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var result []string
+	for queue.Len() > 0 && stillInteresting() {
+		c := queue.pop()
+		f := flags[c.sha] & (paintParent1 | paintParent2 | paintStale)
+
+		if f == paintParent1|paintParent2 {
+			if flags[c.sha]&paintResult == 0 {
+				flags[c.sha] |= paintResult
+				result = append(result, c.sha)
+			}
+			// the ancestors of a common commit are common too, but not the best
+			f |= paintStale
+		}
+
+		for _, p := range c.parents {
+			if flags[p]&f == f {
+				continue
+			}
+			if err := push(p, f); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var bases []string
+	for _, sha := range result {
+		if flags[sha]&paintStale == 0 {
+			bases = append(bases, sha)
+		}
+	}
+	return bases, flags, nil
+}
+
+// removeRedundant drops the commits that are ancestors of another one in
+// the list.
+func removeRedundant(graph *commitGraph, shas []string) ([]string, error) {
+	if len(shas) < 2 {
+		return shas, nil
+	}
+
+	redundant := make(map[string]bool)
+	for i, sha := range shas {
+		if redundant[sha] {
+			continue
+		}
+
+		var others []string
+		for j, other := range shas {
+			if i != j && !redundant[other] {
+				others = append(others, other)
+			}
+		}
+
+		// whatever sha reaches is an ancestor, and sha is one itself when
+		// the others reach it
+		_, flags, err := paintDownToCommon(graph, sha, others)
+		if err != nil {
+			return nil, err
+		}
+		if flags[sha]&paintParent2 != 0 {
+			redundant[sha] = true
+		}
+		for _, other := range others {
+			if flags[other]&paintParent1 != 0 {
+				redundant[other] = true
+			}
+		}
+	}
+
+	var kept []string
+	for _, sha := range shas {
+		if !redundant[sha] {
+			kept = append(kept, sha)
+		}
+	}
+	return kept, nil
+}
+
+// MergeBases returns the best common ancestors of one and each of twos:
+// common ancestors that aren't reachable from another common ancestor.
+// criss-cross histories can have several.
+func MergeBases(repo Repo, one string, twos ...string) ([]string, error) {
+	graph := newCommitGraph(repo)
+
+	for _, two := range twos {
+		if two == one {
+			return []string{one}, nil
+		}
+	}
+
+	bases, _, err := paintDownToCommon(graph, one, twos)
+	if err != nil {
+		return nil, err
+	}
+	return removeRedundant(graph, bases)
+}
+
+// IsAncestor tells whether ancestor is reachable from commit, counting
+// commit itself.
+func IsAncestor(repo Repo, ancestor string, commit string) (bool, error) {
+	if ancestor == commit {
+		return true, nil
+	}
+
+	_, flags, err := paintDownToCommon(newCommitGraph(repo), commit, []string{ancestor})
+	if err != nil {
+		return false, err
+	}
+	return flags[ancestor]&paintParent1 != 0, nil
+}
+
+// MergeBasesOctopus returns the best common ancestors of all of commits,
+// for an octopus merge: the bases of the first two, then the bases of those
+// with the third, and so on.
+func MergeBasesOctopus(repo Repo, commits ...string) ([]string, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	result := []string{commits[0]}
+	for _, next := range commits[1:] {
+		var bases []string
+		seen := make(map[string]bool)
+		for _, sha := range result {
+			found, err := MergeBases(repo, next, sha)
+			if err != nil {
+				return nil, err
+			}
+			for _, base := range found {
+				if !seen[base] {
+					seen[base] = true
+					bases = append(bases, base)
+				}
+			}
+		}
+		result = bases
+	}
+	return removeRedundant(newCommitGraph(repo), result)
+}
+
+// ForkPoint finds where commit forked from ref, taking into account every
+// value ref held according to its reflog, so that a rewritten upstream still
+// gives the commit the branch was started from. it is "" when the merge base
+// isn't one of them.
+func ForkPoint(repo Repo, ref string, commit string) (string, error) {
+	entries, err := ReflogRead(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	add := func(sha string) {
+		if sha == "" || sha == ZeroSha || seen[sha] {
+			return
+		}
+		if sha, err := PeelTo(repo, sha, "commit"); err == nil {
+			seen[sha] = true
+			candidates = append(candidates, sha)
+		}
+	}
+	for _, e := range entries {
+		add(e.Old)
+		add(e.New)
+	}
+	// the tip too, in case the reflog is missing
+	add(ResolveRef(repo, ref))
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	bases, err := MergeBases(repo, commit, candidates...)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) != 1 || !seen[bases[0]] {
+		return "", nil
+	}
+	return bases[0], nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMergeBases(t *testing.T) {
+	repo := testRepo(t)
+
+	// criss-cross: b2 and c2 each merge b1 and c1
+	a := testCommit(t, repo, "a\n")
+	b1 := testCommit(t, repo, "b1\n", a)
+	c1 := testCommit(t, repo, "c1\n", a)
+	b2 := testCommit(t, repo, "b2\n", b1, c1)
+	c2 := testCommit(t, repo, "c2\n", c1, b1)
+
+	// x and y fork from b1, z from a
+	x := testCommit(t, repo, "x\n", b1)
+	y := testCommit(t, repo, "y\n", b1)
+	z := testCommit(t, repo, "z\n", a)
+
+	// a second root
+	r := testCommit(t, repo, "r\n")
+	s := testCommit(t, repo, "s\n", r)
+
+	sorted := func(shas ...string) []string {
+		sort.Strings(shas)
+		return shas
+	}
+
+	tests := []struct {
+		name  string
+		bases func() ([]string, error)
+		want  []string
+	}{
+		{"criss-cross", func() ([]string, error) { return MergeBases(repo, b2, c2) }, sorted(b1, c1)},
+		{"fork", func() ([]string, error) { return MergeBases(repo, x, y) }, []string{b1}},
+		{"ancestor", func() ([]string, error) { return MergeBases(repo, x, a) }, []string{a}},
+		{"same commit", func() ([]string, error) { return MergeBases(repo, x, x) }, []string{x}},
+		{"against several", func() ([]string, error) { return MergeBases(repo, x, y, z) }, []string{b1}},
+		{"octopus", func() ([]string, error) { return MergeBasesOctopus(repo, x, y, z) }, []string{a}},
+		{"octopus of two", func() ([]string, error) { return MergeBasesOctopus(repo, x, y) }, []string{b1}},
+		{"disjoint histories", func() ([]string, error) { return MergeBases(repo, x, s) }, nil},
+		{"disjoint octopus", func() ([]string, error) { return MergeBasesOctopus(repo, x, y, s) }, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.bases()
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if len(got) == 0 {
+			got = nil
+		}
+		if got = sorted(got...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	ancestry := []struct {
+		ancestor, commit string
+		want             bool
+	}{
+		{a, b2, true},
+		{c1, b2, true},
+		{b2, b2, true},
+		{b2, a, false},
+		{c1, b1, false},
+		{b2, c2, false},
+		{r, x, false},
+		{r, s, true},
+	}
+	for _, tt := range ancestry {
+		if got, err := IsAncestor(repo, tt.ancestor, tt.commit); err != nil || got != tt.want {
+			t.Errorf("IsAncestor(%.7v, %.7v) = %v, %v; want %v", tt.ancestor, tt.commit, got, err, tt.want)
+		}
+	}
+}
+
+func TestForkPoint(t *testing.T) {
+	repo := testRepo(t)
+
+	// topic started from o2, then upstream was rewritten to n2 - n3
+	o1 := testCommit(t, repo, "o1\n")
+	o2 := testCommit(t, repo, "o2\n", o1)
+	topic := testCommit(t, repo, "topic\n", o2)
+	n2 := testCommit(t, repo, "n2\n", o1)
+	n3 := testCommit(t, repo, "n3\n", n2)
+
+	other := testCommit(t, repo, "other root\n")
+
+	for _, move := range [][2]string{{ZeroSha, o1}, {o1, o2}, {o2, n2}, {n2, n3}} {
+		tr := NewRefTransaction(repo)
+		tr.Update("refs/heads/upstream", move[1], move[0], "move")
+		if err := tr.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if bases, err := MergeBases(repo, topic, n3); err != nil || !reflect.DeepEqual(bases, []string{o1}) {
+		t.Fatalf("merge base of topic and upstream = %v, %v; want %v", bases, err, o1)
+	}
+	if got, err := ForkPoint(repo, "refs/heads/upstream", topic); err != nil || got != o2 {
+		t.Errorf("ForkPoint of topic = %v, %v; want %v", got, err, o2)
+	}
+	if got, err := ForkPoint(repo, "refs/heads/upstream", other); err != nil || got != "" {
+		t.Errorf("ForkPoint of an unrelated commit = %v, %v; want none", got, err)
+	}
+}
@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NotesDefaultRef is where notes go when no other ref is asked for.
+const NotesDefaultRef = "refs/notes/commits"
+
+// NotesRefName expands a notes ref given on the command line: x and
+// notes/x both name refs/notes/x.
+func NotesRefName(name string) string {
+	switch {
+	case strings.HasPrefix(name, "refs/"):
+		return name
+	case strings.HasPrefix(name, "notes/"):
+		return "refs/" + name
+	}
+	return "refs/notes/" + name
+}
+
+// NotesRef is the notes ref to work on: name when given, otherwise
+// GIT_NOTES_REF, then core.notesRef, then refs/notes/commits.
+func NotesRef(repo Repo, name string) string {
+	if name == "" {
+		name = os.Getenv("GIT_NOTES_REF")
+	}
+	if name == "" {
+		name = ConfigGet(repo, "core", "notesRef")
+	}
+	if name == "" {
+		return NotesDefaultRef
+	}
+	return NotesRefName(name)
+}
+
+// NotesDisplayRefs lists the notes refs log shows: the default one and
+// those matching the notes.displayRef globs, in that order.
+func NotesDisplayRefs(repo Repo) []string {
+	refs := []string{NotesRef(repo, "")}
+	seen := map[string]bool{refs[0]: true}
+
+	names := SortedRefNames(ListRefs(repo, "refs/notes/"))
+	for _, glob := range ConfigGetAll(repo, "notes", "displayRef") {
+		glob = NotesRefName(glob)
+		for _, name := range names {
+			if ok, _ := path.Match(glob, name); ok && !seen[name] {
+				seen[name] = true
+				refs = append(refs, name)
+			}
+		}
+	}
+	return refs
+}
+
+// Notes is the content of a notes commit: the blob of the note on each
+// object, and any other files its tree holds, which are kept as they are.
+type Notes struct {
+	Commit string // "" for a ref with no notes yet
+	Blobs  map[string]string
+	other  []GitIndexEntry
+}
+
+// NotesRead reads the notes in the tree of commit, whatever its fanout. an
+// empty commit gives no notes.
+func NotesRead(repo Repo, commit string) (*Notes, error) {
+	notes := &Notes{Commit: commit, Blobs: make(map[string]string)}
+	if commit == "" {
+		return notes, nil
+	}
+
+	tree, err := PeelTo(repo, commit, "tree")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := TreeIndexEntries(repo, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if name := strings.ReplaceAll(e.Name, "/", ""); IsFullSha(name) && e.ModeType == 0b1000 {
+			notes.Blobs[strings.ToLower(name)] = e.SHA
+			continue
+		}
+		notes.other = append(notes.other, e)
+	}
+	return notes, nil
+}
+
+// NotesReadRef reads the notes ref points at, none when it doesn't exist.
+func NotesReadRef(repo Repo, ref string) (*Notes, error) {
+	return NotesRead(repo, ResolveRef(repo, ref))
+}
+
+// Note gives the content of the note on object, false when there is none.
+func (n *Notes) Note(repo Repo, object string) (string, bool) {
+	sha, ok := n.Blobs[object]
+	if !ok {
+		return "", false
+	}
+	blob, ok := ObjectRead(repo, sha).(*GitBlob)
+	if !ok {
+		return "", false
+	}
+	return blob.Serialize(), true
+}
+
+// notesFanout gives each note its path in the tree. like git, a level of
+// two digit directories is added below a node as soon as each of the 16
+// slots below it holds more than one note, so that the fanout grows with
+// the number of notes.
+func notesFanout(shas []string, depth int, fanout int, paths map[string]string) {
+	var slots [16][]string
+	for _, sha := range shas {
+		digit := strings.IndexByte("0123456789abcdef", sha[depth])
+		slots[digit] = append(slots[digit], sha)
+	}
+
+	if depth%2 == 0 && depth <= 2*fanout {
+		full := true
+		for _, slot := range slots {
+			full = full && len(slot) > 1
+		}
+		if full {
+			fanout++
+		}
+	}
+
+	for _, slot := range slots {
+		switch len(slot) {
+		case 0:
+		case 1:
+			name := slot[0]
+			for i := 0; i < fanout; i++ {
+				name = name[:3*i+2] + "/" + name[3*i+2:]
+			}
+			paths[slot[0]] = name
+		default:
+			notesFanout(slot, depth+1, fanout, paths)
+		}
+	}
+}
+
+// Tree writes the tree of the notes, giving its sha.
+func (n *Notes) Tree(repo Repo) string {
+	shas := make([]string, 0, len(n.Blobs))
+	for sha := range n.Blobs {
+		shas = append(shas, sha)
+	}
+	paths := make(map[string]string)
+	if len(shas) > 0 {
+		notesFanout(shas, 0, 0, paths)
+	}
+
+	entries := append([]GitIndexEntry(nil), n.other...)
+	for object, blob := range n.Blobs {
+		entries = append(entries, GitIndexEntry{ModeType: 0b1000, ModePerms: 0o644, SHA: blob, Name: paths[object]})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return TreeFromIndex(repo, GitIndex{Entries: entries})
+}
+
+// NotesMergeResult is what merging two sets of notes gives: the merged
+// notes, the objects whose notes conflict, and what was done for each of
+// them.
+type NotesMergeResult struct {
+	Notes     *Notes
+	Conflicts map[string]string // object -> content with conflict markers
+	Messages  []string
+}
+
+// NotesStrategies are the ways a note changed on both sides can be
+// resolved; manual leaves it to the user.
+var NotesStrategies = []string{"manual", "ours", "theirs", "union", "cat_sort_uniq"}
+
+// notesCatSortUniq joins the lines of both notes, sorted and without
+// duplicates or empty lines.
+func notesCatSortUniq(ours string, theirs string) string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, line := range append(SplitLines(ours), SplitLines(theirs)...) {
+		line = strings.TrimRight(line, "\n")
+		if line != "" && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// NotesMerge merges the changes from base to theirs into ours. a note both
+// sides changed is resolved with strategy, or with manual, when its lines
+// don't merge, left in Conflicts with markers labelled oursName and
+// theirsName.
+func NotesMerge(repo Repo, base *Notes, ours *Notes, theirs *Notes, strategy string, oursName string, theirsName string) (*NotesMergeResult, error) {
+	result := &NotesMergeResult{
+		Notes:     &Notes{Commit: ours.Commit, Blobs: make(map[string]string), other: ours.other},
+		Conflicts: make(map[string]string),
+	}
+	for object, blob := range ours.Blobs {
+		result.Notes.Blobs[object] = blob
+	}
+
+	objects := make(map[string]bool)
+	for _, n := range []*Notes{base, ours, theirs} {
+		for object := range n.Blobs {
+			objects[object] = true
+		}
+	}
+	sorted := make([]string, 0, len(objects))
+	for object := range objects {
+		sorted = append(sorted, object)
+	}
+	sort.Strings(sorted)
+
+	content := func(n *Notes, object string) string {
+		text, _ := n.Note(repo, object)
+		return text
+	}
+
+	for _, object := range sorted {
+		o, a, b := base.Blobs[object], ours.Blobs[object], theirs.Blobs[object]
+		if b == o || a == b {
+			continue
+		}
+		if a == o {
+			if b == "" {
+				delete(result.Notes.Blobs, object)
+			} else {
+				result.Notes.Blobs[object] = b
+			}
+			continue
+		}
+
+		// changed differently on both sides
+		merged := ""
+		switch strategy {
+		case "ours":
+			result.Messages = append(result.Messages, "Using local notes for "+object)
+			continue
+		case "theirs":
+			result.Messages = append(result.Messages, "Using remote notes for "+object)
+			if b == "" {
+				delete(result.Notes.Blobs, object)
+			} else {
+				result.Notes.Blobs[object] = b
+			}
+			continue
+		case "union":
+			result.Messages = append(result.Messages, "Concatenating local and remote notes for "+object)
+			merged = content(ours, object)
+			if merged != "" && b != "" {
+				merged += "\n"
+			}
+			merged += content(theirs, object)
+		case "cat_sort_uniq":
+			result.Messages = append(result.Messages, "Concatenating unique lines in local and remote notes for "+object)
+			merged = notesCatSortUniq(content(ours, object), content(theirs, object))
+		case "manual":
+			result.Messages = append(result.Messages, "Auto-merging notes for "+object)
+			text, clean := Merge3(content(base, object), content(ours, object), content(theirs, object),
+				MergeOptions{Base: "base", Ours: oursName, Theirs: theirsName})
+			if clean {
+				merged = text
+				break
+			}
+			result.Messages = append(result.Messages, "CONFLICT (content): Merge conflict in notes for object "+object)
+			result.Conflicts[object] = text
+			delete(result.Notes.Blobs, object)
+			continue
+		default:
+			return nil, fmt.Errorf("unknown notes merge strategy %v", strategy)
+		}
+
+		blob := GitBlob{}
+		blob.Deserialize(merged)
+		result.Notes.Blobs[object] = ObjectWrite(&blob, repo)
+	}
+	return result, nil
+}
+
+// the state of a notes merge stopped on conflicts: the commit holding
+// what merged cleanly, the ref being merged into and the conflicting notes
+const (
+	NotesMergePartial  = "NOTES_MERGE_PARTIAL"
+	NotesMergeRef      = "NOTES_MERGE_REF"
+	NotesMergeWorktree = "NOTES_MERGE_WORKTREE"
+)
+
+// NotesMergeInProgress tells whether a notes merge is waiting for its
+// conflicts to be resolved.
+func NotesMergeInProgress(repo Repo) bool {
+	entries, err := os.ReadDir(repoPath(repo, NotesMergeWorktree))
+	return err == nil && len(entries) > 0
+}
+
+// NotesMergeStateWrite saves a notes merge that stopped on conflicts, each
+// conflicting note being a file named after its object.
+func NotesMergeStateWrite(repo Repo, partial string, ref string, conflicts map[string]string) error {
+	dir := repoPath(repo, NotesMergeWorktree)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for object, text := range conflicts {
+		if err := os.WriteFile(repoPath(repo, NotesMergeWorktree, object), []byte(text), 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(repoPath(repo, NotesMergePartial), []byte(partial+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(repoPath(repo, NotesMergeRef), []byte("ref: "+ref+"\n"), 0644)
+}
+
+// NotesMergeStateClear forgets a stopped notes merge.
+func NotesMergeStateClear(repo Repo) {
+	os.RemoveAll(repoPath(repo, NotesMergeWorktree))
+	os.Remove(repoPath(repo, NotesMergePartial))
+	os.Remove(repoPath(repo, NotesMergeRef))
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// notesTestShas gives perDigit shas starting with each hex digit, but only
+// one starting with f when short is set.
+func notesTestShas(perDigit int, short bool) []string {
+	var shas []string
+	for _, d := range "0123456789abcdef" {
+		n := perDigit
+		if short && d == 'f' {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			shas = append(shas, string(d)+string("0123456789abcdef"[i])+strings.Repeat("0", 38))
+		}
+	}
+	return shas
+}
+
+func TestNotesFanout(t *testing.T) {
+	tests := []struct {
+		name   string
+		shas   []string
+		fanout int
+	}{
+		{"a slot with a single note keeps the tree flat", notesTestShas(2, true), 0},
+		{"two notes in every slot add a level", notesTestShas(2, false), 1},
+	}
+
+	for _, tt := range tests {
+		paths := make(map[string]string)
+		notesFanout(tt.shas, 0, 0, paths)
+		for _, sha := range tt.shas {
+			want := sha
+			for i := 0; i < tt.fanout; i++ {
+				want = want[:3*i+2] + "/" + want[3*i+2:]
+			}
+			if paths[sha] != want {
+				t.Errorf("%v: path of %v = %q, want %q", tt.name, sha, paths[sha], want)
+			}
+		}
+	}
+}
+
+func TestNotesTreeRoundTrip(t *testing.T) {
+	repo := testRepo(t)
+	blob := GitBlob{}
+	blob.Deserialize("note\n")
+	note := ObjectWrite(&blob, repo)
+
+	notes := &Notes{Blobs: make(map[string]string)}
+	for _, sha := range notesTestShas(2, false) {
+		notes.Blobs[sha] = note
+	}
+	tree := notes.Tree(repo)
+
+	commit, err := CommitCreate(repo, tree, nil, Ident{Name: "A", Email: "a@example.com"}, Ident{Name: "A", Email: "a@example.com"}, "notes\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := NotesRead(repo, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Blobs) != len(notes.Blobs) {
+		t.Fatalf("read %d notes back, wrote %d", len(read.Blobs), len(notes.Blobs))
+	}
+	for object, sha := range notes.Blobs {
+		if read.Blobs[object] != sha {
+			t.Errorf("note on %v = %q, want %q", object, read.Blobs[object], sha)
+		}
+	}
+}
@@ -7,22 +7,15 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"regexp"
 	"strconv"
-	"strings"
 )
 
 func ObjectRead(repo Repo, sha string) GitObject {
-	path, err := RepoFile(repo, false, "objects", sha[:2], sha[2:])
-	if err != nil {
-		ErrorHandler("couldn't fetch object file", err)
+	if !ObjectExists(repo, sha) {
+		fmt.Printf("Not a valid object file: %v\n", sha)
 		return nil
 	}
-
-	if stat, _ := os.Stat(path); !stat.Mode().IsRegular() {
-		fmt.Printf("Not a valid object file: %v", sha)
-		return nil
-	} 
+	path := repoPath(repo, "objects", sha[:2], sha[2:])
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -59,7 +52,7 @@ func ObjectRead(repo Repo, sha string) GitObject {
 	size, _ := strconv.Atoi(string(rawSlice[spaceIdx+1:nullIdx]))
 
 	if size != len(rawSlice) - nullIdx - 1 {
-		fmt.Printf("malformed object %v: bad length", sha)
+		fmt.Fprintf(os.Stderr, "malformed object %v: bad length\n", sha)
 		return nil
 	}
 	
@@ -76,7 +69,10 @@ func ObjectRead(repo Repo, sha string) GitObject {
 			return nil
 	}
 	
-	obj.Deserialize(string(rawSlice[nullIdx+1:]))
+	if err := obj.Deserialize(string(rawSlice[nullIdx+1:])); err != nil {
+		fmt.Fprintf(os.Stderr, "malformed object %v: %v\n", sha, err)
+		return nil
+	}
 	return obj
 }
 
@@ -109,100 +105,30 @@ func ObjectWrite(obj GitObject, repo Repo) string {
 	return sha
 }
 
-func objectResolve(repo Repo, name string) []string{
-	// resolves HEAD refs, short, long hashes, tags, branches, remote branches.
-	hashRE := "^[0-9A-Fa-f]{4,40}$"
-	var candidates []string 
-
-	if strings.TrimSpace(name) == "" {
-		return []string{""}
-	}
-
-	if name == "HEAD" {
-		return []string{ResolveRef(repo, "HEAD")}
-	}
-
-	if matched, _ := regexp.MatchString(hashRE, name); matched {
-		name = strings.ToLower(name)
-		prefix := name[0:2]
-
-		path, err := RepoDir(repo, false, "objects", prefix)
-		ErrorHandler("couldn't resolve tag object file", err)
-
-		if path != "" {
-			rem := name[2:]
-
-			entries, err := os.ReadDir(path)
-			ErrorHandler("couldn't read the ref directory", err)
-
-			for _, entry := range entries {
-				if strings.HasPrefix(entry.Name(), rem) {
-					candidates = append(candidates, prefix + entry.Name())
-				}
-			}
-		}
-
-		asTag := ResolveRef(repo, "refs/tags/" + name)
-		if asTag != "" {
-			candidates = append(candidates, asTag)
-		}
-
-		asBranch := ResolveRef(repo, "refs/heads/" + name)
-		if asBranch != "" {
-			candidates = append(candidates, asBranch)
-		}
-
-		asRemoteBranch := ResolveRef(repo, "refs/remotes/" + name)
-		if asRemoteBranch != "" {
-			candidates = append(candidates, asRemoteBranch)
-		}
-
-		return candidates
-	}
-
-	return []string{""}
-}
-
+// ObjectFind resolves name (any revision, see RevParse) to a sha. with an
+// objectType the object is checked to be of that type, or peeled to it when
+// follow is set. "" is returned when that's not possible.
 func ObjectFind(repo Repo, name string, objectType string, follow bool) string {
-	shas := objectResolve(repo, name)
-
-	if shas[0] == "" {
-		panic("No such reference: " + shas[0])
-	}
-
-	if len(shas) > 1 {
-		fmt.Printf("Ambiguous reference %v: candidates are:\n", name)
-		for _, sha := range shas {
-			fmt.Printf("%s", sha + "\n")
-		}
-		panic("")
+	sha, err := RevParse(repo, name)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return ""
 	}
-	
-	sha := shas[0]
 
 	if objectType == "" {
 		return sha
 	}
 
-	for {
-		obj := ObjectRead(repo, sha)
-
-		if obj.Format() == objectType {
-			return sha
-		}
-
-		if !follow {
+	if !follow {
+		if obj := ObjectRead(repo, sha); obj == nil || obj.Format() != objectType {
 			return ""
 		}
+		return sha
+	}
 
-		if obj.Format() == "tag" {
-			tag := obj.(*GitTag)
-			sha = tag.GetData()["object"][0]
-		} else if obj.Format() == "commit" && objectType == "tree"{
-			commit := obj.(*GitCommit)
-			sha = commit.GetData()["tree"][0]
-		} else {
-			return ""
-		}
+	sha, err = PeelTo(repo, sha, objectType)
+	if err != nil {
+		return ""
 	}
+	return sha
 }
\ No newline at end of file
@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CommitCreate writes a commit object, signed with signer unless it is nil.
+func CommitCreate(repo Repo, tree string, parents []string, author Ident, committer Ident, message string, signer *SSHSigner) (string, error) {
+	commit := GitCommit{}
+	commit.Data.Add("tree", tree)
+	for _, parent := range parents {
+		commit.Data.Add("parent", parent)
+	}
+
+	commit.Data.Add("author", author.String())
+	commit.Data.Add("committer", committer.String())
+	commit.Data.Message = message
+
+	if signer != nil {
+		if err := SignCommit(&commit, signer); err != nil {
+			return "", err
+		}
+	}
+
+	return ObjectWrite(&commit, repo), nil
+}
+
+// objectType gives the type of the object sha, "" when it isn't there.
+func objectType(repo Repo, sha string) string {
+	if !ObjectExists(repo, sha) {
+		return ""
+	}
+	obj := ObjectRead(repo, sha)
+	if obj == nil {
+		return ""
+	}
+	return obj.Format()
+}
+
+// WriteTree writes index as trees, giving the sha of the root one, or with
+// prefix the one of that directory. the index must be merged, and unless
+// missingOK every object it names must exist.
+func WriteTree(repo Repo, index GitIndex, prefix string, missingOK bool) (string, error) {
+	for _, e := range index.Entries {
+		if e.Stage != 0 {
+			return "", fmt.Errorf("%v: unmerged (%v)", e.Name, e.SHA)
+		}
+		if !missingOK && e.ModeType != 0b1110 && !ObjectExists(repo, e.SHA) {
+			return "", fmt.Errorf("invalid object %06o %v for '%v'", entryMode(&e), e.SHA, e.Name)
+		}
+	}
+
+	tree := TreeFromIndex(repo, index)
+	if strings.Trim(prefix, "/") == "" {
+		return tree, nil
+	}
+	sha, err := treeLookup(repo, tree, prefix)
+	if err != nil || objectType(repo, sha) != "tree" {
+		return "", fmt.Errorf("prefix %v not found", prefix)
+	}
+	return sha, nil
+}
+
+// indexSort puts the entries of index in the order git keeps them, by name
+// then by stage.
+func indexSort(index *GitIndex) {
+	sort.SliceStable(index.Entries, func(i, j int) bool {
+		a, b := index.Entries[i], index.Entries[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Stage < b.Stage
+	})
+}
+
+// ReadTree loads tree into index, replacing what it holds, or with prefix
+// adding it under that directory, which must not have anything in it yet.
+// the entries have no stat data until the files are checked out or added.
+func ReadTree(repo Repo, index *GitIndex, tree string, prefix string) error {
+	entries, err := TreeIndexEntries(repo, tree)
+	if err != nil {
+		return err
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		index.Entries = entries
+		return nil
+	}
+
+	for _, e := range index.Entries {
+		if e.Name == prefix || strings.HasPrefix(e.Name, prefix+"/") {
+			return fmt.Errorf("Entry '%v' overlaps with '%v'.  Cannot bind.", e.Name, prefix)
+		}
+	}
+	for _, e := range entries {
+		e.Name = path.Join(prefix, e.Name)
+		index.Entries = append(index.Entries, e)
+	}
+	indexSort(index)
+	return nil
+}
+
+// readTreeUptodate makes sure the worktree copy of an index entry about to
+// be replaced holds what the index says, so that nothing gets lost.
+func readTreeUptodate(repo Repo, e *GitIndexEntry) error {
+	if repo.Worktree == "" || e == nil {
+		return nil
+	}
+	file := filepath.Join(repo.Worktree, filepath.FromSlash(e.Name))
+	stat, err := os.Lstat(file)
+	if err != nil {
+		return nil
+	}
+
+	blob := GitBlob{}
+	if stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		blob.Deserialize(target)
+	} else if stat.Mode().IsRegular() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		blob.Deserialize(string(data))
+	} else {
+		return nil
+	}
+	if ObjectWrite(&blob, Repo{}) != e.SHA {
+		return fmt.Errorf("Entry '%v' not uptodate. Cannot merge.", e.Name)
+	}
+	return nil
+}
+
+// ErrIndexUnmerged is what ReadTreeMerge fails with for an index that
+// still has conflicts.
+var ErrIndexUnmerged = fmt.Errorf("You need to resolve your current index first")
+
+// ReadTreeMerge reads one to three trees into index the way read-tree -m
+// does. one tree replaces the index, keeping the stat data of the entries
+// that don't change. two trees move the index from the first to the second,
+// keeping the changes staged on top of the first. three trees are a base and
+// the two sides of a merge: the paths only one side changed are resolved and
+// the others are left as stages 1, 2 and 3. an index that differs from the
+// first side where the result would change it is refused, as are worktree
+// files that differ from entries being replaced.
+func ReadTreeMerge(repo Repo, index *GitIndex, trees []string) error {
+	if len(trees) < 1 || len(trees) > 3 {
+		return fmt.Errorf("just how do you expect me to merge %d trees?", len(trees))
+	}
+	sides := make([]map[string]*GitIndexEntry, len(trees))
+	for i, tree := range trees {
+		files, err := treeFiles(repo, tree)
+		if err != nil {
+			return err
+		}
+		sides[i] = files
+	}
+
+	current := make(map[string]*GitIndexEntry)
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		if e.Stage != 0 {
+			return ErrIndexUnmerged
+		}
+		current[e.Name] = e
+	}
+
+	names := make(map[string]bool)
+	for name := range current {
+		names[name] = true
+	}
+	for _, files := range sides {
+		for name := range files {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var entries []GitIndexEntry
+	for _, name := range sorted {
+		i := current[name]
+
+		var result *GitIndexEntry
+		var stages []*GitIndexEntry
+		switch len(trees) {
+		case 1:
+			result = sides[0][name]
+
+		case 2:
+			h, m := sides[0][name], sides[1][name]
+			switch {
+			case sameEntry(h, m), sameEntry(i, m):
+				result = i
+			case sameEntry(i, h):
+				result = m
+			default:
+				return fmt.Errorf("Entry '%v' would be overwritten by merge. Cannot merge.", name)
+			}
+
+		case 3:
+			o, a, b := sides[0][name], sides[1][name], sides[2][name]
+			switch {
+			case sameEntry(a, b), sameEntry(o, b):
+				result = a
+			case sameEntry(o, a):
+				result = b
+			default:
+				stages = []*GitIndexEntry{o, a, b}
+			}
+			// like git without --aggressive, a path removed on either side
+			// is left to resolve
+			if result == nil && o != nil {
+				stages = []*GitIndexEntry{o, a, b}
+			}
+			if !sameEntry(i, a) && (stages != nil || !sameEntry(i, result)) {
+				return fmt.Errorf("Entry '%v' would be overwritten by merge. Cannot merge.", name)
+			}
+		}
+
+		if stages != nil {
+			if err := readTreeUptodate(repo, i); err != nil {
+				return err
+			}
+			for n, e := range stages {
+				if e != nil {
+					staged := *e
+					staged.Stage = uint16(n + 1)
+					entries = append(entries, staged)
+				}
+			}
+			continue
+		}
+
+		if sameEntry(i, result) {
+			if i != nil {
+				entries = append(entries, *i)
+			}
+			continue
+		}
+		if err := readTreeUptodate(repo, i); err != nil {
+			return err
+		}
+		if result != nil {
+			entries = append(entries, GitIndexEntry{ModeType: result.ModeType, ModePerms: result.ModePerms, SHA: result.SHA, Name: name})
+		}
+	}
+
+	index.Entries = entries
+	indexSort(index)
+	return nil
+}
+
+// treeModeType is the type of object a tree entry with mode points at.
+func treeModeType(mode string) string {
+	switch strings.TrimLeft(mode, "0") {
+	case "40000":
+		return "tree"
+	case "160000":
+		return "commit"
+	}
+	return "blob"
+}
+
+// MakeTree writes a tree holding leaves, which name entries of the tree
+// itself, not of trees below it. unless missingOK, the objects must exist;
+// those that do must be of the type their mode says.
+func MakeTree(repo Repo, leaves []GitTreeLeaf, missingOK bool) (string, error) {
+	tree := GitTree{}
+	for _, leaf := range leaves {
+		if strings.Contains(leaf.Path, "/") {
+			return "", fmt.Errorf("path %v contains slash", leaf.Path)
+		}
+		leaf.Mode = strings.TrimLeft(leaf.Mode, "0")
+
+		want := treeModeType(leaf.Mode)
+		got := objectType(repo, leaf.Sha)
+		if got == "" && !missingOK && want != "commit" {
+			return "", fmt.Errorf("entry '%v' object %v is unavailable", leaf.Path, leaf.Sha)
+		}
+		if got != "" && got != want {
+			return "", fmt.Errorf("entry '%v' object type (%v) doesn't match mode type (%v)", leaf.Path, got, want)
+		}
+		tree.Items = append(tree.Items, leaf)
+	}
+	return ObjectWrite(&tree, repo), nil
+}
+
+// ErrTagFsck is what MakeTag fails with for a tag that isn't well formed,
+// as opposed to one naming an object it can't find.
+var ErrTagFsck = fmt.Errorf("tag input does not pass fsck")
+
+// the header lines a tag needs, in order, and what fsck calls them missing
+var tagHeaders = []struct{ name, missing string }{
+	{"object", "missingObject"},
+	{"type", "missingTypeEntry"},
+	{"tag", "missingTagEntry"},
+	{"tagger", "missingTaggerEntry"},
+}
+
+// MakeTag checks data is a well formed tag, the way git fsck checks them,
+// naming an object that exists with the type it says, and writes it.
+func MakeTag(repo Repo, data string) (string, error) {
+	end := strings.Index(data, "\n\n")
+	if end < 0 {
+		if !strings.HasSuffix(data, "\n") {
+			return "", fmt.Errorf("%w: unterminatedHeader: unterminated header", ErrTagFsck)
+		}
+		end = len(data) - 1
+	}
+	if strings.IndexByte(data[:end], 0) >= 0 {
+		return "", fmt.Errorf("%w: nulInHeader: unterminated header: NUL at offset %d", ErrTagFsck, strings.IndexByte(data, 0))
+	}
+
+	lines := strings.Split(data[:end], "\n")
+	values := make(map[string]string)
+	for n, h := range tagHeaders {
+		if n >= len(lines) || !strings.HasPrefix(lines[n], h.name+" ") {
+			return "", fmt.Errorf("%w: %v: invalid format - expected '%v' line", ErrTagFsck, h.missing, h.name)
+		}
+		values[h.name] = strings.TrimPrefix(lines[n], h.name+" ")
+	}
+
+	if !IsFullSha(values["object"]) {
+		return "", fmt.Errorf("%w: badObjectSha: invalid 'object' line format - bad sha1", ErrTagFsck)
+	}
+	switch values["type"] {
+	case "blob", "tree", "commit", "tag":
+	default:
+		return "", fmt.Errorf("%w: badType: invalid 'type' value", ErrTagFsck)
+	}
+	if !CheckRefFormat("refs/tags/" + values["tag"]) {
+		return "", fmt.Errorf("%w: badTagName: invalid 'tag' name: %v", ErrTagFsck, values["tag"])
+	}
+	if _, err := ParseIdent(values["tagger"]); err != nil {
+		return "", fmt.Errorf("%w: badTagger: invalid 'tagger' line: %v", ErrTagFsck, values["tagger"])
+	}
+
+	object := strings.ToLower(values["object"])
+	switch got := objectType(repo, object); got {
+	case "":
+		return "", fmt.Errorf("could not read tagged object '%v'", object)
+	case values["type"]:
+	default:
+		return "", fmt.Errorf("object '%v' tagged as '%v', but is a '%v' type", object, values["type"], got)
+	}
+
+	tag := GitTag{}
+	if err := tag.Deserialize(data); err != nil {
+		return "", fmt.Errorf("%w: badHeader: %v", ErrTagFsck, err)
+	}
+	if tag.Serialize() != data {
+		// the tag must be written byte for byte as given
+		return "", fmt.Errorf("%w: badHeader: headers can't be read back as written", ErrTagFsck)
+	}
+	return ObjectWrite(&tag, repo), nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMakeTag(t *testing.T) {
+	repo := testRepo(t)
+	commit := testCommit(t, repo, "first\n")
+	header := "object " + commit + "\ntype commit\ntag v1\ntagger T <t@example.com> 1700000000 +0000\n"
+
+	sha, err := MakeTag(repo, header+"\nmessage\n")
+	if err != nil {
+		t.Fatalf("MakeTag of a well formed tag: %v", err)
+	}
+	if tag, ok := ObjectRead(repo, sha).(*GitTag); !ok || tag.Serialize() != header+"\nmessage\n" {
+		t.Errorf("the tag written isn't the one given")
+	}
+
+	// git mktag takes a tag that ends with its headers
+	sha, err = MakeTag(repo, header)
+	if err != nil {
+		t.Fatalf("MakeTag of a tag without a message: %v", err)
+	}
+	if tag, ok := ObjectRead(repo, sha).(*GitTag); !ok || tag.Serialize() != header {
+		t.Errorf("the tag without a message isn't written as given")
+	}
+
+	fsck := []struct {
+		name string
+		data string
+	}{
+		{"unterminated header", strings.TrimSuffix(header, "\n")},
+		{"missing tagger", strings.Replace(header, "tagger T <t@example.com> 1700000000 +0000\n", "", 1) + "\n"},
+		{"headers out of order", "type commit\nobject " + commit + "\ntag v1\ntagger T <t@example.com> 1700000000 +0000\n\n"},
+		{"bad object sha", strings.Replace(header, commit, "1234", 1) + "\n"},
+		{"bad type", strings.Replace(header, "type commit", "type branch", 1) + "\n"},
+		{"bad tag name", strings.Replace(header, "tag v1", "tag v..1", 1) + "\n"},
+		{"bad tagger", strings.Replace(header, "T <t@example.com>", "T t@example.com", 1) + "\n"},
+		{"NUL in the headers", strings.Replace(header, "tag v1", "tag v\x001", 1) + "\n"},
+	}
+	for _, tt := range fsck {
+		if _, err := MakeTag(repo, tt.data); !errors.Is(err, ErrTagFsck) {
+			t.Errorf("%v: got %v, want an ErrTagFsck error", tt.name, err)
+		}
+	}
+
+	// well formed, but naming the wrong object
+	for name, data := range map[string]string{
+		"missing object": strings.Replace(header, commit, strings.Repeat("1", 40), 1) + "\n",
+		"wrong type":     strings.Replace(header, "type commit", "type tree", 1) + "\n",
+	} {
+		if _, err := MakeTag(repo, data); err == nil || errors.Is(err, ErrTagFsck) {
+			t.Errorf("%v: got %v, want an error other than ErrTagFsck", name, err)
+		}
+	}
+}
+
+func TestReadTreePrefixOverlap(t *testing.T) {
+	repo := testRepo(t)
+	index := &GitIndex{Entries: []GitIndexEntry{{ModeType: 0b1000, ModePerms: 0o644, SHA: EmptyTreeSha, Name: "sub/a.txt"}}}
+
+	err := ReadTree(repo, index, ObjectWrite(&GitTree{}, repo), "sub/")
+	want := "Entry 'sub/a.txt' overlaps with 'sub'.  Cannot bind."
+	if err == nil || err.Error() != want {
+		t.Errorf("ReadTree into an occupied prefix: got %v, want %q", err, want)
+	}
+}
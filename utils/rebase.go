@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RebaseItem is one line of a rebase todo list: a command, the commit it
+// works on and the rest of the line, the subject or, for exec, the shell
+// command to run.
+type RebaseItem struct {
+	Command string // pick, reword, edit, squash, fixup, drop or exec
+	Sha     string
+	Rest    string
+}
+
+// the commands of a todo list and their one letter forms
+var rebaseCommands = map[string]string{
+	"p": "pick", "r": "reword", "e": "edit", "s": "squash", "f": "fixup", "d": "drop", "x": "exec",
+}
+
+func (item RebaseItem) format(abbrev bool) string {
+	if item.Command == "exec" {
+		return "exec " + item.Rest
+	}
+	sha := item.Sha
+	if abbrev && len(sha) > 7 {
+		sha = sha[:7]
+	}
+	return strings.TrimRight(item.Command+" "+sha+" "+item.Rest, " ")
+}
+
+// FormatRebaseTodo writes the todo list back out, with abbreviated shas
+// when it is for the user to edit.
+func FormatRebaseTodo(items []RebaseItem, abbrev bool) string {
+	var b strings.Builder
+	for _, item := range items {
+		b.WriteString(item.format(abbrev) + "\n")
+	}
+	return b.String()
+}
+
+// rebaseCommitName resolves name to a commit, "" when it isn't one.
+func rebaseCommitName(repo Repo, name string) string {
+	sha, err := RevParse(repo, name)
+	if err != nil {
+		return ""
+	}
+	if sha, err = PeelTo(repo, sha, "commit"); err != nil {
+		return ""
+	}
+	return sha
+}
+
+// ParseRebaseTodo reads a todo list, skipping blank lines and comments, and
+// expands the shas, which may be abbreviated, to full commit names.
+func ParseRebaseTodo(repo Repo, text string) ([]RebaseItem, error) {
+	var items []RebaseItem
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, rest, _ := strings.Cut(line, " ")
+		if long, ok := rebaseCommands[command]; ok {
+			command = long
+		}
+		item := RebaseItem{Command: command}
+
+		switch command {
+		case "exec":
+			item.Rest = strings.TrimSpace(rest)
+			if item.Rest == "" {
+				return nil, fmt.Errorf("missing command on line %d: %v", n+1, line)
+			}
+		case "pick", "reword", "edit", "squash", "fixup", "drop":
+			name, subject, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			item.Sha = rebaseCommitName(repo, name)
+			if item.Sha == "" {
+				return nil, fmt.Errorf("invalid line %d: %v", n+1, line)
+			}
+			item.Rest = subject
+		default:
+			return nil, fmt.Errorf("invalid command '%v' on line %d: %v", command, n+1, line)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// RebaseState is a rebase under way, kept in .wannagit/rebase-merge the way
+// git lays it out, so that --continue, --skip and --abort can pick it up.
+type RebaseState struct {
+	HeadName    string // the branch being rebased, "detached HEAD" when there is none
+	Onto        string
+	OrigHead    string // where the branch was, and where --abort goes back to
+	Todo        []RebaseItem
+	Done        []RebaseItem
+	Interactive bool
+
+	// set while stopped: the commit that stopped, for edit what HEAD was
+	// when it did, and for a squash or fixup chain the message it started
+	// from and the commits folded in so far
+	StoppedSha    string
+	Amend         string
+	SquashMessage string
+	Fixups        []RebaseItem
+}
+
+// RebaseInProgress tells whether a rebase is under way.
+func RebaseInProgress(repo Repo) bool {
+	_, err := os.Stat(repoPath(repo, "rebase-merge"))
+	return err == nil
+}
+
+func rebaseReadFile(repo Repo, name string) string {
+	data, err := os.ReadFile(repoPath(repo, "rebase-merge", name))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// RebaseRead reads the state of the rebase under way.
+func RebaseRead(repo Repo) (*RebaseState, error) {
+	if !RebaseInProgress(repo) {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+
+	state := &RebaseState{
+		HeadName:      strings.TrimSpace(rebaseReadFile(repo, "head-name")),
+		Onto:          strings.TrimSpace(rebaseReadFile(repo, "onto")),
+		OrigHead:      strings.TrimSpace(rebaseReadFile(repo, "orig-head")),
+		StoppedSha:    strings.TrimSpace(rebaseReadFile(repo, "stopped-sha")),
+		Amend:         strings.TrimSpace(rebaseReadFile(repo, "amend")),
+		SquashMessage: rebaseReadFile(repo, "message-squash"),
+	}
+	_, err := os.Stat(repoPath(repo, "rebase-merge", "interactive"))
+	state.Interactive = err == nil
+
+	lists := map[string]*[]RebaseItem{"git-rebase-todo": &state.Todo, "done": &state.Done, "current-fixups": &state.Fixups}
+	for name, list := range lists {
+		items, err := ParseRebaseTodo(repo, rebaseReadFile(repo, name))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		*list = items
+	}
+	return state, nil
+}
+
+// RebaseWrite saves the state of the rebase. the files for a stop that is
+// over are removed.
+func RebaseWrite(repo Repo, state *RebaseState) error {
+	dir := repoPath(repo, "rebase-merge")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"head-name":       state.HeadName + "\n",
+		"onto":            state.Onto + "\n",
+		"orig-head":       state.OrigHead + "\n",
+		"git-rebase-todo": FormatRebaseTodo(state.Todo, false),
+		"done":            FormatRebaseTodo(state.Done, false),
+	}
+	optional := map[string]string{
+		"stopped-sha":    state.StoppedSha,
+		"amend":          state.Amend,
+		"message-squash": state.SquashMessage,
+		"current-fixups": FormatRebaseTodo(state.Fixups, false),
+	}
+	if state.Interactive {
+		files["interactive"] = ""
+	}
+
+	for name, content := range optional {
+		if content == "" {
+			os.Remove(repoPath(repo, "rebase-merge", name))
+			continue
+		}
+		if name == "stopped-sha" || name == "amend" {
+			content += "\n"
+		}
+		files[name] = content
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(repoPath(repo, "rebase-merge", name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebaseClear forgets the rebase, along with the REBASE_HEAD it stopped at.
+func RebaseClear(repo Repo) {
+	os.RemoveAll(repoPath(repo, "rebase-merge"))
+	os.Remove(repoPath(repo, "REBASE_HEAD"))
+}
+
+// AutosquashTodo moves each "fixup! subject" and "squash! subject" commit
+// to just after the commit it names, by subject or by sha, turning its pick
+// into a fixup or a squash. amend! commits, which would need their message
+// to take over the one they name, are left where they are.
+func AutosquashTodo(repo Repo, items []RebaseItem) []RebaseItem {
+	type slot struct {
+		item    RebaseItem
+		subject string
+		follows []RebaseItem
+	}
+	var slots []*slot
+	bySubject := make(map[string]*slot)
+	bySha := make(map[string]*slot)
+
+	for _, item := range items {
+		if item.Command != "pick" {
+			slots = append(slots, &slot{item: item})
+			continue
+		}
+
+		subject := item.Rest
+		if commit, ok := ObjectRead(repo, item.Sha).(*GitCommit); ok {
+			subject, _, _ = strings.Cut(strings.TrimLeft(commit.Data.Message, "\n"), "\n")
+		}
+
+		// fixup! fixup! x goes after x, like a single fixup! x
+		command, target := "", subject
+		for {
+			prefix, rest, ok := strings.Cut(target, "! ")
+			if !ok || (prefix != "fixup" && prefix != "squash" && prefix != "amend") {
+				break
+			}
+			if command == "" {
+				command = prefix
+			}
+			target = rest
+		}
+		if command == "amend" {
+			command = ""
+		}
+
+		// the subject, then a sha, then the start of a subject
+		var found *slot
+		if command != "" {
+			found = bySubject[target]
+			if found == nil && !strings.Contains(target, " ") {
+				if sha := rebaseCommitName(repo, target); sha != "" {
+					found = bySha[sha]
+				}
+			}
+			for i := 0; found == nil && i < len(slots); i++ {
+				if slots[i].item.Command == "pick" && strings.HasPrefix(slots[i].subject, target) {
+					found = slots[i]
+				}
+			}
+		}
+		if found == nil {
+			s := &slot{item: item, subject: subject}
+			slots = append(slots, s)
+			if _, seen := bySubject[subject]; !seen {
+				bySubject[subject] = s
+			}
+			bySha[item.Sha] = s
+			continue
+		}
+
+		item.Command = command
+		found.follows = append(found.follows, item)
+		bySha[item.Sha] = found
+	}
+
+	var out []RebaseItem
+	for _, s := range slots {
+		out = append(out, s.item)
+		out = append(out, s.follows...)
+	}
+	return out
+}
+
+// RebaseHeadWrite records the commit a rebase stopped at in REBASE_HEAD.
+func RebaseHeadWrite(repo Repo, sha string) error {
+	return os.WriteFile(repoPath(repo, "REBASE_HEAD"), []byte(sha+"\n"), 0644)
+}
+
+// RebaseTodoHelp is the comment below a todo list opened in the editor,
+// what being "<range> onto <commit>".
+func RebaseTodoHelp(what string, count int) string {
+	var b bytes.Buffer
+	plural := "s"
+	if count == 1 {
+		plural = ""
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s (%d command%s)\n", what, count, plural)
+	b.WriteString(`#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`)
+	return b.String()
+}
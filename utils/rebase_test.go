@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAutosquashTodo(t *testing.T) {
+	repo := testRepo(t)
+	a := testCommit(t, repo, "add a\n")
+	b := testCommit(t, repo, "add b\n", a)
+	fixA := testCommit(t, repo, "fixup! add a\n", b)
+	squashB := testCommit(t, repo, "squash! "+b[:7]+"\n", fixA)
+	amendA := testCommit(t, repo, "amend! fixup! add\n", squashB)
+	other := testCommit(t, repo, "fixup! nothing like it\n", amendA)
+
+	todo := []RebaseItem{
+		{"pick", a, "add a"},
+		{"pick", b, "add b"},
+		{"exec", "", "make test"},
+		{"pick", fixA, "fixup! add a"},
+		{"pick", squashB, "squash! " + b[:7]},
+		{"pick", amendA, "amend! fixup! add"},
+		{"pick", other, "fixup! nothing like it"},
+	}
+	want := []RebaseItem{
+		{"pick", a, "add a"},
+		{"fixup", fixA, "fixup! add a"},
+		{"pick", b, "add b"},
+		{"squash", squashB, "squash! " + b[:7]},
+		{"exec", "", "make test"},
+		{"pick", amendA, "amend! fixup! add"},
+		{"pick", other, "fixup! nothing like it"},
+	}
+
+	if got := AutosquashTodo(repo, todo); !reflect.DeepEqual(got, want) {
+		t.Errorf("AutosquashTodo:\n got %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,448 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ref format strings, as used by forEachRef --format: %(atom) is replaced by
+// a field of the ref or the object it points to, %(*atom) by a field of the
+// object an annotated tag points to, %% is a percent sign and %xx a byte
+// given in hex.
+
+// RefFields holds the values the atoms of a ref format are computed from.
+type RefFields struct {
+	repo   Repo
+	Name   string
+	Sha    string
+	object GitObject
+	peeled *RefFields // the tagged object, for %(*atom)
+}
+
+func NewRefFields(repo Repo, name string, sha string) *RefFields {
+	f := &RefFields{repo: repo, Name: name, Sha: sha, object: ObjectRead(repo, sha)}
+
+	if tag, ok := f.object.(*GitTag); ok {
+		target := tag.GetData().Get("object")
+		f.peeled = &RefFields{repo: repo, Name: name, Sha: target, object: ObjectRead(repo, target)}
+	}
+	return f
+}
+
+func (f *RefFields) header(key string) string {
+	switch obj := f.object.(type) {
+	case *GitCommit:
+		return obj.GetData().Get(key)
+	case *GitTag:
+		return obj.GetData().Get(key)
+	}
+	return ""
+}
+
+func (f *RefFields) message() string {
+	switch obj := f.object.(type) {
+	case *GitCommit:
+		return obj.GetData().Message
+	case *GitTag:
+		return obj.GetData().Message
+	}
+	return ""
+}
+
+// ShortRefName drops the refs/heads/, refs/tags/ or refs/remotes/ prefix.
+func ShortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
+
+// splitPerson cuts "Name <email> epoch tz" into its parts.
+func splitPerson(person string) (name string, email string, when string) {
+	open := strings.Index(person, "<")
+	closing := strings.LastIndex(person, ">")
+	if open == -1 || closing < open {
+		return person, "", ""
+	}
+	return strings.TrimSpace(person[:open]), person[open : closing+1], strings.TrimSpace(person[closing+1:])
+}
+
+// ParsePersonDate turns the "epoch tz" part of an author line into a time.
+func ParsePersonDate(when string) (time.Time, bool) {
+	fields := strings.Fields(when)
+	if len(fields) != 2 {
+		return time.Time{}, false
+	}
+
+	epoch, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc, err := ParseTimezone(fields[1])
+	if err != nil {
+		loc = time.UTC
+	}
+	return time.Unix(epoch, 0).In(loc), true
+}
+
+// FormatDate writes t the way git's --date=<mode> does.
+func FormatDate(t time.Time, mode string) string {
+	switch mode {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "raw":
+		return fmt.Sprintf("%d %s", t.Unix(), FormatTimezone(t))
+	case "iso", "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case "iso-strict", "iso8601-strict":
+		return t.Format(time.RFC3339)
+	case "short":
+		return t.Format("2006-01-02")
+	case "rfc", "rfc2822":
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	}
+	return t.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+// splitMessage gives the subject (the first paragraph on one line) and the
+// body of a commit or tag message.
+func splitMessage(message string) (string, string) {
+	message = strings.TrimLeft(message, "\n")
+	subject, body, _ := strings.Cut(message, "\n\n")
+	subject = strings.Join(strings.Fields(strings.ReplaceAll(subject, "\n", " ")), " ")
+	return subject, strings.TrimLeft(body, "\n")
+}
+
+// Atom computes one %(atom), like "refname:short" or "*objectname".
+func (f *RefFields) Atom(atom string) (string, error) {
+	if rest, ok := strings.CutPrefix(atom, "*"); ok {
+		if f.peeled == nil {
+			return "", nil
+		}
+		return f.peeled.Atom(rest)
+	}
+
+	name, modifier, _ := strings.Cut(atom, ":")
+
+	switch name {
+	case "refname":
+		return formatRefname(f.Name, modifier)
+
+	case "objectname":
+		switch {
+		case modifier == "":
+			return f.Sha, nil
+		case modifier == "short":
+			return AbbrevSha(f.repo, f.Sha, 7), nil
+		case strings.HasPrefix(modifier, "short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(modifier, "short="))
+			if err != nil {
+				return "", fmt.Errorf("bad objectname modifier: %v", modifier)
+			}
+			return AbbrevSha(f.repo, f.Sha, n), nil
+		}
+		return "", fmt.Errorf("bad objectname modifier: %v", modifier)
+
+	case "objecttype":
+		if f.object == nil {
+			return "", nil
+		}
+		return f.object.Format(), nil
+
+	case "objectsize":
+		if f.object == nil {
+			return "", nil
+		}
+		return strconv.Itoa(len(f.object.Serialize())), nil
+
+	case "tree", "object", "type", "tag":
+		return f.header(name), nil
+
+	case "parent":
+		if commit, ok := f.object.(*GitCommit); ok {
+			return strings.Join(commit.GetData().GetAll("parent"), " "), nil
+		}
+		return "", nil
+
+	case "author", "authorname", "authoremail", "authordate",
+		"committer", "committername", "committeremail", "committerdate",
+		"tagger", "taggername", "taggeremail", "taggerdate":
+		for _, role := range []string{"author", "committer", "tagger"} {
+			if field, ok := strings.CutPrefix(name, role); ok {
+				return formatPerson(f.header(role), field, modifier), nil
+			}
+		}
+
+	case "creator", "creatordate":
+		// the tagger of a tag, the committer of anything else
+		role := "committer"
+		if _, ok := f.object.(*GitTag); ok {
+			role = "tagger"
+		}
+		return formatPerson(f.header(role), strings.TrimPrefix(name, "creator"), modifier), nil
+
+	case "subject":
+		subject, _ := splitMessage(f.message())
+		return subject, nil
+
+	case "body":
+		_, body := splitMessage(f.message())
+		return body, nil
+
+	case "contents":
+		subject, body := splitMessage(f.message())
+		switch modifier {
+		case "subject":
+			return subject, nil
+		case "body":
+			return body, nil
+		}
+		return f.message(), nil
+
+	case "upstream", "push":
+		if !strings.HasPrefix(f.Name, "refs/heads/") {
+			return "", nil
+		}
+		upstream, err := Upstream(f.repo, f.Name)
+		if err != nil {
+			return "", nil
+		}
+		return formatRefname(upstream, modifier)
+
+	case "HEAD":
+		if HeadBranch(f.repo) == f.Name {
+			return "*", nil
+		}
+		return " ", nil
+
+	case "symref":
+		ref, err := ReadRef(f.repo, f.Name)
+		if err != nil || !ref.Symbolic {
+			return "", nil
+		}
+		return formatRefname(ref.Target, modifier)
+	}
+
+	return "", fmt.Errorf("unknown field name: %v", atom)
+}
+
+func formatRefname(name string, modifier string) (string, error) {
+	switch {
+	case modifier == "":
+		return name, nil
+	case modifier == "short":
+		return ShortRefName(name), nil
+	case strings.HasPrefix(modifier, "lstrip=") || strings.HasPrefix(modifier, "strip="):
+		_, value, _ := strings.Cut(modifier, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("bad refname modifier: %v", modifier)
+		}
+		parts := strings.Split(name, "/")
+		if n >= len(parts) {
+			return "", nil
+		}
+		return strings.Join(parts[n:], "/"), nil
+	}
+	return "", fmt.Errorf("bad refname modifier: %v", modifier)
+}
+
+func formatPerson(person string, field string, modifier string) string {
+	if person == "" {
+		return ""
+	}
+	name, email, when := splitPerson(person)
+
+	switch field {
+	case "name":
+		return name
+	case "email":
+		if modifier == "trim" {
+			return strings.Trim(email, "<>")
+		}
+		return email
+	case "date":
+		t, ok := ParsePersonDate(when)
+		if !ok {
+			return ""
+		}
+		return FormatDate(t, modifier)
+	}
+	return person
+}
+
+// Format expands a format string for one ref.
+func (f *RefFields) Format(format string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 >= len(format) {
+			b.WriteByte(c)
+			continue
+		}
+
+		switch next := format[i+1]; {
+		case next == '%':
+			b.WriteByte('%')
+			i++
+
+		case next == '(':
+			closing := strings.IndexByte(format[i:], ')')
+			if closing == -1 {
+				return "", fmt.Errorf("malformed format string %v", format)
+			}
+			value, err := f.Atom(format[i+2 : i+closing])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += closing
+
+		case i+2 < len(format) && isHex(format[i+1]) && isHex(format[i+2]):
+			n, _ := strconv.ParseUint(format[i+1:i+3], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return strings.IndexByte("0123456789abcdefABCDEF", c) != -1
+}
+
+// sorting ------------------------------------------------
+
+// compareVersions orders strings like v1.9 < v1.10, comparing runs of
+// digits as numbers.
+func compareVersions(a string, b string) int {
+	for a != "" && b != "" {
+		if unicode.IsDigit(rune(a[0])) && unicode.IsDigit(rune(b[0])) {
+			i, j := 0, 0
+			for i < len(a) && unicode.IsDigit(rune(a[i])) {
+				i++
+			}
+			for j < len(b) && unicode.IsDigit(rune(b[j])) {
+				j++
+			}
+			na, _ := strconv.ParseUint(a[:i], 10, 64)
+			nb, _ := strconv.ParseUint(b[:j], 10, 64)
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+
+		if a[0] != b[0] {
+			if a[0] < b[0] {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+// sortValue is what key compares on, numbers for dates and sizes.
+func sortValue(f *RefFields, atom string) (string, int64, bool, error) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(atom, "*"), ":")
+
+	if strings.HasSuffix(name, "date") {
+		value, err := f.Atom(atomWithoutModifier(atom) + ":unix")
+		if err != nil {
+			return "", 0, false, err
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return "", n, true, nil
+	}
+	if name == "objectsize" {
+		value, err := f.Atom(atom)
+		if err != nil {
+			return "", 0, false, err
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return "", n, true, nil
+	}
+
+	value, err := f.Atom(atom)
+	return value, 0, false, err
+}
+
+func atomWithoutModifier(atom string) string {
+	name, _, _ := strings.Cut(atom, ":")
+	return name
+}
+
+// SortRefFields orders refs by the given keys, like "refname",
+// "-committerdate" or "version:refname". the last key is the primary one,
+// as with repeated --sort options.
+func SortRefFields(refs []*RefFields, keys []string) error {
+	if len(keys) == 0 {
+		keys = []string{"refname"}
+	}
+
+	var failed error
+	less := func(a *RefFields, b *RefFields, key string) int {
+		descending := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(key, "-")
+
+		var cmp int
+		if atom, ok := strings.CutPrefix(key, "version:"); ok || strings.HasPrefix(key, "v:") {
+			if !ok {
+				atom = strings.TrimPrefix(key, "v:")
+			}
+			va, errA := a.Atom(atom)
+			vb, errB := b.Atom(atom)
+			if errA != nil || errB != nil {
+				failed = fmt.Errorf("unknown sort key: %v", key)
+			}
+			cmp = compareVersions(va, vb)
+		} else {
+			sa, na, numeric, errA := sortValue(a, key)
+			sb, nb, _, errB := sortValue(b, key)
+			if errA != nil || errB != nil {
+				failed = fmt.Errorf("unknown sort key: %v", key)
+			}
+			switch {
+			case numeric && na != nb:
+				cmp = -1
+				if na > nb {
+					cmp = 1
+				}
+			case !numeric:
+				cmp = strings.Compare(sa, sb)
+			}
+		}
+
+		if descending {
+			return -cmp
+		}
+		return cmp
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		for k := len(keys) - 1; k >= 0; k-- {
+			if cmp := less(refs[i], refs[j], keys[k]); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		// refname breaks the ties
+		return refs[i].Name < refs[j].Name
+	})
+	return failed
+}
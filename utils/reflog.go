@@ -0,0 +1,360 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ZeroSha = "0000000000000000000000000000000000000000"
+
+// one line of logs/<ref>:
+// <old sha> <new sha> <name> <<email>> <epoch> <±HHMM>\t<message>
+type ReflogEntry struct {
+	Old      string
+	New      string
+	Identity string
+	Time     time.Time
+	Message  string
+}
+
+func (e ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s %d %s\t%s\n", e.Old, e.New, e.Identity, e.Time.Unix(), FormatTimezone(e.Time), e.Message)
+}
+
+// FormatTimezone gives the ±HHMM offset git writes after timestamps.
+func FormatTimezone(t time.Time) string {
+	_, offset := t.Zone()
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, (offset%3600)/60)
+}
+
+// ParseTimezone turns a ±HHMM offset into a fixed zone.
+func ParseTimezone(tz string) (*time.Location, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, fmt.Errorf("invalid timezone: %v", tz)
+	}
+
+	hours, err1 := strconv.Atoi(tz[1:3])
+	minutes, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", tz)
+	}
+
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(tz, offset), nil
+}
+
+func reflogPath(repo Repo, ref string) string {
+	return repoPath(repo, "logs", filepath.FromSlash(ref))
+}
+
+func ReflogExists(repo Repo, ref string) bool {
+	stat, err := os.Stat(reflogPath(repo, ref))
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// reflogWanted follows core.logAllRefUpdates: by default HEAD, branches,
+// remote-tracking refs and notes get a reflog, other refs only if they
+// already have one.
+func reflogWanted(repo Repo, ref string) bool {
+	if ReflogExists(repo, ref) {
+		return true
+	}
+
+	switch ConfigGet(repo, "core", "logAllRefUpdates") {
+	case "false":
+		return false
+	case "always":
+		return true
+	}
+
+	return ref == "HEAD" || ref == "refs/stash" ||
+		strings.HasPrefix(ref, "refs/heads/") ||
+		strings.HasPrefix(ref, "refs/remotes/") ||
+		strings.HasPrefix(ref, "refs/notes/")
+}
+
+// reflogIdentity is who gets recorded in reflog entries, and when: the
+// committer.
+func reflogIdentity(repo Repo) (string, time.Time) {
+	if ident, err := CommitterIdent(repo); err == nil {
+		return fmt.Sprintf("%s <%s>", ident.Name, ident.Email), ident.When
+	}
+
+	name := ConfigGet(repo, "user", "name")
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s <%s>", name, ConfigGet(repo, "user", "email")), time.Now()
+}
+
+func parseReflogLine(line string) (ReflogEntry, error) {
+	malformed := fmt.Errorf("malformed reflog line: %v", line)
+
+	head, message, _ := strings.Cut(line, "\t")
+	if len(head) < 83 {
+		return ReflogEntry{}, malformed
+	}
+
+	entry := ReflogEntry{
+		Old:     head[:40],
+		New:     head[41:81],
+		Message: message,
+	}
+
+	rest := head[82:]
+	closing := strings.LastIndex(rest, ">")
+	if closing == -1 {
+		return ReflogEntry{}, malformed
+	}
+	entry.Identity = rest[:closing+1]
+
+	fields := strings.Fields(rest[closing+1:])
+	if len(fields) != 2 {
+		return ReflogEntry{}, malformed
+	}
+
+	epoch, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return ReflogEntry{}, malformed
+	}
+	loc, err := ParseTimezone(fields[1])
+	if err != nil {
+		loc = time.UTC
+	}
+	entry.Time = time.Unix(epoch, 0).In(loc)
+
+	return entry, nil
+}
+
+// ReflogRead returns the entries of a ref's reflog, oldest first.
+func ReflogRead(repo Repo, ref string) ([]ReflogEntry, error) {
+	file, err := os.Open(reflogPath(repo, ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		entry, err := parseReflogLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ReflogWrite replaces a reflog, used when expiring or deleting entries.
+func ReflogWrite(repo Repo, ref string, entries []ReflogEntry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.String())
+	}
+
+	path := reflogPath(repo, ref)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// ReflogAppend records that ref moved from old to new.
+func ReflogAppend(repo Repo, ref string, old string, new string, message string) error {
+	if !reflogWanted(repo, ref) {
+		return nil
+	}
+
+	if old == "" {
+		old = ZeroSha
+	}
+	if new == "" {
+		new = ZeroSha
+	}
+
+	// the message has to stay on one line
+	message = strings.Join(strings.Fields(message), " ")
+
+	identity, when := reflogIdentity(repo)
+	entry := ReflogEntry{
+		Old:      old,
+		New:      new,
+		Identity: identity,
+		Time:     when,
+		Message:  message,
+	}
+
+	path := reflogPath(repo, ref)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(entry.String())
+	return err
+}
+
+// ReflogDelete drops the reflog of a deleted ref.
+func ReflogDelete(repo Repo, ref string) {
+	path := reflogPath(repo, ref)
+	if os.Remove(path) == nil {
+		removeEmptyRefDirs(repo, path)
+	}
+}
+
+// ReflogRename moves a reflog along with its renamed ref.
+func ReflogRename(repo Repo, oldRef string, newRef string) error {
+	oldPath := reflogPath(repo, oldRef)
+	if _, err := os.Stat(oldPath); err != nil {
+		return nil
+	}
+
+	newPath := reflogPath(repo, newRef)
+	if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	removeEmptyRefDirs(repo, oldPath)
+	return nil
+}
+
+// reflog revisions ----------------------------------
+
+var approxidateAgoRE = regexp.MustCompile(`^(\d+)[. ]+(second|minute|hour|day|week|month|year)s?[. ]+ago$`)
+
+// Approxidate understands the relative dates accepted in @{...}, like
+// "yesterday" or "3.days.ago", and a few absolute formats.
+func Approxidate(spec string, now time.Time) (time.Time, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+
+	switch spec {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case "today", "midnight":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	if m := approxidateAgoRE.FindStringSubmatch(spec); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, spec, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown date: %v", spec)
+}
+
+// ReflogRef finds the ref whose reflog a short name like "main" refers to.
+func ReflogRef(repo Repo, name string) string {
+	if name == "" {
+		// @{n} on its own is about the current branch
+		if branch := HeadBranch(repo); branch != "" {
+			return branch
+		}
+		return "HEAD"
+	}
+
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if ReflogExists(repo, candidate) {
+			return candidate
+		}
+	}
+	return name
+}
+
+// ReflogResolve resolves <ref>@{<n>} and <ref>@{<date>}.
+func ReflogResolve(repo Repo, ref string, spec string) (string, error) {
+	ref = ReflogRef(repo, ref)
+
+	entries, err := ReflogRead(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no reflog for %v", ref)
+	}
+
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 || n > len(entries) {
+			return "", fmt.Errorf("log for '%v' only has %d entries", ref, len(entries))
+		}
+		if n == len(entries) {
+			// one past the oldest entry gives where it started from
+			if entries[0].Old == ZeroSha {
+				return "", fmt.Errorf("log for '%v' only has %d entries", ref, len(entries))
+			}
+			return entries[0].Old, nil
+		}
+		return entries[len(entries)-1-n].New, nil
+	}
+
+	at, err := Approxidate(spec, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Time.After(at) {
+			return entries[i].New, nil
+		}
+	}
+
+	// a warning on stderr keeps the output scripts read clean
+	fmt.Fprintf(os.Stderr, "warning: log for '%v' only goes back to %v\n", ref, entries[0].Time.Format(time.RFC1123Z))
+	if entries[0].Old == ZeroSha {
+		// the log starts where the ref was created
+		return entries[0].New, nil
+	}
+	return entries[0].Old, nil
+}
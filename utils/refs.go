@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packed-refs ---------------------------------------
+
+type PackedRef struct {
+	Name   string
+	Sha    string
+	Peeled string // the object an annotated tag points to, from a "^" line
+}
+
+// PackedRefsRead parses the packed-refs file, a missing file giving no refs.
+func PackedRefsRead(repo Repo) (map[string]PackedRef, error) {
+	refs := make(map[string]PackedRef)
+
+	file, err := os.Open(repoPath(repo, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var last string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "^"):
+			if ref, ok := refs[last]; ok {
+				ref.Peeled = line[1:]
+				refs[last] = ref
+			}
+		default:
+			sha, name, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("malformed packed-refs line: %v", line)
+			}
+			refs[name] = PackedRef{Name: name, Sha: sha}
+			last = name
+		}
+	}
+
+	return refs, scanner.Err()
+}
+
+// packedRefsFormat gives the contents of a packed-refs file holding refs.
+func packedRefsFormat(refs map[string]PackedRef) string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, name := range names {
+		ref := refs[name]
+		fmt.Fprintf(&b, "%s %s\n", ref.Sha, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&b, "^%s\n", ref.Peeled)
+		}
+	}
+	return b.String()
+}
+
+// packedRefsCommit moves an already written packed-refs.lock into place, an
+// empty set of refs removing the file instead.
+func packedRefsCommit(repo Repo, refs map[string]PackedRef) error {
+	path := repoPath(repo, "packed-refs")
+	lock := path + ".lock"
+
+	if len(refs) == 0 {
+		os.Remove(lock)
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Rename(lock, path)
+}
+
+// PackedRefsWrite replaces the packed-refs file through a lock file.
+func PackedRefsWrite(repo Repo, refs map[string]PackedRef) error {
+	lock := repoPath(repo, "packed-refs") + ".lock"
+
+	file, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to lock packed-refs: %w", err)
+	}
+	if _, err := file.WriteString(packedRefsFormat(refs)); err != nil {
+		file.Close()
+		os.Remove(lock)
+		return err
+	}
+	file.Close()
+
+	return packedRefsCommit(repo, refs)
+}
+
+// PeelTag follows annotated tags down to the object they finally point at.
+func PeelTag(repo Repo, sha string) string {
+	for {
+		tag, ok := ObjectRead(repo, sha).(*GitTag)
+		if !ok {
+			return sha
+		}
+		sha = tag.GetData().Get("object")
+	}
+}
+
+// loose and packed refs together ----------------------
+
+var ErrRefNotFound = fmt.Errorf("ref not found")
+
+// Ref is a single ref read without following it: a symbolic ref names
+// another ref (HEAD -> refs/heads/main), a direct one holds a sha.
+type Ref struct {
+	Name     string
+	Symbolic bool
+	Target   string
+}
+
+// ReadRef reads name one level deep, loose file first then packed-refs.
+func ReadRef(repo Repo, name string) (Ref, error) {
+	data, err := os.ReadFile(looseRefPath(repo, name))
+	if err == nil {
+		value := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			return Ref{Name: name, Symbolic: true, Target: target}, nil
+		}
+		return Ref{Name: name, Target: value}, nil
+	}
+
+	packed, perr := PackedRefsRead(repo)
+	if perr != nil {
+		return Ref{}, perr
+	}
+	if ref, ok := packed[name]; ok {
+		return Ref{Name: name, Target: ref.Sha}, nil
+	}
+
+	return Ref{}, fmt.Errorf("%w: %v", ErrRefNotFound, name)
+}
+
+// ReadSymbolicRef gives the ref a symbolic ref points at, like
+// refs/heads/main for an attached HEAD.
+func ReadSymbolicRef(repo Repo, name string) (string, error) {
+	ref, err := ReadRef(repo, name)
+	if err != nil {
+		return "", err
+	}
+	if !ref.Symbolic {
+		return "", fmt.Errorf("ref %v is not a symbolic ref", name)
+	}
+	return ref.Target, nil
+}
+
+// derefName follows symbolic refs down to the direct ref that holds the sha,
+// which may not exist yet (HEAD on an unborn branch).
+func derefName(repo Repo, name string) string {
+	for depth := 0; depth < 5; depth++ {
+		ref, err := ReadRef(repo, name)
+		if err != nil || !ref.Symbolic {
+			break
+		}
+		name = ref.Target
+	}
+	return name
+}
+
+// HeadBranch returns the full ref HEAD is attached to, or "" when detached.
+func HeadBranch(repo Repo) string {
+	target, err := ReadSymbolicRef(repo, "HEAD")
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// WriteSymbolicRef points name at another ref. a non empty message gets
+// recorded in name's reflog.
+func WriteSymbolicRef(repo Repo, name string, target string, message string) error {
+	old := ResolveRef(repo, name)
+
+	path := looseRefPath(repo, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte("ref: "+target+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if message != "" {
+		return ReflogAppend(repo, name, old, ResolveRef(repo, name), message)
+	}
+	return nil
+}
+
+// DetachHead points HEAD directly at a commit.
+func DetachHead(repo Repo, sha string, message string) error {
+	old := ResolveRef(repo, "HEAD")
+
+	if err := WriteRef(repo, "HEAD", sha); err != nil {
+		return err
+	}
+	return ReflogAppend(repo, "HEAD", old, sha, message)
+}
+
+// ListRefs returns every ref under prefix (e.g. "refs/heads/") with the sha
+// it resolves to. loose refs take priority over packed ones.
+func ListRefs(repo Repo, prefix string) map[string]string {
+	refs := make(map[string]string)
+
+	packed, err := PackedRefsRead(repo)
+	ErrorHandler("couldn't read packed-refs", err)
+	for name, ref := range packed {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = ref.Sha
+		}
+	}
+
+	root := repoPath(repo, "refs")
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(repo.Gitdir, path)
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			if sha := ResolveRef(repo, name); sha != "" {
+				refs[name] = sha
+			}
+		}
+		return nil
+	})
+
+	return refs
+}
+
+// SortedRefNames returns the keys of a ListRefs result in order.
+func SortedRefNames(refs map[string]string) []string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func looseRefPath(repo Repo, ref string) string {
+	return repoPath(repo, filepath.FromSlash(ref))
+}
+
+// WriteRef points ref at sha through a loose ref file, which shadows any
+// packed entry of the same name.
+func WriteRef(repo Repo, ref string, sha string) error {
+	path := looseRefPath(repo, ref)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(sha+"\n"), 0644)
+}
+
+// DeleteRef removes ref both as a loose file and from packed-refs.
+func DeleteRef(repo Repo, ref string) error {
+	path := looseRefPath(repo, ref)
+	looseErr := os.Remove(path)
+	if looseErr == nil {
+		removeEmptyRefDirs(repo, path)
+	}
+
+	packed, err := PackedRefsRead(repo)
+	if err != nil {
+		return err
+	}
+	if _, ok := packed[ref]; ok {
+		delete(packed, ref)
+		return PackedRefsWrite(repo, packed)
+	}
+
+	if os.IsNotExist(looseErr) {
+		return fmt.Errorf("ref %v does not exist", ref)
+	}
+	return looseErr
+}
+
+// removeEmptyRefDirs cleans up the directories left behind by a ref like
+// refs/heads/feature/x, keeping refs/heads and logs/refs/heads themselves.
+func removeEmptyRefDirs(repo Repo, path string) {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(repo.Gitdir, dir)
+		if err != nil {
+			return
+		}
+		rel = filepath.ToSlash(rel)
+
+		keep := 2
+		if strings.HasPrefix(rel, "logs/") {
+			keep = 3
+		}
+		if len(strings.Split(rel, "/")) <= keep || os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// PackRefs moves loose refs into packed-refs, peeling annotated tags. only
+// tags are packed unless all is set, and the loose files go away unless
+// prune is false.
+func PackRefs(repo Repo, all bool, prune bool) error {
+	packed, err := PackedRefsRead(repo)
+	if err != nil {
+		return err
+	}
+
+	var loose []string
+	root := repoPath(repo, "refs")
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(repo.Gitdir, path)
+		name := filepath.ToSlash(rel)
+
+		data, err := os.ReadFile(path)
+		if err != nil || strings.HasPrefix(string(data), "ref: ") {
+			// symbolic refs stay loose
+			return nil
+		}
+		if !all && !strings.HasPrefix(name, "refs/tags/") {
+			return nil
+		}
+
+		sha := strings.TrimSpace(string(data))
+		ref := PackedRef{Name: name, Sha: sha}
+		if peeled := PeelTag(repo, sha); peeled != sha {
+			ref.Peeled = peeled
+		}
+
+		packed[name] = ref
+		loose = append(loose, path)
+		return nil
+	})
+
+	if err := PackedRefsWrite(repo, packed); err != nil {
+		return err
+	}
+
+	if prune {
+		for _, path := range loose {
+			if os.Remove(path) == nil {
+				removeEmptyRefDirs(repo, path)
+			}
+		}
+	}
+	return nil
+}
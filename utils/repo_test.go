@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRepo makes an empty repository in a temporary directory, with HEAD on
+// an unborn main.
+func testRepo(t *testing.T) Repo {
+	t.Helper()
+	dir := t.TempDir()
+	repo := Repo{
+		Worktree: dir,
+		Gitdir:   filepath.Join(dir, ".wannagit"),
+		Conf:     filepath.Join(dir, ".wannagit", "config"),
+	}
+	for _, sub := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(repo.Gitdir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repo.Gitdir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(repo.Conf, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// testCommit writes a commit of the empty tree with message on top of
+// parents, giving its sha.
+func testCommit(t *testing.T, repo Repo, message string, parents ...string) string {
+	t.Helper()
+	tree := ObjectWrite(&GitTree{}, repo)
+	ident := Ident{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1700000000, 0).UTC()}
+	sha, err := CommitCreate(repo, tree, parents, ident, ident, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
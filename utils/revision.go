@@ -0,0 +1,488 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// revision syntax, as described in gitrevisions(7):
+//
+//	<sha>, <refname>, @, <ref>@{<n>|<date>|-<n>|upstream}
+//	<rev>^<n>, <rev>~<n>, <rev>^{<type>}, <rev>^{}, <rev>^{/<regex>}
+//	<rev>:<path>, :<path>, :<n>:<path>, :/<regex>
+
+var shortShaRE = regexp.MustCompile(`^[0-9A-Fa-f]{4,40}$`)
+
+// DwimRefs lists the refs a short name could mean, in the order git tries
+// them: <name>, refs/<name>, refs/tags/<name>, refs/heads/<name>,
+// refs/remotes/<name> and refs/remotes/<name>/HEAD.
+func DwimRefs(name string) []string {
+	var candidates []string
+
+	// only the all caps files in the gitdir are refs, config or index are not
+	if strings.HasPrefix(name, "refs/") || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") == "" {
+		candidates = append(candidates, name)
+	}
+
+	return append(candidates,
+		"refs/"+name,
+		"refs/tags/"+name,
+		"refs/heads/"+name,
+		"refs/remotes/"+name,
+		"refs/remotes/"+name+"/HEAD",
+	)
+}
+
+// DwimRef finds the full name of the ref a short name like "main" or
+// "v1.0" stands for, and the sha it points at.
+func DwimRef(repo Repo, name string) (string, string) {
+	if name == "" || !CheckRefFormat(name) {
+		return "", ""
+	}
+
+	for _, ref := range DwimRefs(name) {
+		if sha := ResolveRef(repo, ref); sha != "" {
+			return ref, sha
+		}
+	}
+	return "", ""
+}
+
+// ObjectExists tells whether the object sha is in the object store.
+func ObjectExists(repo Repo, sha string) bool {
+	if len(sha) < 3 {
+		return false
+	}
+	stat, err := os.Stat(repoPath(repo, "objects", sha[:2], sha[2:]))
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// objectsByPrefix lists the objects whose name starts with prefix.
+func objectsByPrefix(repo Repo, prefix string) []string {
+	prefix = strings.ToLower(prefix)
+
+	entries, err := os.ReadDir(repoPath(repo, "objects", prefix[:2]))
+	if err != nil {
+		return nil
+	}
+
+	var shas []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix[2:]) {
+			shas = append(shas, prefix[:2]+entry.Name())
+		}
+	}
+	return shas
+}
+
+// revParseBase resolves a revision without any ^ or ~ suffixes.
+func revParseBase(repo Repo, name string) (string, error) {
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	// <ref>@{...} goes through the reflog or the upstream config
+	if ref, spec, ok := strings.Cut(name, "@{"); ok && strings.HasSuffix(spec, "}") {
+		spec = strings.TrimSuffix(spec, "}")
+		if ref == "@" {
+			ref = "HEAD"
+		}
+
+		switch {
+		case strings.HasPrefix(spec, "-") && ref == "":
+			n, err := strconv.Atoi(spec[1:])
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("invalid revision: %v", name)
+			}
+			branch, err := PreviousBranch(repo, n)
+			if err != nil {
+				return "", err
+			}
+			return revParseBase(repo, branch)
+
+		case spec == "upstream" || spec == "u" || spec == "push":
+			upstream, err := Upstream(repo, ref)
+			if err != nil {
+				return "", err
+			}
+			return ResolveRef(repo, upstream), nil
+		}
+
+		return ReflogResolve(repo, ref, spec)
+	}
+
+	if len(name) == 40 && shortShaRE.MatchString(name) {
+		return strings.ToLower(name), nil
+	}
+
+	if _, sha := DwimRef(repo, name); sha != "" {
+		return sha, nil
+	}
+
+	if shortShaRE.MatchString(name) {
+		shas := objectsByPrefix(repo, name)
+		switch len(shas) {
+		case 0:
+		case 1:
+			return shas[0], nil
+		default:
+			sort.Strings(shas)
+			return "", fmt.Errorf("short object ID %v is ambiguous, candidates are:\n  %v", name, strings.Join(shas, "\n  "))
+		}
+	}
+
+	return "", fmt.Errorf("unknown revision: %v", name)
+}
+
+// PreviousBranch gives the branch or commit checked out n switches ago, the
+// @{-n} syntax, read from the "checkout: moving from A to B" reflog entries.
+func PreviousBranch(repo Repo, n int) (string, error) {
+	entries, err := ReflogRead(repo, "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		rest, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if n--; n == 0 {
+			from, _, _ := strings.Cut(rest, " to ")
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("no previous branch for @{-%d}", n)
+}
+
+// Upstream gives the remote-tracking ref branch (the current one when "")
+// is set to track, from branch.<name>.remote and branch.<name>.merge.
+func Upstream(repo Repo, branch string) (string, error) {
+	if branch == "" || branch == "HEAD" {
+		branch = HeadBranch(repo)
+		if branch == "" {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+
+	section := fmt.Sprintf("branch \"%s\"", branch)
+	remote := ConfigGet(repo, section, "remote")
+	merge := ConfigGet(repo, section, "merge")
+	if remote == "" || merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%v'", branch)
+	}
+
+	if remote == "." {
+		return merge, nil
+	}
+	return "refs/remotes/" + remote + "/" + strings.TrimPrefix(merge, "refs/heads/"), nil
+}
+
+// PeelTo follows tags, and commits down to their tree, until it reaches an
+// object of type objectType. "" peels tags only, like <rev>^{}.
+func PeelTo(repo Repo, sha string, objectType string) (string, error) {
+	for {
+		obj := ObjectRead(repo, sha)
+		if obj == nil {
+			return "", fmt.Errorf("bad object %v", sha)
+		}
+
+		switch {
+		case obj.Format() == objectType:
+			return sha, nil
+		case obj.Format() == "tag":
+			sha = obj.(*GitTag).GetData().Get("object")
+		case objectType == "":
+			return sha, nil
+		case obj.Format() == "commit" && objectType == "tree":
+			sha = obj.(*GitCommit).GetData().Get("tree")
+		default:
+			return "", fmt.Errorf("%v is a %v, not a %v", sha, obj.Format(), objectType)
+		}
+	}
+}
+
+func readCommit(repo Repo, sha string) (*GitCommit, error) {
+	sha, err := PeelTo(repo, sha, "commit")
+	if err != nil {
+		return nil, err
+	}
+	return ObjectRead(repo, sha).(*GitCommit), nil
+}
+
+// CommitParents lists the parents of a commit, in order.
+func CommitParents(repo Repo, sha string) []string {
+	commit, err := readCommit(repo, sha)
+	if err != nil {
+		return nil
+	}
+	return commit.GetData().GetAll("parent")
+}
+
+// CommitTime is the committer timestamp of a commit, as unix seconds.
+func CommitTime(commit *GitCommit) int64 {
+	fields := strings.Fields(commit.GetData().Get("committer"))
+	if len(fields) < 2 {
+		return 0
+	}
+	epoch, _ := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	return epoch
+}
+
+// revSearch finds the youngest commit reachable from starts whose message
+// matches pattern, for :/<regex> and <rev>^{/<regex>}.
+func revSearch(repo Repo, starts []string, pattern string) (string, error) {
+	negate := false
+	if rest, ok := strings.CutPrefix(pattern, "!-"); ok {
+		pattern, negate = rest, true
+	} else {
+		pattern = strings.TrimPrefix(pattern, "!!")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	type found struct {
+		sha  string
+		time int64
+	}
+	var commits []found
+	seen := make(map[string]bool)
+	queue := append([]string{}, starts...)
+
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+
+		commit, err := readCommit(repo, sha)
+		if err != nil {
+			continue
+		}
+		commits = append(commits, found{sha, CommitTime(commit)})
+		queue = append(queue, commit.GetData().GetAll("parent")...)
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].time > commits[j].time
+	})
+
+	for _, c := range commits {
+		commit, _ := readCommit(repo, c.sha)
+		if re.MatchString(commit.GetData().Message) != negate {
+			return c.sha, nil
+		}
+	}
+	return "", fmt.Errorf("no commit message matches %v", pattern)
+}
+
+// treeLookup finds path inside the tree sha.
+func treeLookup(repo Repo, sha string, name string) (string, error) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return sha, nil
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		tree, ok := ObjectRead(repo, sha).(*GitTree)
+		if !ok {
+			return "", fmt.Errorf("path '%v' does not exist", name)
+		}
+
+		sha = ""
+		for _, leaf := range tree.Items {
+			if leaf.Path == part {
+				sha = leaf.Sha
+				break
+			}
+		}
+		if sha == "" {
+			return "", fmt.Errorf("path '%v' does not exist", name)
+		}
+	}
+	return sha, nil
+}
+
+// revSplitPath finds the ":" separating <rev> from <path>, skipping the
+// ones inside @{...} and ^{...}.
+func revSplitPath(spec string) (string, string, bool) {
+	depth := 0
+	for i, c := range spec {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				return spec[:i], spec[i+1:], true
+			}
+		}
+	}
+	return spec, "", false
+}
+
+// RevParse resolves a revision in any of the forms of gitrevisions(7) to
+// the sha of the object it names.
+func RevParse(repo Repo, spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return "", fmt.Errorf("empty revision")
+	}
+
+	// :/<regex> searches every commit reachable from a ref
+	if pattern, ok := strings.CutPrefix(spec, ":/"); ok {
+		var starts []string
+		if head := ResolveRef(repo, "HEAD"); head != "" {
+			starts = append(starts, head)
+		}
+		refs := ListRefs(repo, "refs/")
+		for _, name := range SortedRefNames(refs) {
+			starts = append(starts, refs[name])
+		}
+		return revSearch(repo, starts, pattern)
+	}
+
+	// :<path> and :<n>:<path> name a blob in the index
+	if rest, ok := strings.CutPrefix(spec, ":"); ok {
+		stage := 0
+		if len(rest) > 2 && rest[1] == ':' && rest[0] >= '0' && rest[0] <= '3' {
+			stage = int(rest[0] - '0')
+			rest = rest[2:]
+		}
+		return revIndexLookup(repo, rest, stage)
+	}
+
+	if rev, name, ok := revSplitPath(spec); ok {
+		tree, err := RevParse(repo, rev)
+		if err != nil {
+			return "", err
+		}
+		if tree, err = PeelTo(repo, tree, "tree"); err != nil {
+			return "", err
+		}
+		return treeLookup(repo, tree, name)
+	}
+
+	// the base ends at the first ^ or ~ outside of @{...}
+	end := len(spec)
+	depth := 0
+	for i, c := range spec {
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+		} else if depth == 0 && (c == '^' || c == '~') {
+			end = i
+			break
+		}
+	}
+
+	sha, err := revParseBase(repo, spec[:end])
+	if err != nil {
+		return "", err
+	}
+	return revApplySuffixes(repo, sha, spec[end:])
+}
+
+// revApplySuffixes walks a chain like ~2^2^{tree} starting at sha.
+func revApplySuffixes(repo Repo, sha string, suffix string) (string, error) {
+	var err error
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+
+		if op == '^' && strings.HasPrefix(suffix, "{") {
+			closing := strings.Index(suffix, "}")
+			if closing == -1 {
+				return "", fmt.Errorf("unterminated ^{ in revision")
+			}
+			inner := suffix[1:closing]
+			suffix = suffix[closing+1:]
+
+			switch {
+			case strings.HasPrefix(inner, "/"):
+				sha, err = revSearch(repo, []string{sha}, inner[1:])
+			case inner == "" || inner == "commit" || inner == "tree" || inner == "blob" || inner == "tag":
+				sha, err = PeelTo(repo, sha, inner)
+			case inner == "object":
+				if !ObjectExists(repo, sha) {
+					err = fmt.Errorf("bad object %v", sha)
+				}
+			default:
+				err = fmt.Errorf("unknown object type %v", inner)
+			}
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		digits := 0
+		for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+
+		commit, err := PeelTo(repo, sha, "commit")
+		if err != nil {
+			return "", err
+		}
+
+		if op == '^' {
+			if n == 0 {
+				sha = commit
+				continue
+			}
+			parents := CommitParents(repo, commit)
+			if n > len(parents) {
+				return "", fmt.Errorf("%v has no parent %d", commit, n)
+			}
+			sha = parents[n-1]
+			continue
+		}
+
+		// ~n follows first parents n times
+		sha = commit
+		for i := 0; i < n; i++ {
+			parents := CommitParents(repo, sha)
+			if len(parents) == 0 {
+				return "", fmt.Errorf("%v has only %d ancestors", commit, i)
+			}
+			sha = parents[0]
+		}
+	}
+
+	return sha, nil
+}
+
+func revIndexLookup(repo Repo, name string, stage int) (string, error) {
+	index, err := IndexRead(repo)
+	if err != nil {
+		return "", err
+	}
+
+	name = strings.Trim(path.Clean("/"+name), "/")
+	for _, entry := range index.Entries {
+		if entry.Name == name && int(entry.Stage) == stage {
+			return entry.SHA, nil
+		}
+	}
+	return "", fmt.Errorf("path '%v' is not in the index at stage %d", name, stage)
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevParse(t *testing.T) {
+	repo := testRepo(t)
+
+	blob := GitBlob{}
+	blob.Deserialize("hello\n")
+	file := ObjectWrite(&blob, repo)
+	dir, err := MakeTree(repo, []GitTreeLeaf{{Mode: "100644", Path: "file.txt", Sha: file}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := MakeTree(repo, []GitTreeLeaf{{Mode: "40000", Path: "dir", Sha: dir}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ident := Ident{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1700000000, 0).UTC()}
+	commit := func(message string, parents ...string) string {
+		sha, err := CommitCreate(repo, root, parents, ident, ident, message, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	first := commit("first\n")
+	second := commit("second\n", first)
+	side := commit("side work\n", first)
+	merge := commit("merge side\n", second, side)
+
+	tag, err := MakeTag(repo, "object "+first+"\ntype commit\ntag v1.0\ntagger "+ident.String()+"\n\nrelease\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, sha := range map[string]string{"refs/heads/main": merge, "refs/heads/side": side, "refs/tags/v1.0": tag} {
+		if err := WriteRef(repo, ref, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"main":              merge,
+		"refs/heads/side":   side,
+		"heads/side":        side,
+		"HEAD":              merge,
+		"@":                 merge,
+		merge[:7]:           merge,
+		"v1.0":              tag,
+		"v1.0^{}":           first,
+		"v1.0^{commit}":     first,
+		"main^":             second,
+		"main^2":            side,
+		"main^^":            first,
+		"main~2":            first,
+		"main^2~1":          first,
+		"main^{tree}":       root,
+		"main:dir":          dir,
+		"main:dir/file.txt": file,
+		"v1.0:dir/file.txt": file,
+		":/side work":       side,
+		"main^{/second}":    second,
+	}
+	for spec, want := range tests {
+		if got, err := RevParse(repo, spec); err != nil || got != want {
+			t.Errorf("RevParse(%q) = %v, %v; want %v", spec, got, err, want)
+		}
+	}
+
+	for _, spec := range []string{"nothing", "main~5", "main^3", "main:missing", "v1.0^{tree}^{commit}", ""} {
+		if got, err := RevParse(repo, spec); err == nil {
+			t.Errorf("RevParse(%q) = %v, want an error", spec, got)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package utils
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// commit graph ----------------------------------------
+
+// graphCommit is the part of a commit the history walks need.
+type graphCommit struct {
+	sha     string
+	parents []string
+	time    int64
+}
+
+// commitGraph reads commits lazily and keeps them around, walks visit the
+// same commits over and over.
+type commitGraph struct {
+	repo    Repo
+	commits map[string]*graphCommit
+}
+
+func newCommitGraph(repo Repo) *commitGraph {
+	return &commitGraph{repo: repo, commits: make(map[string]*graphCommit)}
+}
+
+func (g *commitGraph) get(sha string) (*graphCommit, error) {
+	if c, ok := g.commits[sha]; ok {
+		return c, nil
+	}
+
+	commit, ok := ObjectRead(g.repo, sha).(*GitCommit)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a commit", sha)
+	}
+
+	c := &graphCommit{
+		sha:     sha,
+		parents: commit.GetData().GetAll("parent"),
+		time:    CommitTime(commit),
+	}
+	g.commits[sha] = c
+	return c, nil
+}
+
+// commitQueue is a priority queue handing out the newest commit first, ties
+// going to the one pushed first.
+type commitQueue struct {
+	items []*graphCommit
+	order map[*graphCommit]int
+	next  int
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{order: make(map[*graphCommit]int)}
+}
+
+func (q *commitQueue) Len() int { return len(q.items) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.time != b.time {
+		return a.time > b.time
+	}
+	return q.order[a] < q.order[b]
+}
+
+func (q *commitQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *commitQueue) Push(x any) { q.items = append(q.items, x.(*graphCommit)) }
+
+func (q *commitQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+func (q *commitQueue) push(c *graphCommit) {
+	q.order[c] = q.next
+	q.next++
+	heap.Push(q, c)
+}
+
+func (q *commitQueue) pop() *graphCommit {
+	return heap.Pop(q).(*graphCommit)
+}
+
+// rev walk --------------------------------------------
+
+type RevOrder int
+
+const (
+	RevOrderDefault RevOrder = iota // newest first, as the commits are found
+	RevOrderDate                    // newest first, but never a parent before its children
+	RevOrderTopo                    // children first, keeping lines of history together
+)
+
+// RevWalk lists the commits reachable from the pushed commits but not from
+// the hidden ones, like rev-list.
+type RevWalk struct {
+	FirstParent bool
+	Order       RevOrder
+	MaxCount    int // negative for no limit
+	Skip        int
+	Reverse     bool
+
+	graph   *commitGraph
+	include []string
+	exclude []string
+}
+
+func NewRevWalk(repo Repo) *RevWalk {
+	return &RevWalk{MaxCount: -1, graph: newCommitGraph(repo)}
+}
+
+// Push starts the walk at commit.
+func (w *RevWalk) Push(sha string) {
+	w.include = append(w.include, sha)
+}
+
+// Hide leaves out commit and everything reachable from it.
+func (w *RevWalk) Hide(sha string) {
+	w.exclude = append(w.exclude, sha)
+}
+
+// how many extra commits to look at once only uninteresting ones are left,
+// in case a commit has a timestamp older than one of its parents
+const revWalkSlop = 5
+
+// limit walks back from the tips in date order, returning the interesting
+// commits newest first.
+func (w *RevWalk) limit() ([]*graphCommit, error) {
+	uninteresting := make(map[string]bool)
+	seen := make(map[string]bool)
+	queue := newCommitQueue()
+
+	add := func(sha string, hide bool) error {
+		c, err := w.graph.get(sha)
+		if err != nil {
+			return err
+		}
+		if hide {
+			uninteresting[sha] = true
+		}
+		if !seen[sha] {
+			seen[sha] = true
+			queue.push(c)
+		}
+		return nil
+	}
+
+	for _, sha := range w.exclude {
+		if err := add(sha, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, sha := range w.include {
+		if err := add(sha, false); err != nil {
+			return nil, err
+		}
+	}
+
+	// markParents spreads uninteresting to the ancestors already queued or
+	// walked, so they get dropped even when reached first from an included tip
+	markParents := func(c *graphCommit) {
+		stack := []*graphCommit{c}
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			for _, p := range c.parents {
+				if uninteresting[p] {
+					continue
+				}
+				uninteresting[p] = true
+				if pc, ok := w.graph.commits[p]; ok && seen[p] {
+					stack = append(stack, pc)
+				}
+			}
+		}
+	}
+
+	everybodyUninteresting := func() bool {
+		for _, c := range queue.items {
+			if !uninteresting[c.sha] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var list []*graphCommit
+	slop := revWalkSlop
+
+	for queue.Len() > 0 {
+		c := queue.pop()
+
+		if uninteresting[c.sha] {
+			markParents(c)
+		} else {
+			list = append(list, c)
+		}
+
+		parents := c.parents
+		if w.FirstParent && !uninteresting[c.sha] && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, p := range parents {
+			if err := add(p, uninteresting[c.sha]); err != nil {
+				return nil, err
+			}
+		}
+
+		if everybodyUninteresting() {
+			if slop--; slop <= 0 {
+				break
+			}
+		} else {
+			slop = revWalkSlop
+		}
+	}
+
+	var result []*graphCommit
+	for _, c := range list {
+		if !uninteresting[c.sha] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// sortInGraphOrder reorders the commits so none comes before its children,
+// picking the newest available commit (date order) or staying on the
+// current line of history (topo order) when there's a choice.
+func (w *RevWalk) sortInGraphOrder(list []*graphCommit) []*graphCommit {
+	indegree := make(map[string]int, len(list))
+	for _, c := range list {
+		indegree[c.sha] = 1
+	}
+	for _, c := range list {
+		for _, p := range w.parentsOf(c) {
+			if indegree[p] > 0 {
+				indegree[p]++
+			}
+		}
+	}
+
+	queue := newCommitQueue()
+	var stack []*graphCommit
+	ready := func(c *graphCommit) {
+		if w.Order == RevOrderDate {
+			queue.push(c)
+		} else {
+			stack = append(stack, c)
+		}
+	}
+
+	// the tips, newest first; the stack is reversed so the newest is on top
+	var tips []*graphCommit
+	for _, c := range list {
+		if indegree[c.sha] == 1 {
+			tips = append(tips, c)
+		}
+	}
+	for i := len(tips) - 1; i >= 0; i-- {
+		ready(tips[i])
+	}
+
+	var sorted []*graphCommit
+	for queue.Len() > 0 || len(stack) > 0 {
+		var c *graphCommit
+		if w.Order == RevOrderDate {
+			c = queue.pop()
+		} else {
+			c = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+		sorted = append(sorted, c)
+
+		// like git, in topo order the last parent freed is the next one shown
+		for _, p := range w.parentsOf(c) {
+			if indegree[p] == 0 {
+				continue
+			}
+			if indegree[p]--; indegree[p] == 1 {
+				ready(w.graph.commits[p])
+			}
+		}
+	}
+	return sorted
+}
+
+func (w *RevWalk) parentsOf(c *graphCommit) []string {
+	if w.FirstParent && len(c.parents) > 1 {
+		return c.parents[:1]
+	}
+	return c.parents
+}
+
+// Walk returns the selected commits in the requested order.
+func (w *RevWalk) Walk() ([]string, error) {
+	list, err := w.limit()
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Order != RevOrderDefault {
+		list = w.sortInGraphOrder(list)
+	}
+
+	if w.Skip > 0 {
+		if w.Skip >= len(list) {
+			list = nil
+		} else {
+			list = list[w.Skip:]
+		}
+	}
+	if w.MaxCount >= 0 && w.MaxCount < len(list) {
+		list = list[:w.MaxCount]
+	}
+
+	shas := make([]string, len(list))
+	for i, c := range list {
+		shas[i] = c.sha
+	}
+
+	if w.Reverse {
+		for i, j := 0, len(shas)-1; i < j; i, j = i+1, j-1 {
+			shas[i], shas[j] = shas[j], shas[i]
+		}
+	}
+	return shas, nil
+}
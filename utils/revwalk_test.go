@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// revWalkCommit is testCommit with the commit dated when.
+func revWalkCommit(t *testing.T, repo Repo, when int64, message string, parents ...string) string {
+	t.Helper()
+	tree := ObjectWrite(&GitTree{}, repo)
+	ident := Ident{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+	sha, err := CommitCreate(repo, tree, parents, ident, ident, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestRevWalk(t *testing.T) {
+	repo := testRepo(t)
+
+	// a - b - c ----- m
+	//  \             /
+	//   x ------- y
+	a := revWalkCommit(t, repo, 1000, "a\n")
+	b := revWalkCommit(t, repo, 2000, "b\n", a)
+	x := revWalkCommit(t, repo, 3000, "x\n", a)
+	c := revWalkCommit(t, repo, 4000, "c\n", b)
+	y := revWalkCommit(t, repo, 5000, "y\n", x)
+	m := revWalkCommit(t, repo, 6000, "m\n", c, y)
+
+	// d is on top of b but dated before everything else
+	d := revWalkCommit(t, repo, 500, "d\n", b)
+
+	names := map[string]string{a: "a", b: "b", c: "c", x: "x", y: "y", m: "m", d: "d"}
+
+	tests := []struct {
+		name  string
+		setup func(w *RevWalk)
+		want  []string
+	}{
+		{"default order", func(w *RevWalk) { w.Push(m) }, []string{"m", "y", "c", "x", "b", "a"}},
+		{"date order", func(w *RevWalk) { w.Push(m); w.Order = RevOrderDate }, []string{"m", "y", "c", "x", "b", "a"}},
+		{"topo order", func(w *RevWalk) { w.Push(m); w.Order = RevOrderTopo }, []string{"m", "y", "x", "c", "b", "a"}},
+		{"first parent", func(w *RevWalk) { w.Push(m); w.FirstParent = true }, []string{"m", "c", "b", "a"}},
+		{"skip and max count", func(w *RevWalk) { w.Push(m); w.Skip = 1; w.MaxCount = 2 }, []string{"y", "c"}},
+		{"skip everything", func(w *RevWalk) { w.Push(m); w.Skip = 10 }, []string{}},
+		{"reverse", func(w *RevWalk) { w.Push(m); w.FirstParent = true; w.Reverse = true }, []string{"a", "b", "c", "m"}},
+		{"hide a branch", func(w *RevWalk) { w.Push(m); w.Hide(c) }, []string{"m", "y", "x"}},
+		{"hide an older commit with a skewed clock", func(w *RevWalk) { w.Push(c); w.Hide(d) }, []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		w := NewRevWalk(repo)
+		tt.setup(w)
+		shas, err := w.Walk()
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		got := []string{}
+		for _, sha := range shas {
+			got = append(got, names[sha])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
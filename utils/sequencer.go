@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// SequencerItem is one line of sequencer/todo: pick or revert a commit.
+type SequencerItem struct {
+	Command string // "pick" or "revert"
+	Sha     string
+	Subject string
+}
+
+// SequencerState is a cherry-pick or revert of several commits that
+// stopped, kept in .wannagit/sequencer the way git lays it out.
+type SequencerState struct {
+	Head         string // HEAD before the run, where --abort goes back to
+	Todo         []SequencerItem
+	RecordOrigin bool // -x
+	Mainline     int  // -m
+	NoCommit     bool // -n
+}
+
+// SequencerInProgress tells whether a cherry-pick or revert stopped.
+func SequencerInProgress(repo Repo) bool {
+	_, err := os.Stat(repoPath(repo, "sequencer"))
+	return err == nil
+}
+
+// SequencerRead reads the state of the cherry-pick or revert that stopped.
+func SequencerRead(repo Repo) (*SequencerState, error) {
+	state := &SequencerState{}
+
+	head, err := os.ReadFile(repoPath(repo, "sequencer", "head"))
+	if err != nil {
+		return nil, err
+	}
+	state.Head = strings.TrimSpace(string(head))
+
+	todo, err := os.ReadFile(repoPath(repo, "sequencer", "todo"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(todo), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line in sequencer/todo: %v", line)
+		}
+
+		item := SequencerItem{Command: fields[0], Sha: fields[1]}
+		switch item.Command {
+		case "p":
+			item.Command = "pick"
+		case "r":
+			item.Command = "revert"
+		case "pick", "revert":
+		default:
+			return nil, fmt.Errorf("invalid line in sequencer/todo: %v", line)
+		}
+		if len(fields) == 3 {
+			item.Subject = fields[2]
+		}
+		state.Todo = append(state.Todo, item)
+	}
+
+	opts, err := ini.LooseLoad(repoPath(repo, "sequencer", "opts"))
+	if err != nil {
+		return nil, err
+	}
+	sec := opts.Section("options")
+	state.RecordOrigin = sec.Key("record-origin").MustBool(false)
+	state.NoCommit = sec.Key("no-commit").MustBool(false)
+	state.Mainline = sec.Key("mainline").MustInt(0)
+
+	return state, nil
+}
+
+// SequencerWrite saves the state, so that --continue, --skip and --abort
+// can pick it up.
+func SequencerWrite(repo Repo, state *SequencerState) error {
+	if err := os.MkdirAll(repoPath(repo, "sequencer"), 0755); err != nil {
+		return err
+	}
+
+	var todo bytes.Buffer
+	for _, item := range state.Todo {
+		fmt.Fprintf(&todo, "%s %s %s\n", item.Command, item.Sha, item.Subject)
+	}
+
+	var opts bytes.Buffer
+	opts.WriteString("[options]\n")
+	if state.RecordOrigin {
+		opts.WriteString("\trecord-origin = true\n")
+	}
+	if state.NoCommit {
+		opts.WriteString("\tno-commit = true\n")
+	}
+	if state.Mainline > 0 {
+		opts.WriteString("\tmainline = " + strconv.Itoa(state.Mainline) + "\n")
+	}
+
+	files := map[string]string{"head": state.Head + "\n", "todo": todo.String(), "opts": opts.String()}
+	for name, content := range files {
+		if err := os.WriteFile(repoPath(repo, "sequencer", name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SequencerClear forgets the cherry-pick or revert that stopped.
+func SequencerClear(repo Repo) {
+	os.RemoveAll(repoPath(repo, "sequencer"))
+}
+
+// PickHead gives the commit a stopped cherry-pick or revert was applying,
+// from CHERRY_PICK_HEAD or REVERT_HEAD, and which of the two it is. both
+// are "" when neither stopped.
+func PickHead(repo Repo) (sha string, action string) {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		data, err := os.ReadFile(repoPath(repo, name))
+		if err != nil {
+			continue
+		}
+		action = "cherry-pick"
+		if name == "REVERT_HEAD" {
+			action = "revert"
+		}
+		return strings.TrimSpace(string(data)), action
+	}
+	return "", ""
+}
+
+// PickHeadWrite records the commit a cherry-pick or revert stopped at.
+func PickHeadWrite(repo Repo, sha string, action string) error {
+	name := "CHERRY_PICK_HEAD"
+	if action == "revert" {
+		name = "REVERT_HEAD"
+	}
+	return os.WriteFile(repoPath(repo, name), []byte(sha+"\n"), 0644)
+}
+
+// SequencerCommitted drops sha from the front of the todo once the commit
+// it stopped at is made, forgetting the run when nothing is left.
+func SequencerCommitted(repo Repo, sha string) error {
+	if !SequencerInProgress(repo) {
+		return nil
+	}
+	state, err := SequencerRead(repo)
+	if err != nil {
+		return err
+	}
+	if len(state.Todo) > 0 && strings.HasPrefix(sha, state.Todo[0].Sha) {
+		state.Todo = state.Todo[1:]
+	}
+	if len(state.Todo) == 0 {
+		SequencerClear(repo)
+		return nil
+	}
+	return SequencerWrite(repo, state)
+}
@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the namespace git signs and verifies commits and tags in
+const sigNamespace = "git"
+
+// sigBegins are the lines a signature in a tag message starts with.
+var sigBegins = []string{sshsigBegin, "-----BEGIN PGP SIGNATURE-----", "-----BEGIN SIGNED MESSAGE-----"}
+
+// ExpandHome expands a leading ~/ to the home directory, as the paths in
+// the config may start with.
+func ExpandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
+
+// SigningKey loads the private key to sign with: keyID when given,
+// user.signingkey otherwise. pointing at the .pub file works too, the
+// private key is looked for next to it.
+func SigningKey(repo Repo, keyID string) (*SSHSigner, error) {
+	if keyID == "" {
+		keyID = ConfigGet(repo, "user", "signingkey")
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("user.signingkey needs to be set for ssh signing")
+	}
+	if strings.HasPrefix(keyID, "key::") || strings.HasPrefix(keyID, "ssh-") {
+		return nil, fmt.Errorf("a literal public key needs ssh-agent, which isn't supported: set user.signingkey to the private key file")
+	}
+
+	keyPath := ExpandHome(keyID)
+	keyPath = strings.TrimSuffix(keyPath, ".pub")
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the signing key %v: %v", keyPath, err)
+	}
+
+	signer, err := ParseSSHPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the signing key %v: %v", keyPath, err)
+	}
+	return signer, nil
+}
+
+// SignCommit adds a gpgsig header signing the commit as it stands.
+func SignCommit(commit *GitCommit, signer *SSHSigner) error {
+	commit.Data.Del("gpgsig")
+
+	sig, err := SSHSign(signer, []byte(commit.Serialize()), sigNamespace)
+	if err != nil {
+		return err
+	}
+	commit.Data.Add("gpgsig", strings.TrimSuffix(sig, "\n"))
+	return nil
+}
+
+// SignTag appends the signature of the tag as it stands to its message.
+func SignTag(tag *GitTag, signer *SSHSigner) error {
+	sig, err := SSHSign(signer, []byte(tag.Serialize()), sigNamespace)
+	if err != nil {
+		return err
+	}
+	tag.Data.Message += sig
+	return nil
+}
+
+// CommitSignature splits a commit into what was signed and the signature.
+func CommitSignature(commit *GitCommit) (payload []byte, sig string, ok bool) {
+	if !commit.Data.Has("gpgsig") {
+		return nil, "", false
+	}
+
+	unsigned := GitCommit{Data: KVLM{Message: commit.Data.Message, HeadersOnly: commit.Data.HeadersOnly}}
+	unsigned.Data.Fields = append(unsigned.Data.Fields, commit.Data.Fields...)
+	unsigned.Data.Del("gpgsig")
+	unsigned.Data.Del("gpgsig-sha256")
+
+	return []byte(unsigned.Serialize()), commit.Data.Get("gpgsig") + "\n", true
+}
+
+// SplitTagMessage cuts the signature off the end of a tag message.
+func SplitTagMessage(message string) (string, string) {
+	start := -1
+	for _, begin := range sigBegins {
+		if i := strings.LastIndex(message, begin); i > start && (i == 0 || message[i-1] == '\n') {
+			start = i
+		}
+	}
+	if start == -1 {
+		return message, ""
+	}
+	return message[:start], message[start:]
+}
+
+// TagSignature splits a tag into what was signed and the signature.
+func TagSignature(tag *GitTag) (payload []byte, sig string, ok bool) {
+	message, sig := SplitTagMessage(tag.Data.Message)
+	if sig == "" {
+		return nil, "", false
+	}
+
+	unsigned := GitTag{}
+	unsigned.Data.Fields = append(unsigned.Data.Fields, tag.Data.Fields...)
+	unsigned.Data.Message = message
+
+	return []byte(unsigned.Serialize()), sig, true
+}
+
+// Signature is the outcome of checking a signature.
+type Signature struct {
+	Key       *SSHPublicKey
+	Principal string // who the allowed signers file says the key is, "" if nobody
+	Err       error  // why the signature doesn't hold
+}
+
+// Good tells whether the signature holds and comes from an allowed signer.
+func (s Signature) Good() bool {
+	return s.Err == nil && s.Principal != ""
+}
+
+// String words the outcome like ssh-keygen -Y verify does.
+func (s Signature) String() string {
+	switch {
+	case s.Err != nil && s.Key == nil:
+		return fmt.Sprintf("%v\nCould not verify signature.", s.Err)
+	case s.Err != nil:
+		return fmt.Sprintf("Signature verification failed: %v\nCould not verify signature.", s.Err)
+	case s.Principal == "":
+		return fmt.Sprintf("Good \"%s\" signature with %s key %s\nNo principal matched.", sigNamespace, s.Key.Kind(), s.Key.Fingerprint())
+	}
+	return fmt.Sprintf("Good \"%s\" signature for %s with %s key %s", sigNamespace, s.Principal, s.Key.Kind(), s.Key.Fingerprint())
+}
+
+// VerifySignature checks sig over payload, and that the allowed signers
+// file (gpg.ssh.allowedSignersFile) lets its key sign at the time when.
+func VerifySignature(repo Repo, payload []byte, sig string, when time.Time) Signature {
+	if !strings.HasPrefix(strings.TrimSpace(sig), sshsigBegin) {
+		return Signature{Err: fmt.Errorf("only ssh signatures can be verified")}
+	}
+
+	key, err := SSHVerify(sig, payload, sigNamespace)
+	if err != nil {
+		return Signature{Key: key, Err: err}
+	}
+
+	signersFile := ConfigGet(repo, `gpg "ssh"`, "allowedSignersFile")
+	if signersFile == "" {
+		return Signature{Key: key, Err: fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")}
+	}
+
+	signers, err := readAllowedSigners(ExpandHome(signersFile))
+	if err != nil {
+		return Signature{Key: key, Err: err}
+	}
+
+	for _, signer := range signers {
+		if signer.allows(key, when) {
+			return Signature{Key: key, Principal: strings.Join(signer.principals, ",")}
+		}
+	}
+	return Signature{Key: key}
+}
+
+// VerifyCommit checks the signature of a commit, ok is false for unsigned
+// ones.
+func VerifyCommit(repo Repo, commit *GitCommit) (Signature, bool) {
+	payload, sig, ok := CommitSignature(commit)
+	if !ok {
+		return Signature{}, false
+	}
+	when, _ := ParsePersonDate(personDate(commit.Data.Get("committer")))
+	return VerifySignature(repo, payload, sig, when), true
+}
+
+// VerifyTag checks the signature of a tag, ok is false for unsigned ones.
+func VerifyTag(repo Repo, tag *GitTag) (Signature, bool) {
+	payload, sig, ok := TagSignature(tag)
+	if !ok {
+		return Signature{}, false
+	}
+	when, _ := ParsePersonDate(personDate(tag.Data.Get("tagger")))
+	return VerifySignature(repo, payload, sig, when), true
+}
+
+func personDate(person string) string {
+	_, _, when := splitPerson(person)
+	return when
+}
+
+// allowed signers -----------------------------------
+
+// one line of an allowed signers file (ssh-keygen(1), ALLOWED SIGNERS):
+// principals [options] keytype base64-key [comment]
+type allowedSigner struct {
+	principals    []string
+	namespaces    []string
+	validAfter    time.Time
+	validBefore   time.Time
+	certAuthority bool
+	key           *SSHPublicKey
+}
+
+func (s allowedSigner) allows(key *SSHPublicKey, when time.Time) bool {
+	// certificates aren't supported, so neither are their authorities
+	if s.certAuthority || !s.key.Equal(key) {
+		return false
+	}
+
+	if len(s.namespaces) > 0 {
+		matched := false
+		for _, pattern := range s.namespaces {
+			if ok, _ := path.Match(pattern, sigNamespace); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if !when.IsZero() {
+		if !s.validAfter.IsZero() && when.Before(s.validAfter) {
+			return false
+		}
+		if !s.validBefore.IsZero() && when.After(s.validBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSignerLine cuts a line at any of the characters in seps outside of
+// double quotes.
+func splitSignerLine(line string, seps string) []string {
+	var fields []string
+	var field strings.Builder
+	quoted := false
+
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			field.WriteRune(c)
+		case strings.ContainsRune(seps, c) && !quoted:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// parseSignerTime reads YYYYMMDD[HHMM[SS]], in UTC with a Z suffix.
+func parseSignerTime(value string) (time.Time, error) {
+	loc := time.Local
+	if v, ok := strings.CutSuffix(value, "Z"); ok {
+		value, loc = v, time.UTC
+	}
+
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("bad time %v", value)
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+func parseAllowedSigner(line string) (allowedSigner, error) {
+	fields := splitSignerLine(line, " \t")
+	if len(fields) < 3 {
+		return allowedSigner{}, fmt.Errorf("missing key")
+	}
+
+	signer := allowedSigner{principals: strings.Split(fields[0], ",")}
+	rest := fields[1:]
+
+	// options come before the key, which starts with its type
+	if !strings.HasPrefix(rest[0], "ssh-") && !strings.HasPrefix(rest[0], "ecdsa-") && !strings.HasPrefix(rest[0], "sk-") {
+		for _, option := range splitSignerLine(rest[0], ",") {
+			name, value, _ := strings.Cut(option, "=")
+			value = strings.Trim(value, "\"")
+
+			var err error
+			switch strings.ToLower(name) {
+			case "cert-authority":
+				signer.certAuthority = true
+			case "namespaces":
+				signer.namespaces = strings.Split(value, ",")
+			case "valid-after":
+				signer.validAfter, err = parseSignerTime(value)
+			case "valid-before":
+				signer.validBefore, err = parseSignerTime(value)
+			default:
+				err = fmt.Errorf("unknown option %v", name)
+			}
+			if err != nil {
+				return allowedSigner{}, err
+			}
+		}
+		rest = rest[1:]
+	}
+
+	key, err := ParseAuthorizedKey(strings.Join(rest, " "))
+	if err != nil {
+		return allowedSigner{}, err
+	}
+	signer.key = key
+	return signer, nil
+}
+
+func readAllowedSigners(signersPath string) ([]allowedSigner, error) {
+	file, err := os.Open(signersPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the allowed signers file: %v", err)
+	}
+	defer file.Close()
+
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", signersPath, n, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, scanner.Err()
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	repo := testRepo(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newSSHSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := signer.Public.Type + " " + base64.StdEncoding.EncodeToString(signer.Public.Blob)
+
+	payload := []byte("tree " + EmptyTreeSha + "\n\nsigned\n")
+	sig, err := SSHSign(signer, payload, sigNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signersFile := filepath.Join(t.TempDir(), "allowed_signers")
+	if err := ConfigSet(repo, `gpg "ssh"`, "allowedSignersFile", signersFile); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		signers string
+		good    bool
+	}{
+		{"plain key", "a@example.com " + public, true},
+		{"several principals", "b@example.com,a@example.com " + public, true},
+		{"quoted namespaces with a comma", `a@example.com namespaces="file,git" ` + public, true},
+		{"quoted namespaces then more options", `a@example.com namespaces="file,git",valid-after="20240101" ` + public, true},
+		{"namespace not allowed", `a@example.com namespaces="file,ssh" ` + public, false},
+		{"not valid yet", `a@example.com valid-after="20250101" ` + public, false},
+		{"expired", `a@example.com valid-before="20240101Z" ` + public, false},
+	}
+
+	for _, tt := range tests {
+		if err := os.WriteFile(signersFile, []byte(tt.signers+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got := VerifySignature(repo, payload, sig, when)
+		if got.Good() != tt.good {
+			t.Errorf("%v: Good() = %v, want %v (%v)", tt.name, got.Good(), tt.good, got)
+		}
+	}
+
+	if got := VerifySignature(repo, []byte("tampered\n"), sig, when); got.Err == nil {
+		t.Errorf("a signature over a different payload verified")
+	}
+}
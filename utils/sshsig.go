@@ -0,0 +1,545 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// the sshsig format of OpenSSH (PROTOCOL.sshsig), what ssh-keygen -Y sign
+// writes and git stores for gpg.format=ssh:
+//
+//	"SSHSIG" uint32(1) string(public key) string(namespace) string("")
+//	string(hash algorithm) string(signature)
+//
+// the signature covers "SSHSIG" string(namespace) string("")
+// string(hash algorithm) string(H(message)).
+
+const (
+	sshsigMagic   = "SSHSIG"
+	sshsigVersion = 1
+	sshsigBegin   = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd     = "-----END SSH SIGNATURE-----"
+)
+
+// ssh wire format -----------------------------------
+
+type sshReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = fmt.Errorf("truncated ssh data")
+		return 0
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return n
+}
+
+func (r *sshReader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.data)) < n {
+		r.err = fmt.Errorf("truncated ssh data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *sshReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *sshReader) mpint() *big.Int {
+	return new(big.Int).SetBytes(r.bytes())
+}
+
+func sshString(b []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(b)))
+	return append(out, b...)
+}
+
+// sshMpint writes a positive number, with a leading zero byte when the top
+// bit is set so it doesn't read as negative.
+func sshMpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return sshString(b)
+}
+
+// public keys -----------------------------------
+
+// SSHPublicKey is a public key in the ssh wire format, as found in
+// allowed signers files and signatures.
+type SSHPublicKey struct {
+	Type string
+	Blob []byte
+	key  crypto.PublicKey
+}
+
+var sshCurves = map[string]elliptic.Curve{
+	"nistp256": elliptic.P256(),
+	"nistp384": elliptic.P384(),
+	"nistp521": elliptic.P521(),
+}
+
+func ParseSSHPublicKey(blob []byte) (*SSHPublicKey, error) {
+	r := &sshReader{data: blob}
+	k := &SSHPublicKey{Type: r.string(), Blob: blob}
+
+	switch {
+	case k.Type == "ssh-ed25519":
+		pub := r.bytes()
+		if r.err == nil && len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad ed25519 public key")
+		}
+		k.key = ed25519.PublicKey(pub)
+
+	case k.Type == "ssh-rsa":
+		e := r.mpint()
+		n := r.mpint()
+		if r.err == nil && !e.IsInt64() {
+			return nil, fmt.Errorf("bad rsa public key")
+		}
+		k.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case strings.HasPrefix(k.Type, "ecdsa-sha2-"):
+		curve, ok := sshCurves[r.string()]
+		point := r.bytes()
+		if !ok {
+			return nil, fmt.Errorf("unsupported ecdsa curve in %v", k.Type)
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if r.err == nil && x == nil {
+			return nil, fmt.Errorf("bad ecdsa public key")
+		}
+		k.key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", k.Type)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return k, nil
+}
+
+// ParseAuthorizedKey reads a key the way .pub files and allowed signers
+// files write it: "ssh-ed25519 AAAA... comment".
+func ParseAuthorizedKey(line string) (*SSHPublicKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("not an ssh public key: %v", line)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("not an ssh public key: %v", line)
+	}
+	key, err := ParseSSHPublicKey(blob)
+	if err != nil {
+		return nil, err
+	}
+	if key.Type != fields[0] {
+		return nil, fmt.Errorf("key type mismatch: %v and %v", fields[0], key.Type)
+	}
+	return key, nil
+}
+
+func (k *SSHPublicKey) Equal(other *SSHPublicKey) bool {
+	return bytes.Equal(k.Blob, other.Blob)
+}
+
+// Fingerprint is the SHA256:... form ssh-keygen -l shows.
+func (k *SSHPublicKey) Fingerprint() string {
+	sum := sha256.Sum256(k.Blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Kind is the short key type name, like ED25519 or RSA.
+func (k *SSHPublicKey) Kind() string {
+	switch k.key.(type) {
+	case ed25519.PublicKey:
+		return "ED25519"
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "ECDSA"
+	}
+	return strings.ToUpper(k.Type)
+}
+
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve.Params().BitSize {
+	case 256:
+		return crypto.SHA256
+	case 384:
+		return crypto.SHA384
+	}
+	return crypto.SHA512
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	hash := h.New()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// verify checks an ssh signature, string(algorithm) string(blob), over data.
+func (k *SSHPublicKey) verify(data []byte, signature []byte) error {
+	r := &sshReader{data: signature}
+	algorithm := r.string()
+	blob := r.bytes()
+	if r.err != nil {
+		return r.err
+	}
+
+	switch key := k.key.(type) {
+	case ed25519.PublicKey:
+		if algorithm != "ssh-ed25519" || !ed25519.Verify(key, data, blob) {
+			return fmt.Errorf("incorrect signature")
+		}
+
+	case *rsa.PublicKey:
+		// sshsig rules out the sha1 based ssh-rsa
+		hashes := map[string]crypto.Hash{"rsa-sha2-256": crypto.SHA256, "rsa-sha2-512": crypto.SHA512}
+		h, ok := hashes[algorithm]
+		if !ok {
+			return fmt.Errorf("unsupported signature algorithm %v", algorithm)
+		}
+		if rsa.VerifyPKCS1v15(key, h, digest(h, data), blob) != nil {
+			return fmt.Errorf("incorrect signature")
+		}
+
+	case *ecdsa.PublicKey:
+		if algorithm != k.Type {
+			return fmt.Errorf("unsupported signature algorithm %v", algorithm)
+		}
+		rs := &sshReader{data: blob}
+		sigR, sigS := rs.mpint(), rs.mpint()
+		if rs.err != nil {
+			return rs.err
+		}
+		if !ecdsa.Verify(key, digest(ecdsaHash(key.Curve), data), sigR, sigS) {
+			return fmt.Errorf("incorrect signature")
+		}
+
+	default:
+		return fmt.Errorf("unsupported key type %v", k.Type)
+	}
+	return nil
+}
+
+// private keys -----------------------------------
+
+// SSHSigner signs with a private key read from an OpenSSH or PEM key file.
+type SSHSigner struct {
+	Public *SSHPublicKey
+	key    crypto.Signer
+}
+
+func newSSHSigner(key crypto.Signer) (*SSHSigner, error) {
+	var blob []byte
+
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		blob = append(sshString([]byte("ssh-ed25519")), sshString(pub)...)
+	case *rsa.PublicKey:
+		blob = append(sshString([]byte("ssh-rsa")), sshMpint(big.NewInt(int64(pub.E)))...)
+		blob = append(blob, sshMpint(pub.N)...)
+	case *ecdsa.PublicKey:
+		var curve string
+		for name, c := range sshCurves {
+			if c == pub.Curve {
+				curve = name
+			}
+		}
+		if curve == "" {
+			return nil, fmt.Errorf("unsupported ecdsa curve")
+		}
+		blob = append(sshString([]byte("ecdsa-sha2-"+curve)), sshString([]byte(curve))...)
+		blob = append(blob, sshString(elliptic.Marshal(pub.Curve, pub.X, pub.Y))...)
+	default:
+		return nil, fmt.Errorf("unsupported private key type")
+	}
+
+	public, err := ParseSSHPublicKey(blob)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHSigner{Public: public, key: key}, nil
+}
+
+// ParseSSHPrivateKey reads an unencrypted private key, in the OpenSSH
+// format ssh-keygen writes or as PKCS#1, PKCS#8 or SEC 1 PEM.
+func ParseSSHPrivateKey(data []byte) (*SSHSigner, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+		return nil, fmt.Errorf("encrypted private keys are not supported")
+	}
+
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		key, err := parseOpenSSHPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSSHSigner(key)
+
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSSHSigner(key)
+
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSSHSigner(key)
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type")
+		}
+		return newSSHSigner(signer)
+	}
+
+	return nil, fmt.Errorf("unsupported private key type %v", block.Type)
+}
+
+// parseOpenSSHPrivateKey reads the openssh-key-v1 format (PROTOCOL.key).
+func parseOpenSSHPrivateKey(data []byte) (crypto.Signer, error) {
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, fmt.Errorf("bad openssh private key")
+	}
+
+	r := &sshReader{data: data[len(magic):]}
+	cipher := r.string()
+	r.string() // kdf name
+	r.bytes()  // kdf options
+	if r.uint32() != 1 {
+		return nil, fmt.Errorf("only openssh key files holding one key are supported")
+	}
+	r.bytes() // public key, repeated in the private section
+	private := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if cipher != "none" {
+		return nil, fmt.Errorf("encrypted private keys are not supported, remove the passphrase with ssh-keygen -p")
+	}
+
+	r = &sshReader{data: private}
+	if r.uint32() != r.uint32() {
+		return nil, fmt.Errorf("bad openssh private key")
+	}
+
+	var key crypto.Signer
+	switch keyType := r.string(); {
+	case keyType == "ssh-ed25519":
+		r.bytes() // public half
+		priv := r.bytes()
+		if r.err == nil && len(priv) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("bad ed25519 private key")
+		}
+		key = ed25519.PrivateKey(priv)
+
+	case keyType == "ssh-rsa":
+		n, e, d := r.mpint(), r.mpint(), r.mpint()
+		r.mpint() // iqmp, computed again by Precompute
+		p, q := r.mpint(), r.mpint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		rsaKey := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		rsaKey.Precompute()
+		key = rsaKey
+
+	case strings.HasPrefix(keyType, "ecdsa-sha2-"):
+		curve, ok := sshCurves[r.string()]
+		point := r.bytes()
+		d := r.mpint()
+		if !ok {
+			return nil, fmt.Errorf("unsupported ecdsa curve in %v", keyType)
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if r.err == nil && x == nil {
+			return nil, fmt.Errorf("bad ecdsa private key")
+		}
+		key = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", keyType)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return key, nil
+}
+
+// sign makes an ssh signature, string(algorithm) string(blob), over data.
+func (s *SSHSigner) sign(data []byte) ([]byte, error) {
+	var algorithm string
+	var blob []byte
+	var err error
+
+	switch key := s.key.(type) {
+	case ed25519.PrivateKey:
+		algorithm = "ssh-ed25519"
+		blob = ed25519.Sign(key, data)
+
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha2-512"
+		blob, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA512, digest(crypto.SHA512, data))
+
+	case *ecdsa.PrivateKey:
+		algorithm = s.Public.Type
+		var sigR, sigS *big.Int
+		sigR, sigS, err = ecdsa.Sign(rand.Reader, key, digest(ecdsaHash(key.Curve), data))
+		if err == nil {
+			blob = append(sshMpint(sigR), sshMpint(sigS)...)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported private key type")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return append(sshString([]byte(algorithm)), sshString(blob)...), nil
+}
+
+// signatures -----------------------------------
+
+func sshsigSignedData(namespace string, hashAlgorithm string, hash []byte) []byte {
+	data := []byte(sshsigMagic)
+	data = append(data, sshString([]byte(namespace))...)
+	data = append(data, sshString(nil)...)
+	data = append(data, sshString([]byte(hashAlgorithm))...)
+	return append(data, sshString(hash)...)
+}
+
+// SSHSign signs message for namespace, giving the armored signature
+// ssh-keygen -Y sign would.
+func SSHSign(signer *SSHSigner, message []byte, namespace string) (string, error) {
+	hash := sha512.Sum512(message)
+	signature, err := signer.sign(sshsigSignedData(namespace, "sha512", hash[:]))
+	if err != nil {
+		return "", err
+	}
+
+	blob := []byte(sshsigMagic)
+	blob = binary.BigEndian.AppendUint32(blob, sshsigVersion)
+	blob = append(blob, sshString(signer.Public.Blob)...)
+	blob = append(blob, sshString([]byte(namespace))...)
+	blob = append(blob, sshString(nil)...)
+	blob = append(blob, sshString([]byte("sha512"))...)
+	blob = append(blob, sshString(signature)...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var b strings.Builder
+	b.WriteString(sshsigBegin + "\n")
+	for len(encoded) > 70 {
+		b.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString(sshsigEnd + "\n")
+	return b.String(), nil
+}
+
+// SSHVerify checks an armored signature over message, returning the key
+// that made it. whether that key may sign is up to the caller.
+func SSHVerify(armored string, message []byte, namespace string) (*SSHPublicKey, error) {
+	armored = strings.TrimSpace(armored)
+	body, ok := strings.CutPrefix(armored, sshsigBegin)
+	if ok {
+		body, ok = strings.CutSuffix(body, sshsigEnd)
+	}
+	if !ok {
+		return nil, fmt.Errorf("not an ssh signature")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte(sshsigMagic)) {
+		return nil, fmt.Errorf("not an ssh signature")
+	}
+
+	r := &sshReader{data: blob[len(sshsigMagic):]}
+	version := r.uint32()
+	keyBlob := r.bytes()
+	sigNamespace := r.string()
+	r.bytes() // reserved
+	hashAlgorithm := r.string()
+	signature := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if version != sshsigVersion {
+		return nil, fmt.Errorf("unsupported signature version %v", version)
+	}
+	if sigNamespace != namespace {
+		return nil, fmt.Errorf("signature is for namespace \"%v\", not \"%v\"", sigNamespace, namespace)
+	}
+
+	var hash []byte
+	switch hashAlgorithm {
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hash = sum[:]
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hash = sum[:]
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %v", hashAlgorithm)
+	}
+
+	key, err := ParseSSHPublicKey(keyBlob)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.verify(sshsigSignedData(namespace, hashAlgorithm, hash), signature); err != nil {
+		return key, err
+	}
+	return key, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+)
+
+// StashRef is where the newest stash is, the older ones being its reflog.
+const StashRef = "refs/stash"
+
+// StashEntries lists the stashes newest first, so that entry n is
+// stash@{n}.
+func StashEntries(repo Repo) ([]ReflogEntry, error) {
+	if ResolveRef(repo, StashRef) == "" {
+		return nil, nil
+	}
+	entries, err := ReflogRead(repo, StashRef)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// StashDrop removes stash@{n} and gives the commit it was. the entries
+// after it are rewritten to follow on from the one before, and refs/stash
+// moves to the newest one left, or goes when none is. it fails if another
+// stash is pushed or dropped in the meantime.
+func StashDrop(repo Repo, n int) (string, error) {
+	current := ResolveRef(repo, StashRef)
+	if current == "" {
+		return "", fmt.Errorf("no stash entries found")
+	}
+	entries, err := ReflogRead(repo, StashRef)
+	if err != nil {
+		return "", err
+	}
+	pos := len(entries) - 1 - n
+	if n < 0 || pos < 0 {
+		return "", fmt.Errorf("stash@{%d} is not a valid reference", n)
+	}
+	dropped := entries[pos]
+
+	if pos+1 < len(entries) {
+		entries[pos+1].Old = dropped.Old
+	}
+	entries = append(entries[:pos], entries[pos+1:]...)
+
+	t := NewRefTransaction(repo)
+	if len(entries) == 0 {
+		t.Delete(StashRef, current, "")
+	} else {
+		u := t.Update(StashRef, entries[len(entries)-1].New, current, "")
+		u.Reflog = entries
+	}
+	if err := t.Commit(); err != nil {
+		return "", err
+	}
+	return dropped.New, nil
+}
+
+// StashClear forgets all the stashes.
+func StashClear(repo Repo) error {
+	current := ResolveRef(repo, StashRef)
+	if current == "" {
+		ReflogDelete(repo, StashRef)
+		return nil
+	}
+
+	t := NewRefTransaction(repo)
+	t.Delete(StashRef, current, "")
+	return t.Commit()
+}
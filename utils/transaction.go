@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RefUpdate is one change queued in a RefTransaction. Old is the value the
+// ref must have for the transaction to go ahead: "" skips the check and
+// ZeroSha means the ref must not exist yet.
+type RefUpdate struct {
+	Name    string
+	New     string
+	Old     string
+	Delete  bool
+	Verify  bool // only check Old, don't touch the ref
+	NoDeref bool // update a symbolic ref itself rather than what it points at
+	Message string
+	Reflog  []ReflogEntry // when set, replaces the reflog instead of adding Message to it
+
+	ref  string // Name with symbolic refs followed
+	lock string
+	prev string // value found while locked
+}
+
+// RefTransaction updates several refs at once: every ref is locked and
+// checked against its expected old value before any of them is changed,
+// so either all updates happen or none.
+type RefTransaction struct {
+	repo    Repo
+	updates []*RefUpdate
+	packed  map[string]PackedRef // set when deletions rewrite packed-refs
+	locked  []string
+}
+
+func NewRefTransaction(repo Repo) *RefTransaction {
+	return &RefTransaction{repo: repo}
+}
+
+// Update points name at sha, provided it currently is at old.
+func (t *RefTransaction) Update(name string, sha string, old string, message string) *RefUpdate {
+	u := &RefUpdate{Name: name, New: sha, Old: old, Message: message}
+	t.updates = append(t.updates, u)
+	return u
+}
+
+// Create adds a ref that must not exist yet.
+func (t *RefTransaction) Create(name string, sha string, message string) *RefUpdate {
+	return t.Update(name, sha, ZeroSha, message)
+}
+
+// Delete removes name, loose and packed, provided it currently is at old.
+func (t *RefTransaction) Delete(name string, old string, message string) *RefUpdate {
+	u := &RefUpdate{Name: name, Old: old, Delete: true, Message: message}
+	t.updates = append(t.updates, u)
+	return u
+}
+
+// Verify only checks that name is at old.
+func (t *RefTransaction) Verify(name string, old string) *RefUpdate {
+	u := &RefUpdate{Name: name, Old: old, Verify: true}
+	t.updates = append(t.updates, u)
+	return u
+}
+
+// lockFile takes the lock on path by creating path.lock, which fails when
+// someone else holds it.
+func (t *RefTransaction) lockFile(path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	lock := path + ".lock"
+	file, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	t.locked = append(t.locked, lock)
+	return lock, nil
+}
+
+// Abort releases the locks of a transaction that won't be committed.
+func (t *RefTransaction) Abort() {
+	for _, lock := range t.locked {
+		os.Remove(lock)
+	}
+	t.locked = nil
+}
+
+// prepare locks every ref and checks the old values, leaving the new values
+// written to the lock files.
+func (t *RefTransaction) prepare() error {
+	seen := make(map[string]bool)
+
+	for _, u := range t.updates {
+		if !CheckRefFormat(u.Name) {
+			return fmt.Errorf("invalid ref name: %v", u.Name)
+		}
+
+		u.ref = u.Name
+		if !u.NoDeref {
+			u.ref = derefName(t.repo, u.Name)
+		}
+		if seen[u.ref] {
+			return fmt.Errorf("multiple updates for ref '%v' not allowed", u.ref)
+		}
+		seen[u.ref] = true
+
+		lock, err := t.lockFile(looseRefPath(t.repo, u.ref))
+		if err != nil {
+			return fmt.Errorf("cannot lock ref '%v': %w", u.ref, err)
+		}
+		u.lock = lock
+
+		// read only once we hold the lock, so nobody moves it underneath us
+		u.prev = ""
+		if ref, err := ReadRef(t.repo, u.ref); err == nil && !ref.Symbolic {
+			u.prev = ref.Target
+		}
+
+		switch {
+		case u.Old == "":
+		case u.Old == ZeroSha && u.prev != "":
+			return fmt.Errorf("cannot lock ref '%v': reference already exists", u.ref)
+		case u.Old != ZeroSha && u.prev == "":
+			return fmt.Errorf("cannot lock ref '%v': unable to resolve reference", u.ref)
+		case u.Old != ZeroSha && u.prev != u.Old:
+			return fmt.Errorf("cannot lock ref '%v': is at %v but expected %v", u.ref, u.prev, u.Old)
+		}
+
+		if u.Verify {
+			continue
+		}
+		if u.Delete {
+			if u.prev == "" && u.Old == "" {
+				return fmt.Errorf("cannot delete ref '%v': it does not exist", u.ref)
+			}
+			continue
+		}
+
+		if err := os.WriteFile(u.lock, []byte(u.New+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	return t.preparePacked()
+}
+
+// preparePacked locks packed-refs when a deleted ref lives there, and
+// writes out the result without those refs.
+func (t *RefTransaction) preparePacked() error {
+	packed, err := PackedRefsRead(t.repo)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, u := range t.updates {
+		if _, ok := packed[u.ref]; ok && u.Delete {
+			delete(packed, u.ref)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	lock, err := t.lockFile(repoPath(t.repo, "packed-refs"))
+	if err != nil {
+		return fmt.Errorf("unable to lock packed-refs: %w", err)
+	}
+	if err := os.WriteFile(lock, []byte(packedRefsFormat(packed)), 0644); err != nil {
+		return err
+	}
+
+	t.packed = packed
+	return nil
+}
+
+// Commit applies the transaction, or changes nothing when a ref can't be
+// locked or isn't at its expected value.
+func (t *RefTransaction) Commit() error {
+	if err := t.prepare(); err != nil {
+		t.Abort()
+		return err
+	}
+
+	// the hook gets to veto the transaction once every ref is locked
+	if err := t.runTransactionHook("prepared"); err != nil {
+		t.Abort()
+		t.runTransactionHook("aborted")
+		return fmt.Errorf("in 'prepared' phase, update aborted by the reference-transaction hook")
+	}
+
+	// from here on the locks get renamed into place or removed one by one
+	defer t.Abort()
+
+	head := HeadBranch(t.repo)
+
+	for _, u := range t.updates {
+		path := looseRefPath(t.repo, u.ref)
+
+		switch {
+		case u.Verify:
+			os.Remove(u.lock)
+
+		case u.Delete:
+			os.Remove(path)
+			os.Remove(u.lock)
+			removeEmptyRefDirs(t.repo, path)
+			ReflogDelete(t.repo, u.ref)
+
+		default:
+			// the reflog is written while the ref is still locked
+			var err error
+			if u.Reflog != nil {
+				err = ReflogWrite(t.repo, u.ref, u.Reflog)
+			} else {
+				err = ReflogAppend(t.repo, u.ref, u.prev, u.New, u.Message)
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Rename(u.lock, path); err != nil {
+				return err
+			}
+
+			// moving the checked out branch shows in HEAD's reflog too
+			if u.ref != "HEAD" && u.ref == head {
+				if err := ReflogAppend(t.repo, "HEAD", u.prev, u.New, u.Message); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if t.packed != nil {
+		if err := packedRefsCommit(t.repo, t.packed); err != nil {
+			return err
+		}
+	}
+
+	t.locked = nil
+	t.runTransactionHook("committed")
+	return nil
+}
+
+// UpdateRef points ref at sha and records it in the reflog. symbolic refs
+// are followed, so updating HEAD moves the checked out branch, and that move
+// is logged in HEAD's reflog as well.
+func UpdateRef(repo Repo, ref string, sha string, message string) error {
+	t := NewRefTransaction(repo)
+	t.Update(ref, sha, "", message)
+	return t.Commit()
+}
+
+// IsFullSha tells whether s is a complete 40 digit hex object name.
+func IsFullSha(s string) bool {
+	return len(s) == 40 && strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
@@ -0,0 +1,69 @@
+package utils
+
+import "testing"
+
+func TestRefTransactionCompareAndSwap(t *testing.T) {
+	repo := testRepo(t)
+	first := testCommit(t, repo, "first\n")
+	second := testCommit(t, repo, "second\n", first)
+
+	create := NewRefTransaction(repo)
+	create.Create("refs/heads/main", first, "create")
+	if err := create.Commit(); err != nil {
+		t.Fatalf("creating a new ref: %v", err)
+	}
+
+	again := NewRefTransaction(repo)
+	again.Create("refs/heads/main", second, "create again")
+	if err := again.Commit(); err == nil {
+		t.Errorf("creating a ref that exists succeeded")
+	}
+
+	stale := NewRefTransaction(repo)
+	stale.Update("refs/heads/main", second, second, "stale")
+	if err := stale.Commit(); err == nil {
+		t.Errorf("updating from a value the ref doesn't have succeeded")
+	}
+	if got := ResolveRef(repo, "refs/heads/main"); got != first {
+		t.Fatalf("main = %v after failed updates, want %v", got, first)
+	}
+
+	// one failing update leaves the others alone
+	batch := NewRefTransaction(repo)
+	batch.Update("refs/heads/main", second, first, "move")
+	batch.Create("refs/heads/side", first, "branch")
+	batch.Verify("refs/heads/missing", first)
+	if err := batch.Commit(); err == nil {
+		t.Errorf("a transaction with a failing verify succeeded")
+	}
+	if got := ResolveRef(repo, "refs/heads/main"); got != first {
+		t.Errorf("main moved to %v in a failed transaction", got)
+	}
+	if got := ResolveRef(repo, "refs/heads/side"); got != "" {
+		t.Errorf("side was created in a failed transaction")
+	}
+
+	swap := NewRefTransaction(repo)
+	swap.Update("refs/heads/main", second, first, "move")
+	swap.Create("refs/heads/side", first, "branch")
+	if err := swap.Commit(); err != nil {
+		t.Fatalf("updating from the right value: %v", err)
+	}
+	if got := ResolveRef(repo, "refs/heads/main"); got != second {
+		t.Errorf("main = %v, want %v", got, second)
+	}
+
+	del := NewRefTransaction(repo)
+	del.Delete("refs/heads/side", second, "delete")
+	if err := del.Commit(); err == nil {
+		t.Errorf("deleting from a value the ref doesn't have succeeded")
+	}
+	del = NewRefTransaction(repo)
+	del.Delete("refs/heads/side", first, "delete")
+	if err := del.Commit(); err != nil {
+		t.Fatalf("deleting from the right value: %v", err)
+	}
+	if got := ResolveRef(repo, "refs/heads/side"); got != "" {
+		t.Errorf("side is still at %v after being deleted", got)
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
 )
 
+// the tree with nothing in it, which an empty index gives
+const EmptyTreeSha = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
 // GitTree ------------------------------------
 
 type GitTree struct {
@@ -20,9 +26,10 @@ func (b *GitTree) Serialize() string {
 	// returns bytes in the form of string NOT READABLE
 }
 
-func (b *GitTree) Deserialize(data string) {
+func (b *GitTree) Deserialize(data string) error {
 	b.Items = ParseTree([]byte(data))
 	b.format = "tree"
+	return nil
 }
 
 // tree leaf node --------------------------------
@@ -86,8 +93,16 @@ func ParseTree(raw []byte) []GitTreeLeaf {
 }
 
 func treeSerialize(obj *GitTree) []byte {
+	// git orders directories as if their name ended with "/"
+	sortKey := func(leaf GitTreeLeaf) []byte {
+		if strings.HasPrefix(leaf.Mode, "4") || strings.HasPrefix(leaf.Mode, "04") {
+			return []byte(leaf.Path + "/")
+		}
+		return []byte(leaf.Path)
+	}
+
 	sort.Slice(obj.Items, func(i, j int) bool {
-		return bytes.Compare([]byte(obj.Items[i].Path), []byte(obj.Items[j].Path)) < 0
+		return bytes.Compare(sortKey(obj.Items[i]), sortKey(obj.Items[j])) < 0
 	})
 	
 	var ret []byte
@@ -104,3 +119,102 @@ func treeSerialize(obj *GitTree) []byte {
 	}
 	return ret
 }
+
+// TreeFromIndex writes the trees for the entries of index, giving the sha
+// of the root one.
+func TreeFromIndex(repo Repo, index GitIndex) string {
+	// index names always use "/", whatever the platform
+	contents := map[string][]GitTreeLeaf{".": nil}
+
+	for _, entry := range index.Entries {
+		dirname := path.Dir(entry.Name)
+
+		// every parent directory needs a tree, even without files of its own
+		for key := dirname; key != "."; key = path.Dir(key) {
+			if _, ok := contents[key]; !ok {
+				contents[key] = nil
+			}
+		}
+
+		leafMode := fmt.Sprintf("%02o%04o", entry.ModeType, entry.ModePerms)
+		contents[dirname] = append(contents[dirname], GitTreeLeaf{
+			Mode: leafMode,
+			Path: path.Base(entry.Name),
+			Sha: entry.SHA,
+		})
+	}
+
+	depth := func(dir string) int {
+		if dir == "." {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+
+	sortedPaths := make([]string, 0, len(contents))
+	for k := range contents {
+		sortedPaths = append(sortedPaths, k)
+	}
+
+	// deepest directories first, so subtrees exist before their parents
+	sort.Slice(sortedPaths, func(i, j int) bool {
+		return depth(sortedPaths[i]) > depth(sortedPaths[j])
+	})
+
+	var sha string
+	for _, dir := range sortedPaths {
+		tree := GitTree{Items: contents[dir]}
+		sha = ObjectWrite(&tree, repo)
+
+		if dir != "." {
+			parent := path.Dir(dir)
+			contents[parent] = append(contents[parent], GitTreeLeaf{
+				Mode: "40000",
+				Path: path.Base(dir),
+				Sha: sha,
+			})
+		}
+	}
+
+	// the root comes last
+	return sha
+}
+
+// TreeIndexEntries lists the blobs of a tree as index entries, with no
+// stat data, the way they are before being checked out.
+func TreeIndexEntries(repo Repo, sha string) ([]GitIndexEntry, error) {
+	return treeIndexEntries(repo, sha, "")
+}
+
+func treeIndexEntries(repo Repo, sha string, prefix string) ([]GitIndexEntry, error) {
+	tree, ok := ObjectRead(repo, sha).(*GitTree)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a tree", sha)
+	}
+
+	var entries []GitIndexEntry
+	for _, leaf := range tree.Items {
+		name := path.Join(prefix, leaf.Path)
+		mode, err := strconv.ParseUint(leaf.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad mode %v in tree %v", leaf.Mode, sha)
+		}
+
+		if mode == 0o40000 {
+			sub, err := treeIndexEntries(repo, leaf.Sha, name)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, sub...)
+			continue
+		}
+
+		entries = append(entries, GitIndexEntry{
+			ModeType:  uint16(mode >> 12),
+			ModePerms: uint16(mode & 0o7777),
+			SHA:       leaf.Sha,
+			Name:      name,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// the untracked cache remembers, for every directory of the worktree, its
+// mtime and the files and subdirectories found in it. a directory whose
+// mtime hasn't moved since doesn't have to be read or matched against the
+// ignore rules again. it is kept in the UNTC index extension, which is
+// wannagit's own layout and not git's UNTR one.
+//
+// UNTC: uint32 version, 20 byte hash of the ignore rules, uint32 dir count,
+// then per directory: path NUL, mtime (uint32 s, uint32 ns), uint8 flags,
+// and the files, ignored files and subdirectories as counted lists of
+// NUL terminated names.
+
+type UntrackedCacheDir struct {
+	Mtime   [2]uint32
+	Files   []string // files not ignored, tracked or not
+	Ignored []string
+	Dirs    []string
+	Valid   bool // fsmonitor reported nothing below, no need to even stat it
+}
+
+type UntrackedCache struct {
+	IgnoreHash string
+	Dirs       map[string]*UntrackedCacheDir // keyed by path relative to the worktree, "." for the root
+}
+
+func NewUntrackedCache(ignoreHash string) *UntrackedCache {
+	return &UntrackedCache{
+		IgnoreHash: ignoreHash,
+		Dirs:       make(map[string]*UntrackedCacheDir),
+	}
+}
+
+func UntrackedCacheEnabled(repo Repo) bool {
+	return ConfigGetBool(repo, "core", "untrackedCache")
+}
+
+// Invalidate drops the fsmonitor validity of a directory.
+func (c *UntrackedCache) Invalidate(dir string) {
+	if d, ok := c.Dirs[dir]; ok {
+		d.Valid = false
+	}
+}
+
+func (c *UntrackedCache) InvalidateAll() {
+	for _, d := range c.Dirs {
+		d.Valid = false
+	}
+}
+
+// index extension ---------------------------------
+
+func untrackedReadExtension(index *GitIndex, data []byte) error {
+	malformed := fmt.Errorf("malformed untracked cache extension")
+
+	if len(data) < 4+20+4 || binary.BigEndian.Uint32(data[:4]) != 1 {
+		return fmt.Errorf("unsupported untracked cache extension version")
+	}
+
+	cache := NewUntrackedCache(hex.EncodeToString(data[4:24]))
+	count := int(binary.BigEndian.Uint32(data[24:28]))
+	pos := 28
+
+	readName := func() (string, bool) {
+		nullIdx := bytes.IndexByte(data[pos:], 0)
+		if nullIdx == -1 {
+			return "", false
+		}
+		name := string(data[pos : pos+nullIdx])
+		pos += nullIdx + 1
+		return name, true
+	}
+
+	readList := func() ([]string, bool) {
+		if pos+4 > len(data) {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+
+		list := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			name, ok := readName()
+			if !ok {
+				return nil, false
+			}
+			list = append(list, name)
+		}
+		return list, true
+	}
+
+	for i := 0; i < count; i++ {
+		path, ok := readName()
+		if !ok || pos+9 > len(data) {
+			return malformed
+		}
+
+		dir := &UntrackedCacheDir{
+			Mtime: [2]uint32{binary.BigEndian.Uint32(data[pos:]), binary.BigEndian.Uint32(data[pos+4:])},
+			Valid: data[pos+8]&1 != 0,
+		}
+		pos += 9
+
+		if dir.Files, ok = readList(); !ok {
+			return malformed
+		}
+		if dir.Ignored, ok = readList(); !ok {
+			return malformed
+		}
+		if dir.Dirs, ok = readList(); !ok {
+			return malformed
+		}
+
+		cache.Dirs[path] = dir
+	}
+
+	index.Untracked = cache
+	return nil
+}
+
+func untrackedWriteExtension(cache *UntrackedCache) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+
+	hash, err := hex.DecodeString(cache.IgnoreHash)
+	if err != nil || len(hash) != 20 {
+		hash = make([]byte, 20)
+	}
+	buf.Write(hash)
+
+	paths := make([]string, 0, len(cache.Dirs))
+	for p := range cache.Dirs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(paths)))
+
+	writeList := func(list []string) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(list)))
+		for _, name := range list {
+			buf.WriteString(name)
+			buf.WriteByte(0)
+		}
+	}
+
+	for _, p := range paths {
+		dir := cache.Dirs[p]
+		buf.WriteString(p)
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.BigEndian, dir.Mtime[0])
+		binary.Write(&buf, binary.BigEndian, dir.Mtime[1])
+
+		var flags uint8
+		if dir.Valid {
+			flags |= 1
+		}
+		buf.WriteByte(flags)
+
+		writeList(dir.Files)
+		writeList(dir.Ignored)
+		writeList(dir.Dirs)
+	}
+
+	return buf.Bytes()
+}
@@ -15,7 +15,7 @@ type Repo struct {
 
 type GitObject interface {
 	Serialize() string
-	Deserialize(data string)
+	Deserialize(data string) error
 	Format() string
 }
 
@@ -39,9 +39,10 @@ func (b *GitBlob) Serialize() string {
 	return b.data
 }
 
-func (b *GitBlob) Deserialize(data string) {
+func (b *GitBlob) Deserialize(data string) error {
 	b.data = data
 	b.format = "blob"
+	return nil
 }
 
 // GitTag ----------------------------------------
@@ -50,20 +51,29 @@ type GitTag struct {
 	GitCommit
 }
 
-func (b *GitTag) Deserialize(data string) {
-	b.Data = ParseKVLM([]byte(data))
+func (b *GitTag) Serialize() string {
 	b.format = "tag"
+	return string(SerializeKVLM(b.Data))
 }
 
-func (b *GitTag) GetData() map[string][]string {
-	return b.Data
+func (b *GitTag) Deserialize(data string) error {
+	var err error
+	b.Data, err = ParseKVLM([]byte(data))
+	b.format = "tag"
+	return err
+}
+
+func (b *GitTag) GetData() *KVLM {
+	return &b.Data
 }
 
 // GitIndexEntry and GitIndex ----------------------------------
 
 type GitIndex struct {
-	Version 	uint32
-	Entries 	[]GitIndexEntry
+	Version 		uint32
+	Entries 		[]GitIndexEntry
+	FsmonitorToken 	string // token of the last fsmonitor query, stored in the FSMN extension
+	Untracked 		*UntrackedCache // stored in the UNTC extension
 }
 
 type GitIndexEntry struct {
@@ -80,6 +90,7 @@ type GitIndexEntry struct {
 	AssumeValid      bool
 	Stage            uint16
 	Name             string // full path of this object (name)
+	FsmonitorValid   bool // unchanged since FsmonitorToken, kept in the FSMN extension
 }
 
 // GitIgnore and Rule --------------------------------------
@@ -99,7 +110,7 @@ type GitIgnore struct {
 func RepoFind(path string, required bool) Repo {
 	path, _ = filepath.Abs(path)
 
-	if stat, _ := os.Stat(filepath.Join(path, ".wannagit")); stat.IsDir() {
+	if stat, err := os.Stat(filepath.Join(path, ".wannagit")); err == nil && stat.IsDir() {
 		return Repo {
 			Worktree: path,
 			Gitdir: filepath.Join(path, ".wannagit"),
@@ -114,6 +125,7 @@ func RepoFind(path string, required bool) Repo {
 		// /.. --> / still root
 		if required {
 			fmt.Print("No git Directory\n")
+			os.Exit(128)
 		} else {
 			return Repo {}
 		}
@@ -167,7 +179,10 @@ func ResolveRef(repo Repo, ref string) string {
 	
 	stat, err := os.Stat(path)
 	if err != nil || !stat.Mode().IsRegular() {
-		return ""
+		// not a loose ref, maybe it was packed
+		packed, err := PackedRefsRead(repo)
+		ErrorHandler("couldn't read packed-refs", err)
+		return packed[ref].Sha
 	}
 
 	dataSlice, err := os.ReadFile(path)
@@ -182,8 +197,32 @@ func ResolveRef(repo Repo, ref string) string {
 	return data
 }
 
+// CheckRefFormat tells whether name is usable as a ref name, following the
+// rules of git check-ref-format.
+func CheckRefFormat(name string) bool {
+	if name == "" || name == "@" || strings.HasPrefix(name, "-") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "//") {
+		return false
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
 func ErrorHandler(customMsg string, err error) {
 	if err != nil {
-		fmt.Printf(customMsg + "\nerror: ", err)
+		fmt.Printf("%s\nerror: %v\n", customMsg, err)
 	}
 }